@@ -2,7 +2,11 @@ package config
 
 import (
 	"fmt"
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
+	"math"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -11,37 +15,237 @@ type Config struct {
 	BaseUrl   string
 	AssKey    string
 	SecretKey string
-	Symbols   []map[string]string
+	//单独存放api key的文件，权限需要是600
+	KeyFile string
+	//加密的keystore文件，口令从环境变量QT_KEYSTORE_PASSPHRASE读取
+	Keystore      string
+	KeystoreEntry string
+	Symbols       []*SymbolConfig
+	//live 实盘，paper 模拟盘
+	Mode          string
+	PaperFee      string
+	PaperBalances map[string]string
+	//订单日志文件，为空时不记录
+	JournalPath string
+	//退出时等待撤单的秒数
+	ShutdownTimeout int
+	//管理接口的监听地址，如127.0.0.1:8090，为空时不开启
+	AdminAddr string
+	//管理接口修改类请求的token，可以用环境变量QT_ADMIN_TOKEN
+	AdminToken string
+	//Prometheus指标/metrics的监听地址，为空时不开启
+	MetricsAddr string
+	//行情接口和私有接口每秒的请求数和突发数，所有交易对共用，请求数为0时不限频
+	PublicRate   float64
+	PublicBurst  int
+	PrivateRate  float64
+	PrivateBurst int
+	//查询和撤单失败后的重试次数和退避时间，下单不重试
+	RetryMax      int
+	RetryDelay    time.Duration
+	RetryMaxDelay time.Duration
+	//账户余额的刷新间隔，所有交易对共用，为0时每次都查询
+	BalanceRefresh time.Duration
+	//fcoin的websocket行情推送地址，为空时每隔period秒用REST查询深度
+	WsUrl string
+	//深度推送触发的两个周期之间的最小间隔
+	FeedInterval time.Duration
+	//读取的配置文件，Watch时使用
+	file string
 }
 
+const (
+	MODE_LIVE  = "live"
+	MODE_PAPER = "paper"
+	//fcoin限频为每10秒100次
+	DEFAULT_RATE            = 10
+	DEFAULT_RETRY_MAX       = 3
+	DEFAULT_RETRY_DELAY     = 200 * time.Millisecond
+	DEFAULT_RETRY_MAX_DELAY = 5 * time.Second
+	DEFAULT_BALANCE_REFRESH = 5 * time.Second
+	DEFAULT_FEED_INTERVAL   = time.Second
+)
+
+//InitConfig 读取配置，配置有错误时panic
 func InitConfig(cfgName, cfgPath string) *Config {
-	viper.SetConfigName(cfgName)
-	viper.AddConfigPath(cfgPath)
-	err := viper.ReadInConfig()
+	cfg, err := LoadConfig(cfgName, cfgPath)
 	if err != nil {
 		panic(fmt.Errorf("Fatal error config file: %s \n", err))
 	}
+	return cfg
+}
 
-	ss := make([]map[string]string, 0, 8)
+//LoadConfig 读取并校验配置，每个交易对的所有错误一起返回
+func LoadConfig(cfgName, cfgPath string) (*Config, error) {
+	v := viper.New()
+	v.SetConfigName(cfgName)
+	v.AddConfigPath(cfgPath)
+	return readConfig(v, nil)
+}
 
-	symbols := viper.Get("symbols")
+//LoadConfigFile 读取并校验指定的配置文件
+func LoadConfigFile(file string) (*Config, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	return readConfig(v, nil)
+}
 
-	for _, val := range symbols.([]interface{}) {
-		maps := make(map[string]string)
-		for k, v := range val.(map[interface{}]interface{}) {
-			maps[k.(string)] = v.(string)
-		}
-		ss = append(ss, maps)
+//readConfig running不为nil时是重新读取，api key和管理接口的token沿用running的，
+//不再读取keystore：解密很慢，而且运行中可能已经没有口令的环境变量
+func readConfig(v *viper.Viper, running *Config) (*Config, error) {
+	err := v.ReadInConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	ss, err := ParseSymbols(v.Get("symbols"))
+	if err != nil {
+		return nil, err
 	}
 
 	cfg := &Config{
-		LogPath:   viper.GetString("logPath"),
-		LogLevel:  viper.GetString("logLevel"),
-		BaseUrl:   viper.GetString("baseUrl"),
-		AssKey:    viper.GetString("assKey"),
-		SecretKey: viper.GetString("secretKey"),
+		LogPath:   v.GetString("logPath"),
+		LogLevel:  v.GetString("logLevel"),
+		BaseUrl:   v.GetString("baseUrl"),
+		AssKey:    v.GetString("assKey"),
+		SecretKey: v.GetString("secretKey"),
 		Symbols:   ss,
+		Mode:      v.GetString("mode"),
+		PaperFee:  v.GetString("paperFee"),
+		//模拟盘的初始余额，如usdt: "100"
+		PaperBalances:   v.GetStringMapString("paperBalances"),
+		JournalPath:     v.GetString("journalPath"),
+		ShutdownTimeout: v.GetInt("shutdownTimeout"),
+		KeyFile:         v.GetString("keyFile"),
+		Keystore:        v.GetString("keystore"),
+		KeystoreEntry:   v.GetString("keystoreEntry"),
+		AdminAddr:       v.GetString("adminAddr"),
+		AdminToken:      v.GetString("adminToken"),
+		MetricsAddr:     v.GetString("metricsAddr"),
+		PublicRate:      DEFAULT_RATE,
+		PublicBurst:     v.GetInt("publicBurst"),
+		PrivateRate:     DEFAULT_RATE,
+		PrivateBurst:    v.GetInt("privateBurst"),
+		RetryMax:        DEFAULT_RETRY_MAX,
+		RetryDelay:      v.GetDuration("retryDelay"),
+		RetryMaxDelay:   v.GetDuration("retryMaxDelay"),
+		BalanceRefresh:  DEFAULT_BALANCE_REFRESH,
+		WsUrl:           v.GetString("wsUrl"),
+		FeedInterval:    DEFAULT_FEED_INTERVAL,
+		file:            v.ConfigFileUsed(),
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = MODE_LIVE
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = 10
+	}
+	if cfg.PaperFee == "" {
+		cfg.PaperFee = "0.001"
+	}
+	if cfg.Mode != MODE_LIVE && cfg.Mode != MODE_PAPER {
+		return nil, fmt.Errorf("mode must be %s or %s,got %q", MODE_LIVE, MODE_PAPER, cfg.Mode)
+	}
+	if v.IsSet("publicRate") {
+		cfg.PublicRate = v.GetFloat64("publicRate")
+	}
+	if v.IsSet("privateRate") {
+		cfg.PrivateRate = v.GetFloat64("privateRate")
+	}
+	if cfg.PublicRate < 0 || cfg.PrivateRate < 0 {
+		return nil, fmt.Errorf("publicRate and privateRate must not be negative")
+	}
+	if cfg.PublicBurst <= 0 {
+		cfg.PublicBurst = int(math.Ceil(cfg.PublicRate))
+	}
+	if cfg.PrivateBurst <= 0 {
+		cfg.PrivateBurst = int(math.Ceil(cfg.PrivateRate))
+	}
+	if v.IsSet("retryMax") {
+		cfg.RetryMax = v.GetInt("retryMax")
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = DEFAULT_RETRY_DELAY
+	}
+	if cfg.RetryMaxDelay < cfg.RetryDelay {
+		cfg.RetryMaxDelay = DEFAULT_RETRY_MAX_DELAY
+	}
+	if v.IsSet("balanceRefresh") {
+		cfg.BalanceRefresh = v.GetDuration("balanceRefresh")
+	}
+	if v.IsSet("feedInterval") {
+		cfg.FeedInterval = v.GetDuration("feedInterval")
+	}
+	if cfg.KeystoreEntry == "" {
+		cfg.KeystoreEntry = KEYSTORE_ENTRY
+	}
+	if running != nil {
+		cfg.AssKey, cfg.SecretKey, cfg.AdminToken = running.AssKey, running.SecretKey, running.AdminToken
+	} else if err = resolveCredentials(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.AdminAddr != "" && cfg.AdminToken == "" {
+		return nil, fmt.Errorf("adminToken or %s is required when adminAddr is set", ENV_ADMIN_TOKEN)
 	}
 
-	return cfg
+	return cfg, nil
+}
+
+//Watch 监听配置文件，文件修改后重新读取并校验，通过时调用onChange，有错误时调用onError，
+//正在使用的配置不受影响。api key沿用正在使用的。编辑器保存一次可能触发多次onChange
+func (cfg *Config) Watch(onChange func(*Config), onError func(error)) {
+	v := viper.New()
+	v.SetConfigFile(cfg.file)
+	v.OnConfigChange(func(e fsnotify.Event) {
+		nv := viper.New()
+		nv.SetConfigFile(cfg.file)
+		next, err := readConfig(nv, cfg)
+		if err != nil {
+			onError(err)
+			return
+		}
+		onChange(next)
+	})
+	v.WatchConfig()
+}
+
+//ParseSymbols 解析symbols列表，列表中每一项是一个交易对的配置
+func ParseSymbols(symbols interface{}) ([]*SymbolConfig, error) {
+	list, ok := symbols.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("symbols must be a list")
+	}
+	ss := make([]*SymbolConfig, 0, len(list))
+	msgs := make([]string, 0)
+	seen := make(map[string]bool)
+	for i, val := range list {
+		maps := make(map[string]string)
+		if m, ok := val.(map[interface{}]interface{}); ok {
+			for k, v := range m {
+				maps[fmt.Sprint(k)] = fmt.Sprint(v)
+			}
+		} else if m, ok := val.(map[string]interface{}); ok {
+			for k, v := range m {
+				maps[k] = fmt.Sprint(v)
+			}
+		} else {
+			msgs = append(msgs, fmt.Sprintf("symbols[%d] must be a map", i))
+			continue
+		}
+		sc, err := ParseSymbol(i, maps)
+		if err != nil {
+			msgs = append(msgs, err.Error())
+			continue
+		}
+		if seen[sc.Symbol] {
+			msgs = append(msgs, fmt.Sprintf("symbols[%d] %s is configured more than once", i, sc.Symbol))
+			continue
+		}
+		seen[sc.Symbol] = true
+		ss = append(ss, sc)
+	}
+	if len(msgs) > 0 {
+		return nil, fmt.Errorf("invalid symbols:\n%s", strings.Join(msgs, "\n"))
+	}
+	return ss, nil
 }
@@ -2,11 +2,81 @@ package config
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestInitConfig(t *testing.T) {
-	cfg := InitConfig("qt", `C:\Users\zhouyc\gospace\src\github.com\MrChang666\qt\config`)
+	cfg := InitConfig("qt", ".")
 	fmt.Println(cfg.LogLevel)
 	fmt.Println(cfg.Symbols)
+	if cfg.Mode != MODE_LIVE || cfg.PaperBalances["usdt"] != "100" {
+		t.Fatalf("mode:%s,paper balances:%v", cfg.Mode, cfg.PaperBalances)
+	}
+	if cfg.PublicRate != DEFAULT_RATE || cfg.PrivateBurst != DEFAULT_RATE {
+		t.Fatalf("rate limits %v/%d", cfg.PublicRate, cfg.PrivateBurst)
+	}
+	if cfg.BalanceRefresh != DEFAULT_BALANCE_REFRESH {
+		t.Fatalf("balance refresh %v", cfg.BalanceRefresh)
+	}
+	if len(cfg.Symbols) != 2 || cfg.Symbols[1].SellLevel != 12 || cfg.Symbols[1].BySide != "1" {
+		t.Fatalf("symbols:%v", cfg.Symbols)
+	}
+}
+
+func TestConfig_Watch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "qt.yml")
+	symbol := func(s, level string) string {
+		return fmt.Sprintf("  - {symbol: %s, balance: \"50\", minBalance: \"1\", minAsset: \"1\", assetPrecision: \"4\", pricePrecision: \"4\", buyLevel: \"%s\", sellLevel: \"5\"}\n", s, level)
+	}
+	write := func(content string) {
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("symbols:\n" + symbol("paxusdt", "5"))
+	cfg, err := LoadConfigFile(file)
+	if err != nil || len(cfg.Symbols) != 1 {
+		t.Fatalf("load %s,%v", file, err)
+	}
+
+	changes := make(chan *Config, 10)
+	errs := make(chan error, 10)
+	cfg.Watch(func(c *Config) { changes <- c }, func(err error) { errs <- err })
+
+	//越界的档位不生效
+	write("symbols:\n" + symbol("paxusdt", "99"))
+	select {
+	case <-errs:
+	case c := <-changes:
+		t.Fatalf("invalid config should be rejected,%v", c.Symbols)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after write")
+	}
+
+	//重新读取时不解析api key，keystore的口令不在环境变量里也可以
+	write("keystore: " + filepath.Join(dir, "qt.keystore") + "\nsymbols:\n" + symbol("paxusdt", "6") + symbol("eosusdt", "5"))
+	for {
+		select {
+		case c := <-changes:
+			if len(c.Symbols) == 2 && c.Symbols[0].BuyLevel == 6 {
+				if c.AssKey != cfg.AssKey || c.SecretKey != cfg.SecretKey {
+					t.Fatalf("running credentials should be kept,%s", c.AssKey)
+				}
+				return
+			}
+		case <-errs:
+			//编辑器可能分多次写入
+		case <-time.After(5 * time.Second):
+			t.Fatal("valid change should be reloaded")
+		}
+	}
 }
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+const (
+	KEYSTORE_VERSION    = 1
+	KEYSTORE_ITERATIONS = 200000
+	KEYSTORE_ENTRY      = "default"
+)
+
+//Credentials 交易所的api key
+type Credentials struct {
+	AssKey    string `json:"assKey"`
+	SecretKey string `json:"secretKey"`
+}
+
+//keystoreFile 加密后写入文件的内容，密钥由口令经PBKDF2-SHA256得到，用AES-256-GCM加密
+type keystoreFile struct {
+	Version    int    `json:"version"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+//Keystore 用口令加密保存的api key，按名字保存多组
+type Keystore struct {
+	path       string
+	passphrase string
+	entries    map[string]Credentials
+}
+
+//NewKeystore 创建一个空的keystore，Save后写入path
+func NewKeystore(path, passphrase string) *Keystore {
+	return &Keystore{path: path, passphrase: passphrase, entries: make(map[string]Credentials)}
+}
+
+//OpenKeystore 读取并解密keystore文件
+func OpenKeystore(path, passphrase string) (*Keystore, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kf keystoreFile
+	if err = json.Unmarshal(b, &kf); err != nil {
+		return nil, fmt.Errorf("parse keystore %s failed,%v", path, err)
+	}
+	if kf.Version != KEYSTORE_VERSION {
+		return nil, fmt.Errorf("unsupported keystore version %d", kf.Version)
+	}
+	gcm, err := newGCM(passphrase, kf.Salt, kf.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, kf.Nonce, kf.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt keystore %s failed,wrong passphrase or corrupted file", path)
+	}
+	ks := NewKeystore(path, passphrase)
+	if err = json.Unmarshal(plain, &ks.entries); err != nil {
+		return nil, fmt.Errorf("parse keystore %s failed,%v", path, err)
+	}
+	return ks, nil
+}
+
+func (ks *Keystore) Get(name string) (Credentials, bool) {
+	c, ok := ks.entries[name]
+	return c, ok
+}
+
+//Set 新增或替换一组api key
+func (ks *Keystore) Set(name string, c Credentials) {
+	ks.entries[name] = c
+}
+
+func (ks *Keystore) Delete(name string) bool {
+	_, ok := ks.entries[name]
+	delete(ks.entries, name)
+	return ok
+}
+
+func (ks *Keystore) Names() []string {
+	names := make([]string, 0, len(ks.entries))
+	for name := range ks.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//SetPassphrase 修改口令，Save后生效
+func (ks *Keystore) SetPassphrase(passphrase string) {
+	ks.passphrase = passphrase
+}
+
+//Save 用新的salt和nonce加密后写入文件，文件权限为0600
+func (ks *Keystore) Save() error {
+	plain, err := json.Marshal(ks.entries)
+	if err != nil {
+		return err
+	}
+	kf := keystoreFile{Version: KEYSTORE_VERSION, Iterations: KEYSTORE_ITERATIONS, Salt: make([]byte, 16)}
+	if _, err = rand.Read(kf.Salt); err != nil {
+		return err
+	}
+	gcm, err := newGCM(ks.passphrase, kf.Salt, kf.Iterations)
+	if err != nil {
+		return err
+	}
+	kf.Nonce = make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(kf.Nonce); err != nil {
+		return err
+	}
+	kf.Data = gcm.Seal(nil, kf.Nonce, plain, nil)
+	b, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := ks.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ks.path)
+}
+
+func newGCM(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("keystore passphrase is empty")
+	}
+	block, err := aes.NewCipher(pbkdf2([]byte(passphrase), salt, iterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//pbkdf2 PBKDF2-HMAC-SHA256，见RFC 8018
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	key := make([]byte, 0, keyLen)
+	buf := make([]byte, 4)
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf, block)
+		prf.Write(buf)
+		u := prf.Sum(nil)
+		t := make([]byte, len(u))
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
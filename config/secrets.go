@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"os"
+	"runtime"
+)
+
+//读取api key和管理接口token的环境变量
+const (
+	ENV_ASS_KEY             = "QT_ASS_KEY"
+	ENV_SECRET_KEY          = "QT_SECRET_KEY"
+	ENV_KEYSTORE_PASSPHRASE = "QT_KEYSTORE_PASSPHRASE"
+	ENV_ADMIN_TOKEN         = "QT_ADMIN_TOKEN"
+)
+
+//resolveCredentials 按优先级从低到高读取api key：qt.yml中的assKey/secretKey，keyFile指定的文件，
+//keystore指定的加密文件，环境变量QT_ASS_KEY/QT_SECRET_KEY。管理接口的token也可以用环境变量QT_ADMIN_TOKEN
+func resolveCredentials(cfg *Config) error {
+	if cfg.KeyFile != "" {
+		c, err := readKeyFile(cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		cfg.AssKey, cfg.SecretKey = c.AssKey, c.SecretKey
+	}
+	if cfg.Keystore != "" {
+		passphrase := os.Getenv(ENV_KEYSTORE_PASSPHRASE)
+		if passphrase == "" {
+			return fmt.Errorf("keystore %s needs the passphrase in %s", cfg.Keystore, ENV_KEYSTORE_PASSPHRASE)
+		}
+		ks, err := OpenKeystore(cfg.Keystore, passphrase)
+		if err != nil {
+			return err
+		}
+		c, ok := ks.Get(cfg.KeystoreEntry)
+		if !ok {
+			return fmt.Errorf("keystore %s has no entry %s", cfg.Keystore, cfg.KeystoreEntry)
+		}
+		cfg.AssKey, cfg.SecretKey = c.AssKey, c.SecretKey
+	}
+	if v := os.Getenv(ENV_ASS_KEY); v != "" {
+		cfg.AssKey = v
+	}
+	if v := os.Getenv(ENV_SECRET_KEY); v != "" {
+		cfg.SecretKey = v
+	}
+	if v := os.Getenv(ENV_ADMIN_TOKEN); v != "" {
+		cfg.AdminToken = v
+	}
+	return nil
+}
+
+//readKeyFile 读取单独存放api key的文件(yml或json)，文件不能被其他用户读取
+func readKeyFile(path string) (Credentials, error) {
+	var c Credentials
+	fi, err := os.Stat(path)
+	if err != nil {
+		return c, err
+	}
+	//windows上没有unix的文件权限
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return c, fmt.Errorf("key file %s is accessible by other users,run chmod 600 %s", path, path)
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err = v.ReadInConfig(); err != nil {
+		return c, err
+	}
+	c.AssKey = v.GetString("assKey")
+	c.SecretKey = v.GetString("secretKey")
+	return c, nil
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPbkdf2(t *testing.T) {
+	//RFC 7914 11节的测试向量
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if got := hex.EncodeToString(pbkdf2([]byte("passwd"), []byte("salt"), 1, 64)); got != want {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestKeystore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "qt.keystore")
+
+	ks := NewKeystore(path, "secret")
+	ks.Set(KEYSTORE_ENTRY, Credentials{AssKey: "ass", SecretKey: "sec"})
+	ks.Set("old", Credentials{AssKey: "a0", SecretKey: "s0"})
+	if err = ks.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0600 {
+		t.Fatalf("keystore mode %v", fi.Mode())
+	}
+	if _, err = OpenKeystore(path, "wrong"); err == nil {
+		t.Fatal("wrong passphrase should be an error")
+	}
+
+	//修改口令后旧口令不能再打开
+	ks, err = OpenKeystore(path, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks.Delete("old")
+	ks.SetPassphrase("secret2")
+	if err = ks.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenKeystore(path, "secret"); err == nil {
+		t.Fatal("old passphrase should not open the keystore")
+	}
+	ks, err = OpenKeystore(path, "secret2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := ks.Get(KEYSTORE_ENTRY); !ok || c.SecretKey != "sec" || len(ks.Names()) != 1 {
+		t.Fatalf("entries %v", ks.Names())
+	}
+}
+
+func TestResolveCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "keys.yml")
+	ioutil.WriteFile(keyFile, []byte("assKey: fileass\nsecretKey: filesec\n"), 0644)
+
+	cfg := &Config{AssKey: "ymlass", SecretKey: "ymlsec", KeyFile: keyFile}
+	if err = resolveCredentials(cfg); err == nil {
+		t.Fatal("key file readable by others should be rejected")
+	}
+	os.Chmod(keyFile, 0600)
+	if err = resolveCredentials(cfg); err != nil || cfg.AssKey != "fileass" {
+		t.Fatalf("key file,%s,%v", cfg.AssKey, err)
+	}
+
+	path := filepath.Join(dir, "qt.keystore")
+	ks := NewKeystore(path, "secret")
+	ks.Set("trade", Credentials{AssKey: "ksass", SecretKey: "kssec"})
+	ks.Save()
+	cfg = &Config{KeyFile: keyFile, Keystore: path, KeystoreEntry: "trade"}
+	os.Setenv(ENV_KEYSTORE_PASSPHRASE, "secret")
+	defer os.Unsetenv(ENV_KEYSTORE_PASSPHRASE)
+	if err = resolveCredentials(cfg); err != nil || cfg.AssKey != "ksass" || cfg.SecretKey != "kssec" {
+		t.Fatalf("keystore,%s,%v", cfg.AssKey, err)
+	}
+
+	//环境变量优先
+	os.Setenv(ENV_SECRET_KEY, "envsec")
+	defer os.Unsetenv(ENV_SECRET_KEY)
+	if err = resolveCredentials(cfg); err != nil || cfg.AssKey != "ksass" || cfg.SecretKey != "envsec" {
+		t.Fatalf("env,%s,%v", cfg.SecretKey, err)
+	}
+
+	cfg.KeystoreEntry = "missing"
+	if err = resolveCredentials(cfg); err == nil {
+		t.Fatal("missing entry should be an error")
+	}
+}
@@ -0,0 +1,399 @@
+package config
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//SymbolConfig qt.yml中symbols下一个交易对的配置，可选的功能没有开启时对应字段为nil
+type SymbolConfig struct {
+	Symbol         string
+	Strategy       string          //dig grid twap，默认dig
+	Balance        decimal.Decimal //允许使用的计价币金额
+	MinBalance     decimal.Decimal //最小可用余额
+	MinAsset       decimal.Decimal //最小可用资产
+	AssetPrecision int32
+	PricePrecision int32
+	BuyLevel       int
+	SellLevel      int
+	Period         int
+	BySide         string //1单边交易 2双边交易
+	OrphanPolicy   string
+	PnLMethod      string
+	PriceTolerance decimal.Decimal //基点
+	SizeTolerance  decimal.Decimal //比例
+	Skew           *SkewConfig
+	Spread         *SpreadConfig
+	Ladder         *LadderConfig
+	Grid           *GridConfig
+	Twap           *TwapConfig
+}
+
+//SkewConfig 按持仓调整报价，配置了targetInventory时开启
+type SkewConfig struct {
+	Target    decimal.Decimal
+	Band      decimal.Decimal
+	MaxLevels int
+}
+
+//SpreadConfig 按中间价加减价差报价，priceMode为spread时开启
+type SpreadConfig struct {
+	Bps           decimal.Decimal
+	VolResolution string
+	VolWindow     int
+	VolMultiplier decimal.Decimal
+	MaxBps        decimal.Decimal
+}
+
+//LadderConfig 梯度挂单，ladderCount大于1时开启
+type LadderConfig struct {
+	Count     int
+	Step      int
+	StepBps   decimal.Decimal
+	Weighting string
+	Ratio     decimal.Decimal
+}
+
+//GridConfig 网格交易，strategy为grid时使用
+type GridConfig struct {
+	Lower      decimal.Decimal
+	Upper      decimal.Decimal
+	Levels     int
+	Amount     decimal.Decimal
+	StatePath  string //为空时不保存网格
+	KeepOrders bool   //退出时不撤单，重启后从StatePath接着跟踪
+}
+
+//TwapConfig 拆单执行，strategy为twap时使用
+type TwapConfig struct {
+	Side      string
+	Amount    decimal.Decimal
+	Duration  time.Duration
+	Slices    int
+	Visible   decimal.Decimal
+	Price     string
+	StatePath string //为空时不保存父单进度
+}
+
+const (
+	MIN_LEVEL = 1
+	MAX_LEVEL = 15
+)
+
+//misspelledKeys 以前版本中拼错的配置项
+var misspelledKeys = map[string]string{
+	"sellLevle":     "sellLevel",
+	"pricePrecison": "pricePrecision",
+}
+
+//FieldError 某个配置项的错误
+type FieldError struct {
+	Field string
+	Msg   string
+}
+
+func (fe FieldError) Error() string {
+	return fe.Field + " " + fe.Msg
+}
+
+//SymbolError 一个交易对的全部配置错误
+type SymbolError struct {
+	Index  int
+	Symbol string
+	Fields []FieldError
+}
+
+func (se *SymbolError) Error() string {
+	msgs := make([]string, 0, len(se.Fields))
+	for _, fe := range se.Fields {
+		msgs = append(msgs, fe.Error())
+	}
+	return fmt.Sprintf("symbols[%d] %s invalid:\n\t%s", se.Index, se.Symbol, strings.Join(msgs, "\n\t"))
+}
+
+//ParseSymbol 解析并校验一个交易对的配置，index是它在symbols中的位置，只用于错误信息
+func ParseSymbol(index int, raw map[string]string) (*SymbolConfig, error) {
+	p := &symbolParser{raw: raw, used: make(map[string]bool)}
+	sc := &SymbolConfig{
+		Symbol:         p.str("symbol", ""),
+		Strategy:       p.str("strategy", "dig"),
+		Balance:        p.decimal("balance", decimal.Zero),
+		MinBalance:     p.decimal("minBalance", decimal.Zero),
+		MinAsset:       p.decimal("minAsset", decimal.Zero),
+		AssetPrecision: int32(p.int("assetPrecision", -1)),
+		PricePrecision: int32(p.int("pricePrecision", -1)),
+		BuyLevel:       p.int("buyLevel", 0),
+		SellLevel:      p.int("sellLevel", 0),
+		Period:         p.int("period", 2),
+		BySide:         p.str("bySide", "2"),
+		OrphanPolicy:   p.str("orphanPolicy", "ignore"),
+		PnLMethod:      p.str("pnlMethod", "average"),
+		PriceTolerance: p.decimal("priceTolerance", decimal.Zero),
+		SizeTolerance:  p.decimal("sizeTolerance", decimal.Zero),
+	}
+	p.require(sc.Symbol != "", "symbol", "is required")
+	p.oneOf("strategy", sc.Strategy, "dig", "grid", "twap")
+	p.require(sc.AssetPrecision >= 0, "assetPrecision", "is required and must not be negative")
+	p.require(sc.PricePrecision >= 0, "pricePrecision", "is required and must not be negative")
+	p.require(sc.Period >= 1, "period", "must be at least 1 second,got %d", sc.Period)
+	p.require(!sc.MinBalance.IsNegative(), "minBalance", "must not be negative,got %s", sc.MinBalance)
+	p.require(!sc.MinAsset.IsNegative(), "minAsset", "must not be negative,got %s", sc.MinAsset)
+	p.oneOf("pnlMethod", sc.PnLMethod, "average", "fifo")
+
+	dig := sc.Strategy == "dig"
+	if dig {
+		p.require(sc.Balance.IsPositive(), "balance", "must be positive,got %s", sc.Balance)
+		p.levelRange("buyLevel", sc.BuyLevel)
+		p.levelRange("sellLevel", sc.SellLevel)
+		p.oneOf("bySide", sc.BySide, "1", "2")
+		p.oneOf("orphanPolicy", sc.OrphanPolicy, "ignore", "cancel", "adopt")
+		p.require(!sc.PriceTolerance.IsNegative(), "priceTolerance", "must not be negative,got %s", sc.PriceTolerance)
+		p.require(!sc.SizeTolerance.IsNegative(), "sizeTolerance", "must not be negative,got %s", sc.SizeTolerance)
+	}
+	sc.Skew = p.skew(dig)
+	sc.Spread = p.spread(dig)
+	sc.Ladder = p.ladder(dig)
+	sc.Grid = p.grid(sc.Strategy == "grid", sc.Symbol)
+	sc.Twap = p.twap(sc.Strategy == "twap", sc.Symbol)
+
+	p.unknownKeys()
+	if len(p.errs) > 0 {
+		return sc, &SymbolError{Index: index, Symbol: sc.Symbol, Fields: p.errs}
+	}
+	return sc, nil
+}
+
+//Clone 复制一份配置，包括可选功能的配置，修改副本不影响原来的配置
+func (sc *SymbolConfig) Clone() *SymbolConfig {
+	c := *sc
+	if sc.Skew != nil {
+		sk := *sc.Skew
+		c.Skew = &sk
+	}
+	if sc.Spread != nil {
+		sp := *sc.Spread
+		c.Spread = &sp
+	}
+	if sc.Ladder != nil {
+		l := *sc.Ladder
+		c.Ladder = &l
+	}
+	if sc.Grid != nil {
+		g := *sc.Grid
+		c.Grid = &g
+	}
+	if sc.Twap != nil {
+		tw := *sc.Twap
+		c.Twap = &tw
+	}
+	return &c
+}
+
+func (p *symbolParser) skew(enabled bool) *SkewConfig {
+	sk := &SkewConfig{
+		Target:    p.decimal("targetInventory", decimal.Zero),
+		Band:      p.decimal("inventoryBand", decimal.Zero),
+		MaxLevels: p.int("maxSkewLevels", 0),
+	}
+	if !enabled || !p.has("targetInventory") {
+		return nil
+	}
+	p.require(!sk.Target.IsNegative(), "targetInventory", "must not be negative,got %s", sk.Target)
+	p.require(!sk.Band.IsNegative(), "inventoryBand", "must not be negative,got %s", sk.Band)
+	p.require(sk.MaxLevels >= 0, "maxSkewLevels", "must not be negative,got %d", sk.MaxLevels)
+	return sk
+}
+
+func (p *symbolParser) spread(enabled bool) *SpreadConfig {
+	mode := p.str("priceMode", "level")
+	sp := &SpreadConfig{
+		Bps:           p.decimal("spreadBps", decimal.Zero),
+		VolResolution: p.str("volResolution", ""),
+		VolWindow:     p.int("volWindow", 0),
+		VolMultiplier: p.decimal("volMultiplier", decimal.Zero),
+		MaxBps:        p.decimal("maxSpreadBps", decimal.Zero),
+	}
+	if !enabled {
+		return nil
+	}
+	p.oneOf("priceMode", mode, "level", "spread")
+	if mode != "spread" {
+		return nil
+	}
+	p.require(!sp.Bps.IsNegative(), "spreadBps", "must not be negative,got %s", sp.Bps)
+	p.require(sp.VolWindow >= 0, "volWindow", "must not be negative,got %d", sp.VolWindow)
+	p.require(sp.VolWindow == 0 || sp.VolResolution != "", "volResolution", "is required when volWindow is set")
+	p.require(!sp.VolMultiplier.IsNegative(), "volMultiplier", "must not be negative,got %s", sp.VolMultiplier)
+	p.require(!sp.MaxBps.IsNegative(), "maxSpreadBps", "must not be negative,got %s", sp.MaxBps)
+	return sp
+}
+
+func (p *symbolParser) ladder(enabled bool) *LadderConfig {
+	l := &LadderConfig{
+		Count:     p.int("ladderCount", 1),
+		Step:      p.int("ladderStep", 1),
+		StepBps:   p.decimal("ladderStepBps", decimal.Zero),
+		Weighting: p.str("ladderWeighting", "flat"),
+		Ratio:     p.decimal("ladderRatio", decimal.Zero),
+	}
+	if !enabled {
+		return nil
+	}
+	p.require(l.Count >= 1, "ladderCount", "must be at least 1,got %d", l.Count)
+	if l.Count <= 1 {
+		return nil
+	}
+	p.require(l.Step >= 1, "ladderStep", "must be at least 1,got %d", l.Step)
+	p.require(!l.StepBps.IsNegative(), "ladderStepBps", "must not be negative,got %s", l.StepBps)
+	p.oneOf("ladderWeighting", l.Weighting, "flat", "linear", "geometric")
+	if l.Weighting == "geometric" {
+		p.require(l.Ratio.IsPositive(), "ladderRatio", "must be positive for geometric weighting,got %s", l.Ratio)
+	}
+	return l
+}
+
+func (p *symbolParser) grid(enabled bool, symbol string) *GridConfig {
+	g := &GridConfig{
+		Lower:      p.decimal("gridLower", decimal.Zero),
+		Upper:      p.decimal("gridUpper", decimal.Zero),
+		Levels:     p.int("gridLevels", 0),
+		Amount:     p.decimal("gridAmount", decimal.Zero),
+		StatePath:  p.str("gridStatePath", "./data/grid-"+symbol+".json"),
+		KeepOrders: p.bool("gridKeepOrders", false),
+	}
+	if !enabled {
+		return nil
+	}
+	p.require(!g.KeepOrders || g.StatePath != "", "gridKeepOrders", "requires gridStatePath")
+	p.require(g.Lower.IsPositive(), "gridLower", "must be positive,got %s", g.Lower)
+	p.require(g.Upper.GreaterThan(g.Lower), "gridUpper", "must be greater than gridLower,got %s", g.Upper)
+	p.require(g.Levels >= 1, "gridLevels", "must be at least 1,got %d", g.Levels)
+	p.require(g.Amount.IsPositive(), "gridAmount", "must be positive,got %s", g.Amount)
+	return g
+}
+
+func (p *symbolParser) twap(enabled bool, symbol string) *TwapConfig {
+	tw := &TwapConfig{
+		Side:      p.str("twapSide", ""),
+		Amount:    p.decimal("twapAmount", decimal.Zero),
+		Duration:  time.Duration(p.int("twapDuration", 0)) * time.Second,
+		Slices:    p.int("twapSlices", 1),
+		Visible:   p.decimal("twapVisible", decimal.Zero),
+		Price:     p.str("twapPrice", "passive"),
+		StatePath: p.str("twapStatePath", "./data/twap-"+symbol+".json"),
+	}
+	if !enabled {
+		return nil
+	}
+	p.oneOf("twapSide", tw.Side, "buy", "sell")
+	p.require(tw.Amount.IsPositive(), "twapAmount", "must be positive,got %s", tw.Amount)
+	p.require(tw.Duration >= 0, "twapDuration", "must not be negative,got %s", tw.Duration)
+	p.require(tw.Slices >= 1, "twapSlices", "must be at least 1,got %d", tw.Slices)
+	p.require(!tw.Visible.IsNegative(), "twapVisible", "must not be negative,got %s", tw.Visible)
+	p.oneOf("twapPrice", tw.Price, "passive", "cross")
+	return tw
+}
+
+//symbolParser 按类型读取配置项，记下读过的key和所有错误
+type symbolParser struct {
+	raw  map[string]string
+	used map[string]bool
+	errs []FieldError
+}
+
+func (p *symbolParser) has(key string) bool {
+	_, ok := p.raw[key]
+	return ok
+}
+
+func (p *symbolParser) str(key, def string) string {
+	p.used[key] = true
+	if v, ok := p.raw[key]; ok {
+		return strings.TrimSpace(v)
+	}
+	return def
+}
+
+func (p *symbolParser) int(key string, def int) int {
+	s := p.str(key, "")
+	if s == "" {
+		return def
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		p.fail(key, "must be an integer,got %q", s)
+		return def
+	}
+	return v
+}
+
+func (p *symbolParser) bool(key string, def bool) bool {
+	s := p.str(key, "")
+	if s == "" {
+		return def
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		p.fail(key, "must be true or false,got %q", s)
+		return def
+	}
+	return v
+}
+
+func (p *symbolParser) decimal(key string, def decimal.Decimal) decimal.Decimal {
+	s := p.str(key, "")
+	if s == "" {
+		return def
+	}
+	v, err := decimal.NewFromString(s)
+	if err != nil {
+		p.fail(key, "must be a number,got %q", s)
+		return def
+	}
+	return v
+}
+
+func (p *symbolParser) fail(key, format string, args ...interface{}) {
+	p.errs = append(p.errs, FieldError{Field: key, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *symbolParser) require(ok bool, key, format string, args ...interface{}) {
+	if !ok {
+		p.fail(key, format, args...)
+	}
+}
+
+func (p *symbolParser) oneOf(key, v string, allowed ...string) {
+	for _, a := range allowed {
+		if v == a {
+			return
+		}
+	}
+	p.fail(key, "must be one of %s,got %q", strings.Join(allowed, ","), v)
+}
+
+func (p *symbolParser) levelRange(key string, level int) {
+	p.require(level >= MIN_LEVEL && level <= MAX_LEVEL, key, "must be between %d and %d,got %d", MIN_LEVEL, MAX_LEVEL, level)
+}
+
+//unknownKeys 没有读过的key都是拼错或者不支持的配置项
+func (p *symbolParser) unknownKeys() {
+	keys := make([]string, 0)
+	for k := range p.raw {
+		if !p.used[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if right, ok := misspelledKeys[k]; ok {
+			p.fail(k, "is misspelled,use %s", right)
+		} else {
+			p.fail(k, "is not a known setting")
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func validSymbol() map[string]string {
+	return map[string]string{
+		"symbol":         "eosusdt",
+		"balance":        "50",
+		"assetPrecision": "4",
+		"pricePrecision": "3",
+		"buyLevel":       "5",
+		"sellLevel":      "6",
+	}
+}
+
+func TestParseSymbol_Defaults(t *testing.T) {
+	sc, err := ParseSymbol(0, validSymbol())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Strategy != "dig" || sc.Period != 2 || sc.BySide != "2" || sc.OrphanPolicy != "ignore" || sc.PnLMethod != "average" {
+		t.Fatalf("defaults %+v", sc)
+	}
+	if sc.BuyLevel != 5 || sc.SellLevel != 6 || sc.PricePrecision != 3 || !sc.MinBalance.IsZero() {
+		t.Fatalf("parsed %+v", sc)
+	}
+	if sc.Skew != nil || sc.Spread != nil || sc.Ladder != nil || sc.Grid != nil || sc.Twap != nil {
+		t.Fatal("optional features should be off")
+	}
+}
+
+func TestParseSymbol_Errors(t *testing.T) {
+	raw := validSymbol()
+	raw["sellLevle"] = "5"
+	delete(raw, "sellLevel")
+	raw["buyLevel"] = "16"
+	raw["balance"] = "-1"
+	raw["bySide"] = "3"
+	raw["period"] = "two"
+
+	_, err := ParseSymbol(1, raw)
+	se, ok := err.(*SymbolError)
+	if !ok {
+		t.Fatalf("expected SymbolError,got %v", err)
+	}
+	fields := make(map[string]string)
+	for _, fe := range se.Fields {
+		fields[fe.Field] = fe.Msg
+	}
+	for _, f := range []string{"sellLevle", "sellLevel", "buyLevel", "balance", "bySide", "period"} {
+		if _, ok := fields[f]; !ok {
+			t.Fatalf("missing error for %s,%v", f, err)
+		}
+	}
+	if !strings.Contains(fields["sellLevle"], "use sellLevel") || !strings.Contains(fields["buyLevel"], "between 1 and 15,got 16") {
+		t.Fatalf("errors should explain the fix,%v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "symbols[1] eosusdt invalid:") {
+		t.Fatal(err)
+	}
+}
+
+func TestParseSymbol_Strategies(t *testing.T) {
+	raw := map[string]string{
+		"symbol": "paxusdt", "strategy": "grid", "assetPrecision": "4", "pricePrecision": "4",
+		"gridLower": "0.99", "gridUpper": "1.01", "gridLevels": "10", "gridAmount": "5",
+	}
+	sc, err := ParseSymbol(0, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Grid == nil || sc.Grid.Levels != 10 || sc.Grid.StatePath != "./data/grid-paxusdt.json" || sc.Grid.KeepOrders {
+		t.Fatalf("grid %+v", sc.Grid)
+	}
+
+	raw["gridKeepOrders"] = "true"
+	if sc, err = ParseSymbol(0, raw); err != nil || !sc.Grid.KeepOrders {
+		t.Fatalf("keep orders,%+v,%v", sc.Grid, err)
+	}
+	raw["gridStatePath"] = ""
+	if _, err = ParseSymbol(0, raw); err == nil || !strings.Contains(err.Error(), "gridKeepOrders requires gridStatePath") {
+		t.Fatalf("keep orders without state,%v", err)
+	}
+	raw["gridKeepOrders"] = "yes"
+	if _, err = ParseSymbol(0, raw); err == nil || !strings.Contains(err.Error(), "gridKeepOrders must be true or false") {
+		t.Fatalf("bad bool,%v", err)
+	}
+	delete(raw, "gridKeepOrders")
+	delete(raw, "gridStatePath")
+
+	raw["gridUpper"] = "0.98"
+	if _, err = ParseSymbol(0, raw); err == nil || !strings.Contains(err.Error(), "gridUpper must be greater than gridLower") {
+		t.Fatalf("inverted grid,%v", err)
+	}
+
+	raw = map[string]string{
+		"symbol": "eosusdt", "strategy": "twap", "assetPrecision": "4", "pricePrecision": "3",
+		"twapSide": "buy", "twapAmount": "100", "twapDuration": "3600", "twapSlices": "12",
+	}
+	sc, err = ParseSymbol(0, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Twap.Duration != time.Hour || sc.Twap.Price != "passive" {
+		t.Fatalf("twap %+v", sc.Twap)
+	}
+}
+
+func TestParseSymbols_Duplicate(t *testing.T) {
+	one := make(map[interface{}]interface{})
+	for k, v := range validSymbol() {
+		one[k] = v
+	}
+	//yml中没有加引号的数字
+	one["period"] = 3
+	ss, err := ParseSymbols([]interface{}{one})
+	if err != nil || ss[0].Period != 3 {
+		t.Fatalf("%v,%v", ss, err)
+	}
+	if _, err = ParseSymbols([]interface{}{one, one}); err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Fatalf("duplicate symbol,%v", err)
+	}
+}
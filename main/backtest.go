@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/MrChang666/qt/config"
+	"github.com/MrChang666/qt/service"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//backtest 用录制的深度快照回测qt.yml中某个交易对的配置
+//qt backtest -symbol eosusdt -data depth-eosusdt.jsonl.gz [-quote 100] [-base 0] [-fee 0.001]
+func backtest(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+	symbol := fs.String("symbol", "", "symbol configured in qt.yml, e.g. eosusdt")
+	data := fs.String("data", "", "comma separated depth files or globs (.jsonl, .csv, optionally .gz)")
+	quote := fs.String("quote", "", "initial quote balance, defaults to the symbol's balance")
+	base := fs.String("base", "0", "initial base balance")
+	fee := fs.String("fee", "0.001", "fee rate charged on each fill")
+	fs.Parse(args)
+
+	var sc *config.SymbolConfig
+	for _, s := range cfg.Symbols {
+		if s.Symbol == *symbol {
+			sc = s
+		}
+	}
+	if sc == nil || sc.Strategy != service.STRATEGY_DIG || *data == "" {
+		fmt.Fprintln(os.Stderr, "symbol must be configured in qt.yml with the dig strategy and data is required")
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *quote == "" {
+		*quote = sc.Balance.String()
+	}
+
+	depths := make([]*service.Depth, 0, 1024)
+	for _, pattern := range strings.Split(*data, ",") {
+		//录制文件按时间命名，glob排序后就是时间顺序
+		paths, err := filepath.Glob(pattern)
+		if err != nil || len(paths) == 0 {
+			log.Fatalf("no data file matches %s", pattern)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			ds, err := service.LoadDepths(path, *symbol)
+			if err != nil {
+				log.Fatalf("load %s failed,%v", path, err)
+			}
+			depths = append(depths, ds...)
+		}
+	}
+
+	ex := service.NewSnapshotExchange(mustDecimal("fee", *fee))
+	baseCcy, quoteCcy := service.SplitSymbol(*symbol)
+	ex.AddMarket(*symbol, baseCcy, quoteCcy)
+	ex.SetBalance(quoteCcy, mustDecimal("quote", *quote))
+	ex.SetBalance(baseCcy, mustDecimal("base", *base))
+
+	res := service.RunBacktest(newDigService(sc, ex), ex, depths)
+	fmt.Println(res)
+}
+
+func mustDecimal(name, s string) decimal.Decimal {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		log.Fatalf("invalid %s %q,%v", name, s, err)
+	}
+	return d
+}
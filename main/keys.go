@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/MrChang666/qt/config"
+	"os"
+	"strings"
+)
+
+const defaultKeystore = "./config/qt.keystore"
+
+//keys 管理加密保存api key的keystore，口令和api key优先从环境变量读取，没有时从标准输入读取
+//qt keys set [-keystore ./config/qt.keystore] [-name default]  新增或替换一组api key，keystore不存在时创建
+//qt keys list                                                 列出保存的api key
+//qt keys delete -name old                                     删除一组api key
+//qt keys passwd                                               修改口令
+func keys(args []string) {
+	if len(args) == 0 {
+		keysUsage()
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("keys "+action, flag.ExitOnError)
+	path := fs.String("keystore", defaultKeystore, "keystore file, set keystore in qt.yml to the same path")
+	name := fs.String("name", config.KEYSTORE_ENTRY, "entry name, set keystoreEntry in qt.yml to use it")
+	fs.Parse(args[1:])
+
+	_, err := os.Stat(*path)
+	creating := os.IsNotExist(err) && action == "set"
+	in := bufio.NewReader(os.Stdin)
+	passphrase := os.Getenv(config.ENV_KEYSTORE_PASSPHRASE)
+	if passphrase == "" {
+		passphrase = prompt(in, "passphrase: ")
+		//新建keystore时和passwd一样输入两次，输错了以后就打不开了
+		if creating && (passphrase == "" || passphrase != prompt(in, "repeat passphrase: ")) {
+			fatal("passphrases are empty or do not match")
+		}
+	}
+
+	var ks *config.Keystore
+	if creating {
+		fmt.Fprintf(os.Stderr, "creating keystore %s\n", *path)
+		ks = config.NewKeystore(*path, passphrase)
+	} else {
+		ks, err = config.OpenKeystore(*path, passphrase)
+		if err != nil {
+			fatal("open keystore failed,%v", err)
+		}
+	}
+
+	switch action {
+	case "set":
+		c := config.Credentials{AssKey: os.Getenv(config.ENV_ASS_KEY), SecretKey: os.Getenv(config.ENV_SECRET_KEY)}
+		if c.AssKey == "" {
+			c.AssKey = prompt(in, "assKey: ")
+		}
+		if c.SecretKey == "" {
+			c.SecretKey = prompt(in, "secretKey: ")
+		}
+		if c.AssKey == "" || c.SecretKey == "" {
+			fatal("assKey and secretKey are required")
+		}
+		if _, ok := ks.Get(*name); ok {
+			fmt.Fprintf(os.Stderr, "replacing entry %s\n", *name)
+		}
+		ks.Set(*name, c)
+	case "list":
+		for _, n := range ks.Names() {
+			c, _ := ks.Get(n)
+			fmt.Printf("%s\tassKey:%s\n", n, mask(c.AssKey))
+		}
+		return
+	case "delete":
+		if !ks.Delete(*name) {
+			fatal("keystore has no entry %s", *name)
+		}
+	case "passwd":
+		next := prompt(in, "new passphrase: ")
+		if next == "" || next != prompt(in, "repeat new passphrase: ") {
+			fatal("passphrases are empty or do not match")
+		}
+		ks.SetPassphrase(next)
+	default:
+		keysUsage()
+	}
+	if err := ks.Save(); err != nil {
+		fatal("save keystore failed,%v", err)
+	}
+	fmt.Fprintf(os.Stderr, "saved %s\n", *path)
+}
+
+func keysUsage() {
+	fmt.Fprintln(os.Stderr, "usage: qt keys set|list|delete|passwd [-keystore path] [-name entry]")
+	os.Exit(2)
+}
+
+//prompt 从标准输入读取一行，输入会显示在终端上，不想显示时用环境变量
+func prompt(in *bufio.Reader, msg string) string {
+	fmt.Fprint(os.Stderr, msg)
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		fatal("read %s failed,%v", strings.TrimSuffix(msg, ": "), err)
+	}
+	return strings.TrimSpace(line)
+}
+
+//mask 只显示前4位
+func mask(s string) string {
+	if len(s) <= 4 {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:4] + strings.Repeat("*", len(s)-4)
+}
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -1,18 +1,24 @@
 package main
 
 import (
-	"github.com/MrChang666/fcoin-api-go/client"
+	"context"
 	"github.com/MrChang666/qt/config"
+	"github.com/MrChang666/qt/metrics"
 	"github.com/MrChang666/qt/service"
 	"github.com/natefinch/lumberjack"
-	"github.com/shopspring/decimal"
 	log "github.com/sirupsen/logrus"
-	"strconv"
 
 	"io"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+//退出超时后中断请求，再等待策略返回的时间
+const abortTimeout = 5 * time.Second
+
 func initLog(logPath, logLevel string) {
 
 	lumberjackLogger := &lumberjack.Logger{
@@ -47,30 +53,215 @@ func initLog(logPath, logLevel string) {
 	log.SetLevel(level)
 }
 
+//newDigService 根据qt.yml中一个交易对的配置创建DigService
+func newDigService(sc *config.SymbolConfig, ex service.Exchange) *service.DigService {
+	ds := service.NewDigService(sc.Symbol, sc.Balance, sc.MinBalance, sc.MinAsset, sc.AssetPrecision, sc.PricePrecision, ex, sc.SellLevel, sc.BuyLevel, sc.Period, sc.BySide)
+	ds.SetPnLMethod(sc.PnLMethod)
+	ds.Reconfigure(sc)
+	return ds
+}
+
+//newGridService 根据qt.yml中strategy为grid的交易对创建GridService
+func newGridService(sc *config.SymbolConfig) *service.GridService {
+	g := sc.Grid
+	gs := service.NewGridService(sc.Symbol, g.Lower, g.Upper, g.Amount, g.Levels, sc.AssetPrecision, sc.PricePrecision, g.StatePath)
+	gs.SetPnLMethod(sc.PnLMethod)
+	gs.SetKeepOrders(g.KeepOrders)
+	return gs
+}
+
+//newTwapService 根据qt.yml中strategy为twap的交易对创建TwapService
+func newTwapService(sc *config.SymbolConfig) *service.TwapService {
+	tw := sc.Twap
+	ts := service.NewTwapService(sc.Symbol, tw.Side, tw.Amount, tw.Duration, tw.Slices, sc.AssetPrecision)
+	ts.SetIceberg(tw.Visible)
+	ts.SetPriceStyle(tw.Price)
+	ts.SetStatePath(tw.StatePath)
+	return ts
+}
+
+func init() {
+	service.RegisterStrategy(service.STRATEGY_DIG, func(sc *config.SymbolConfig, ex service.Exchange) (service.Strategy, error) {
+		return newDigService(sc, ex), nil
+	})
+	service.RegisterStrategy(service.STRATEGY_GRID, func(sc *config.SymbolConfig, ex service.Exchange) (service.Strategy, error) {
+		return newGridService(sc), nil
+	})
+	service.RegisterStrategy(service.STRATEGY_TWAP, func(sc *config.SymbolConfig, ex service.Exchange) (service.Strategy, error) {
+		return newTwapService(sc), nil
+	})
+}
+
+//newPaperExchange 模拟盘，余额从paperBalances开始
+func newPaperExchange(cfg *config.Config, live service.Exchange) *service.PaperExchange {
+	pe := service.NewPaperExchange(live, mustDecimal("paperFee", cfg.PaperFee))
+	for _, s := range cfg.Symbols {
+		base, quote := service.SplitSymbol(s.Symbol)
+		pe.AddMarket(s.Symbol, base, quote)
+	}
+	for currency, amount := range cfg.PaperBalances {
+		pe.SetBalance(currency, mustDecimal(currency, amount))
+	}
+	log.Infof("paper trading with balances %v", cfg.PaperBalances)
+	return pe
+}
+
+//runSymbols 要运行的交易对。模拟盘的订单重启后就不存在了，不保存网格和拆单进度，新增的交易对加入模拟盘
+func runSymbols(cfg *config.Config, ex service.Exchange) []*config.SymbolConfig {
+	pe, ok := ex.(*service.PaperExchange)
+	if !ok {
+		return cfg.Symbols
+	}
+	for _, s := range cfg.Symbols {
+		if s.Grid != nil {
+			s.Grid.StatePath = ""
+			s.Grid.KeepOrders = false
+		}
+		if s.Twap != nil {
+			s.Twap.StatePath = ""
+		}
+		base, quote := service.SplitSymbol(s.Symbol)
+		pe.AddMarket(s.Symbol, base, quote)
+	}
+	return cfg.Symbols
+}
+
 func main() {
-	cfg := config.InitConfig("qt", "./config")
+	//keys在读取配置之前，keystore还不存在时也能创建
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		keys(os.Args[2:])
+		return
+	}
+
+	cfg, err := config.LoadConfig("qt", "./config")
+	if err != nil {
+		log.Fatalf("load config failed,%v", err)
+	}
 	initLog(cfg.LogPath, cfg.LogLevel)
-	fcClient := client.NewFCoinClient(cfg.SecretKey, cfg.AssKey, cfg.BaseUrl)
 
-	start := make(chan int)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "backtest":
+			backtest(cfg, os.Args[2:])
+			return
+		case "record":
+			record(cfg, os.Args[2:])
+			return
+		}
+	}
 
-	for _, s := range cfg.Symbols {
-		symbol := s["symbol"]
-		balance, _ := decimal.NewFromString(s["balance"])
-		minBalance, _ := decimal.NewFromString(s["minBalance"])
-		minAsset, _ := decimal.NewFromString(s["minAsset"])
-		assetPrecision, _ := strconv.Atoi(s["assetPrecision"])
-		pricePrecison, _ := strconv.Atoi(s["pricePrecison"])
+	fcClient := service.NewRestClient(cfg.SecretKey, cfg.AssKey, cfg.BaseUrl)
+	fcClient.SetObserver(service.ObserveRequest)
+	fcClient.SetRetryPolicy(service.RetryPolicy{MaxRetries: cfg.RetryMax, BaseDelay: cfg.RetryDelay, MaxDelay: cfg.RetryMaxDelay})
+	fcClient.SetRateLimits(service.NewLimiter(cfg.PublicRate, cfg.PublicBurst), service.NewLimiter(cfg.PrivateRate, cfg.PrivateBurst))
+	var ex service.Exchange = service.NewFCoinExchange(fcClient)
+	var feed *service.MarketFeed
+	if cfg.WsUrl != "" {
+		feed = service.NewMarketFeed(ex, cfg.WsUrl)
+		ex = feed
+	}
+	if cfg.Mode == config.MODE_PAPER {
+		ex = newPaperExchange(cfg, ex)
+	} else {
+		ex = service.NewAccountCache(ex, cfg.BalanceRefresh)
+	}
 
-		buyLevel, _ := strconv.Atoi(s["buyLevel"])
-		sellLevel, _ := strconv.Atoi(s["sellLevle"])
-		period, _ := strconv.Atoi(s["period"])
+	//模拟盘的订单不在交易所上，不需要日志
+	var journal *service.Journal
+	if cfg.Mode == config.MODE_LIVE && cfg.JournalPath != "" {
+		j, err := service.OpenJournal(cfg.JournalPath)
+		if err != nil {
+			log.Fatalf("open journal failed,%v", err)
+		}
+		journal = j
+	}
 
-		bySide := s["bySide"]
+	ctx, cancel := signalContext()
+	defer cancel()
+	//退出时撤单不再等待重试，超时后中断还在进行的请求
+	fcClient.SetShutdown(ctx.Done())
+	abortCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+	fcClient.SetContext(abortCtx)
 
-		ds := service.NewDigService(symbol, balance, minBalance, minAsset, int32(assetPrecision), int32(pricePrecison), fcClient, buyLevel, sellLevel, period, bySide)
-		go ds.Run()
+	m := service.NewManager(ctx, ex, journal)
+	if feed != nil {
+		go feed.Run(ctx)
+		m.SetFeed(feed, cfg.FeedInterval)
 	}
+	for _, s := range runSymbols(cfg, ex) {
+		if err = m.Start(s); err != nil {
+			log.Fatalf("%s,%v", s.Symbol, err)
+		}
+	}
+
+	if cfg.AdminAddr != "" {
+		serve(ctx, "admin api", cfg.AdminAddr, service.NewAdminServer(m, cfg.AdminToken))
+	}
+	if cfg.MetricsAddr != "" {
+		m.CollectMetrics()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Default)
+		serve(ctx, "metrics", cfg.MetricsAddr, mux)
+	}
+
+	//修改qt.yml后增加、停止或修改交易对，其它配置需要重启后生效
+	cfg.Watch(func(next *config.Config) {
+		log.Infof("config file changed,reloading symbols")
+		if next.Mode != cfg.Mode || next.BaseUrl != cfg.BaseUrl || next.JournalPath != cfg.JournalPath || next.WsUrl != cfg.WsUrl {
+			log.Warn("only symbols are reloaded,restart to apply other changes")
+		}
+		if err := m.Apply(runSymbols(next, ex)); err != nil {
+			log.Errorf("reload config failed,%v", err)
+		}
+	}, func(err error) {
+		log.Errorf("reload config rejected,keep the running config,%v", err)
+	})
+
+	<-ctx.Done()
+	log.Infof("shutting down,waiting up to %ds for orders to be cancelled", cfg.ShutdownTimeout)
+	if !m.Wait(time.Duration(cfg.ShutdownTimeout) * time.Second) {
+		log.Error("shutdown timed out,some orders may still be on the book")
+		abort()
+		//策略返回后才能关闭订单日志，否则还在写的记录会丢
+		if !m.Wait(abortTimeout) {
+			log.Error("strategies are still running,exit without closing the journal")
+			return
+		}
+	}
+	if journal != nil {
+		journal.Close()
+	}
+}
+
+//serve 在addr上开启HTTP服务，ctx结束时关闭
+func serve(ctx context.Context, name, addr string, h http.Handler) {
+	srv := &http.Server{Addr: addr, Handler: h}
+	go func() {
+		log.Infof("%s listening on %s", name, addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("%s stopped,%v", name, err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+}
 
-	<-start
+//signalContext 收到SIGINT或SIGTERM时结束的context
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case s := <-sig:
+			log.Infof("received %v", s)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sig)
+	}()
+	return ctx, cancel
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"github.com/MrChang666/qt/config"
+	"github.com/MrChang666/qt/service"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRecordInterval = time.Second
+	defaultCandleInterval = time.Minute
+	defaultRotate         = time.Hour
+)
+
+//record 录制qt.yml中交易对的深度、ticker和k线，供回测使用
+//qt record [-dir data] [-symbols eosusdt,paxusdt] [-interval 1s] [-rotate 1h]
+func record(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	dir := fs.String("dir", "data", "output directory")
+	symbols := fs.String("symbols", "", "comma separated symbols, defaults to all symbols in qt.yml")
+	interval := fs.Duration("interval", defaultRecordInterval, "depth and ticker polling interval")
+	candleInterval := fs.Duration("candle-interval", defaultCandleInterval, "candle polling interval")
+	resolution := fs.String("resolution", "M1", "candle resolution")
+	rotate := fs.Duration("rotate", defaultRotate, "start a new file every rotate")
+	compress := fs.Bool("gzip", true, "gzip the output files")
+	fs.Parse(args)
+
+	ss := make([]string, 0, len(cfg.Symbols))
+	if *symbols != "" {
+		ss = strings.Split(*symbols, ",")
+	} else {
+		for _, s := range cfg.Symbols {
+			ss = append(ss, s.Symbol)
+		}
+	}
+
+	fcClient := service.NewRestClient(cfg.SecretKey, cfg.AssKey, cfg.BaseUrl)
+	fcClient.SetRetryPolicy(service.RetryPolicy{MaxRetries: cfg.RetryMax, BaseDelay: cfg.RetryDelay, MaxDelay: cfg.RetryMaxDelay})
+	fcClient.SetRateLimits(service.NewLimiter(cfg.PublicRate, cfg.PublicBurst), service.NewLimiter(cfg.PrivateRate, cfg.PrivateBurst))
+	rec := service.NewRecorder(fcClient, ss, *dir, *rotate, *compress, *resolution)
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	fcClient.SetShutdown(ctx.Done())
+
+	log.Infof("recording %v to %s", ss, *dir)
+	rec.Run(ctx, *interval, *candleInterval)
+	if err := rec.Close(); err != nil {
+		log.Errorf("close record files failed,%v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	TYPE_COUNTER   = "counter"
+	TYPE_GAUGE     = "gauge"
+	TYPE_HISTOGRAM = "histogram"
+)
+
+//DEFAULT_BUCKETS 请求耗时的默认分桶，单位秒
+var DEFAULT_BUCKETS = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+//Registry 按Prometheus文本格式导出的指标，只实现了qt用到的counter、gauge和histogram
+type Registry struct {
+	mu         sync.Mutex
+	families   []*family
+	collectors []func()
+}
+
+//Default 默认的Registry，/metrics导出的就是它
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type family struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	counts []uint64 //histogram每个分桶的数量，不累计
+	sum    float64
+	count  uint64
+}
+
+//CounterVec 只增不减的计数，按标签区分
+type CounterVec struct{ f *family }
+
+//GaugeVec 可以任意设置的值，按标签区分
+type GaugeVec struct{ f *family }
+
+//HistogramVec 按分桶统计的分布，按标签区分
+type HistogramVec struct{ f *family }
+
+type Counter struct {
+	f *family
+	s *series
+}
+type Gauge struct {
+	f *family
+	s *series
+}
+type Histogram struct {
+	f *family
+	s *series
+}
+
+func (r *Registry) add(name, help, typ string, buckets []float64, labels []string) *family {
+	f := &family{name: name, help: help, typ: typ, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	r.mu.Lock()
+	r.families = append(r.families, f)
+	r.mu.Unlock()
+	return f
+}
+
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.add(name, help, TYPE_COUNTER, nil, labels)}
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.add(name, help, TYPE_GAUGE, nil, labels)}
+}
+
+//Histogram buckets为每个分桶的上限，从小到大排列
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{r.add(name, help, TYPE_HISTOGRAM, buckets, labels)}
+}
+
+//OnCollect 每次导出前调用f，用来更新需要查询才能得到的gauge
+func (r *Registry) OnCollect(f func()) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, f)
+	r.mu.Unlock()
+}
+
+//get 按标签值找到一条时间序列，没有时创建，标签值的数量需要和标签一致
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("%s has %d labels,got %d values", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.typ == TYPE_HISTOGRAM {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (cv *CounterVec) With(values ...string) Counter {
+	return Counter{cv.f, cv.f.get(values)}
+}
+
+func (gv *GaugeVec) With(values ...string) Gauge {
+	return Gauge{gv.f, gv.f.get(values)}
+}
+
+func (hv *HistogramVec) With(values ...string) Histogram {
+	return Histogram{hv.f, hv.f.get(values)}
+}
+
+func (c Counter) Inc() {
+	c.Add(1)
+}
+
+//Add v不能是负数
+func (c Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.f.mu.Lock()
+	c.s.value += v
+	c.f.mu.Unlock()
+}
+
+func (c Counter) Value() float64 {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	return c.s.value
+}
+
+func (g Gauge) Set(v float64) {
+	g.f.mu.Lock()
+	g.s.value = v
+	g.f.mu.Unlock()
+}
+
+func (g Gauge) Value() float64 {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	return g.s.value
+}
+
+func (h Histogram) Observe(v float64) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	for i, b := range h.f.buckets {
+		if v <= b {
+			h.s.counts[i]++
+			break
+		}
+	}
+	h.s.sum += v
+	h.s.count++
+}
+
+//Write 按Prometheus文本格式写出所有指标
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]func(){}, r.collectors...)
+	families := append([]*family{}, r.families...)
+	r.mu.Unlock()
+	for _, c := range collectors {
+		c()
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, strings.Replace(f.help, "\n", " ", -1))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := f.series[k]
+		if f.typ != TYPE_HISTOGRAM {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labelString(f.labels, s.values, "", ""), formatFloat(s.value))
+			continue
+		}
+		var cum uint64
+		for i, b := range f.buckets {
+			cum += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelString(f.labels, s.values, "le", formatFloat(b)), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelString(f.labels, s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelString(f.labels, s.values, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelString(f.labels, s.values, "", ""), s.count)
+	}
+}
+
+//labelString 如{symbol="eosusdt",side="buy"}，extra不为空时加在最后
+func labelString(labels, values []string, extra, extraValue string) string {
+	if len(labels) == 0 && extra == "" {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)+1)
+	for i, l := range labels {
+		parts = append(parts, l+"="+quote(values[i]))
+	}
+	if extra != "" {
+		parts = append(parts, extra+"="+quote(extraValue))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+//quote 标签值只需要转义反斜杠、双引号和换行
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//ServeHTTP 导出指标，挂在/metrics上
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := r.Write(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("orders_total", "Orders.", "symbol", "side")
+	g := r.Gauge("balance", "Balance.", "currency")
+	h := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1}, "endpoint")
+	c.With("eosusdt", "buy").Inc()
+	c.With("eosusdt", "buy").Add(2)
+	c.With("eosusdt", "buy").Add(-1)
+	g.With(`a"b`).Set(1.5)
+	h.With("depth").Observe(0.05)
+	h.With("depth").Observe(0.5)
+	h.With("depth").Observe(3)
+	collected := 0
+	r.OnCollect(func() { collected++ })
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE orders_total counter",
+		`orders_total{symbol="eosusdt",side="buy"} 3`,
+		`balance{currency="a\"b"} 1.5`,
+		`latency_seconds_bucket{endpoint="depth",le="0.1"} 1`,
+		`latency_seconds_bucket{endpoint="depth",le="1"} 2`,
+		`latency_seconds_bucket{endpoint="depth",le="+Inf"} 3`,
+		`latency_seconds_sum{endpoint="depth"} 3.55`,
+		`latency_seconds_count{endpoint="depth"} 3`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %s in\n%s", line, out)
+		}
+	}
+	if collected != 1 {
+		t.Fatalf("collectors called %d times", collected)
+	}
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+	"sync"
+	"time"
+)
+
+//AccountCache 所有交易对共用的账户余额，包装真实交易所：interval内只请求一次/accounts/balance，
+//两次刷新之间自己下单成功时在本地冻结资金；撤单、订单有成交或结果不确定时，下一次读取余额重新刷新
+type AccountCache struct {
+	Exchange
+	interval   time.Duration
+	mu         sync.Mutex
+	ledger     *ledger
+	updated    time.Time
+	stale      bool
+	gen        int64                      //本地余额每次变化或失效时加1
+	refreshing *refreshCall               //正在进行的刷新，同时读取的交易对等待这一次的结果
+	orders     map[string]decimal.Decimal //自己挂着的订单已经成交的数量
+	now        func() time.Time
+}
+
+//refreshCall 一次查询余额的请求，done关闭后err为结果
+type refreshCall struct {
+	done   chan struct{}
+	err    error
+	placed []*OrderRequest //请求期间成功的下单
+}
+
+//NewAccountCache interval不大于0时每次读取余额都请求交易所
+func NewAccountCache(ex Exchange, interval time.Duration) *AccountCache {
+	return &AccountCache{
+		Exchange: ex,
+		interval: interval,
+		orders:   make(map[string]decimal.Decimal),
+		now:      time.Now,
+	}
+}
+
+//GetBalances 返回缓存的余额，过期或者失效时先刷新，同时读取的交易对共用一次请求
+func (ac *AccountCache) GetBalances() ([]*Balance, error) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if ac.ledger == nil || ac.stale || ac.now().Sub(ac.updated) >= ac.interval {
+		if err := ac.refresh(); err != nil {
+			return nil, err
+		}
+	}
+	return ac.ledger.snapshot(), nil
+}
+
+//refresh 持有锁时调用，请求期间释放锁。已经有刷新在进行时等待它的结果，不再重复请求。
+//请求期间本地余额有变化时不知道是否已经算在返回的余额里，刷新后仍然失效
+func (ac *AccountCache) refresh() error {
+	if call := ac.refreshing; call != nil {
+		ac.mu.Unlock()
+		<-call.done
+		ac.mu.Lock()
+		return call.err
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	ac.refreshing = call
+	ac.stale = false
+	gen := ac.gen
+	ac.mu.Unlock()
+	bals, err := ac.Exchange.GetBalances()
+	ac.mu.Lock()
+	ac.refreshing = nil
+	defer close(call.done)
+	if err != nil {
+		ac.stale = true
+		call.err = fmt.Errorf("refresh balances failed,%w", err)
+		return call.err
+	}
+	l := newLedger(decimal.Zero)
+	for _, b := range bals {
+		c := *b
+		l.balances[b.Currency] = &c
+	}
+	//请求期间下的单可能不在返回的余额里，重新冻结，宁可少算可用余额
+	for _, req := range call.placed {
+		base, quote := SplitSymbol(req.Symbol)
+		l.freeze(base, quote, req.Side, req.Price, req.Amount)
+	}
+	ac.ledger = l
+	ac.updated = ac.now()
+	if ac.gen != gen {
+		ac.stale = true
+	}
+	return nil
+}
+
+//invalidate 持有锁时调用，下一次读取余额时重新刷新
+func (ac *AccountCache) invalidate() {
+	ac.stale = true
+	ac.gen++
+}
+
+//Invalidate 下一次读取余额时重新刷新
+func (ac *AccountCache) Invalidate() {
+	ac.mu.Lock()
+	ac.invalidate()
+	ac.mu.Unlock()
+}
+
+//CreateOrder 下单成功后在本地冻结资金，失败或者本地余额对不上时刷新
+func (ac *AccountCache) CreateOrder(req *OrderRequest) (*OrderResult, error) {
+	res, err := ac.Exchange.CreateOrder(req)
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if err != nil || res.Status != client.ORDER_STATES_SUCCESS {
+		ac.invalidate()
+		return res, err
+	}
+	ac.orders[res.ID] = decimal.Zero
+	ac.gen++
+	if ac.refreshing != nil {
+		ac.refreshing.placed = append(ac.refreshing.placed, req)
+	}
+	if ac.ledger == nil {
+		ac.stale = true
+		return res, nil
+	}
+	base, quote := SplitSymbol(req.Symbol)
+	if !ac.ledger.freeze(base, quote, req.Side, req.Price, req.Amount) {
+		ac.stale = true
+	}
+	return res, nil
+}
+
+//CancelOrder 撤单时可能已经部分成交，解冻的数量以交易所为准
+func (ac *AccountCache) CancelOrder(id string) (*CancelResult, error) {
+	res, err := ac.Exchange.CancelOrder(id)
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.invalidate()
+	if err == nil && (res.Status == client.ORDER_STATES_SUCCESS || res.Status == client.CANCEL_SUCCESS_ORDER) {
+		delete(ac.orders, id)
+	}
+	return res, err
+}
+
+//GetOrder 自己的订单有新的成交或者已经结束时刷新
+func (ac *AccountCache) GetOrder(id string) (*Order, error) {
+	o, err := ac.Exchange.GetOrder(id)
+	if err != nil {
+		return nil, err
+	}
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	filled, ok := ac.orders[id]
+	if !ok {
+		return o, nil
+	}
+	if !o.FilledAmount.Equal(filled) {
+		ac.invalidate()
+		ac.orders[id] = o.FilledAmount
+	}
+	switch o.State {
+	case client.FILLED, client.ORDER_STATE_CANCEL, ORDER_STATE_PARTIAL_CANCELED:
+		ac.invalidate()
+		delete(ac.orders, id)
+	}
+	return o, nil
+}
+
+//GetCandles k线直接取交易所的
+func (ac *AccountCache) GetCandles(symbol, resolution string, limit int) ([]*Candle, error) {
+	cs, ok := ac.Exchange.(CandleSource)
+	if !ok {
+		return nil, fmt.Errorf("exchange does not provide candles")
+	}
+	return cs.GetCandles(symbol, resolution, limit)
+}
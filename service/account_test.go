@@ -0,0 +1,156 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//countingExchange 统计查询余额的次数
+type countingExchange struct {
+	*SimExchange
+	balanceCalls int
+}
+
+func (ce *countingExchange) GetBalances() ([]*Balance, error) {
+	ce.balanceCalls++
+	return ce.SimExchange.GetBalances()
+}
+
+func cachedBalance(t *testing.T, ac *AccountCache, currency string) *Balance {
+	bals, err := ac.GetBalances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range bals {
+		if b.Currency == currency {
+			return b
+		}
+	}
+	t.Fatalf("no balance for %s", currency)
+	return nil
+}
+
+func TestAccountCache(t *testing.T) {
+	se := NewSimExchange(dec("0.001"))
+	se.AddMarket("eosusdt", "eos", "usdt")
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("10"))
+	ce := &countingExchange{SimExchange: se}
+	now := time.Unix(1000, 0)
+	ac := NewAccountCache(ce, 5*time.Second)
+	ac.now = func() time.Time { return now }
+
+	//两个交易对、买卖两侧在一个周期里只查询一次
+	for i := 0; i < 4; i++ {
+		if _, err := GetAvailableBalance(ac, "usdt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ce.balanceCalls != 1 {
+		t.Fatalf("balance calls %d", ce.balanceCalls)
+	}
+
+	//下单后在本地冻结，不需要查询
+	buy, err := ac.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.BUY, Price: dec("4.9"), Amount: dec("2")})
+	if err != nil || buy.Status != client.ORDER_STATES_SUCCESS {
+		t.Fatalf("create %v,%v", buy, err)
+	}
+	if b := cachedBalance(t, ac, "usdt"); !b.Available.Equal(dec("90.2")) || !b.Frozen.Equal(dec("9.8")) || ce.balanceCalls != 1 {
+		t.Fatalf("usdt %v,calls %d", b, ce.balanceCalls)
+	}
+
+	//外部卖单吃掉买单，查询订单发现成交后重新查询余额
+	se.SubmitExternal("eosusdt", client.SELL, dec("4.9"), dec("2"))
+	if o, err := ac.GetOrder(buy.ID); err != nil || o.State != client.FILLED {
+		t.Fatalf("order %v,%v", o, err)
+	}
+	if b := cachedBalance(t, ac, "eos"); !b.Available.Equal(dec("11.998")) || ce.balanceCalls != 2 {
+		t.Fatalf("eos %v,calls %d", b, ce.balanceCalls)
+	}
+
+	//撤单后重新查询
+	sell, _ := ac.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.SELL, Price: dec("6"), Amount: dec("1")})
+	if b := cachedBalance(t, ac, "eos"); !b.Frozen.Equal(dec("1")) || ce.balanceCalls != 2 {
+		t.Fatalf("eos %v,calls %d", b, ce.balanceCalls)
+	}
+	if res, err := ac.CancelOrder(sell.ID); err != nil || res.Status != client.ORDER_STATES_SUCCESS {
+		t.Fatalf("cancel %v,%v", res, err)
+	}
+	if b := cachedBalance(t, ac, "eos"); !b.Frozen.IsZero() || ce.balanceCalls != 3 {
+		t.Fatalf("eos %v,calls %d", b, ce.balanceCalls)
+	}
+
+	//余额不足时本地余额不对，重新查询
+	if res, _ := ac.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.SELL, Price: dec("6"), Amount: dec("100")}); res.Status != client.ORDER_INSUFFICIENT {
+		t.Fatalf("create %v", res)
+	}
+	cachedBalance(t, ac, "eos")
+	if ce.balanceCalls != 4 {
+		t.Fatalf("balance calls %d", ce.balanceCalls)
+	}
+
+	//过期后刷新
+	now = now.Add(5 * time.Second)
+	cachedBalance(t, ac, "eos")
+	if ce.balanceCalls != 5 {
+		t.Fatalf("balance calls %d", ce.balanceCalls)
+	}
+}
+
+//slowBalanceExchange 查询余额时先取快照，release后才返回
+type slowBalanceExchange struct {
+	*SimExchange
+	calls   int32
+	release chan struct{}
+}
+
+func (se *slowBalanceExchange) GetBalances() ([]*Balance, error) {
+	bals, err := se.SimExchange.GetBalances()
+	atomic.AddInt32(&se.calls, 1)
+	<-se.release
+	return bals, err
+}
+
+func TestAccountCache_Concurrent(t *testing.T) {
+	se := NewSimExchange(dec("0.001"))
+	se.AddMarket("eosusdt", "eos", "usdt")
+	se.SetBalance("usdt", dec("100"))
+	slow := &slowBalanceExchange{SimExchange: se, release: make(chan struct{})}
+	ac := NewAccountCache(slow, time.Minute)
+
+	//同时读取的交易对共用一次请求
+	results := make(chan []*Balance, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			bals, err := ac.GetBalances()
+			if err != nil {
+				t.Error(err)
+			}
+			results <- bals
+		}()
+	}
+	waitFor(t, "balance request", func() bool { return atomic.LoadInt32(&slow.calls) == 1 })
+	time.Sleep(10 * time.Millisecond)
+	//请求期间下的单不能因为旧的余额丢掉
+	if res, err := ac.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.BUY, Price: dec("4.9"), Amount: dec("2")}); err != nil || res.Status != client.ORDER_STATES_SUCCESS {
+		t.Fatalf("create %v,%v", res, err)
+	}
+	close(slow.release)
+	for i := 0; i < 3; i++ {
+		for _, b := range <-results {
+			if b.Currency == "usdt" && !b.Frozen.Equal(dec("9.8")) {
+				t.Fatalf("usdt %v", b)
+			}
+		}
+	}
+	if calls := atomic.LoadInt32(&slow.calls); calls != 1 {
+		t.Fatalf("balance calls %d", calls)
+	}
+	//不知道返回的余额是否包含请求期间的下单，下一次读取重新刷新
+	cachedBalance(t, ac, "usdt")
+	if calls := atomic.LoadInt32(&slow.calls); calls != 2 {
+		t.Fatalf("balance calls %d", calls)
+	}
+}
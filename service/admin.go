@@ -0,0 +1,131 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/MrChang666/qt/config"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+//AdminServer 查看和控制正在运行的交易对的HTTP接口，修改类的请求需要带上
+//Authorization: Bearer <token>
+//
+//	GET    /symbols                   所有交易对的订单、最近深度时间、余额、盈亏和修改过的配置
+//	POST   /symbols/{symbol}/pause    暂停并撤掉所有订单
+//	POST   /symbols/{symbol}/resume   恢复运行
+//	POST   /symbols/{symbol}/cancel   撤掉所有订单，不暂停
+//	POST   /symbols/{symbol}/config   修改档位和金额，如{"buyLevel":5,"sellLevel":5,"balance":"50"}，
+//	                                  没有传的字段不修改，qt.yml重新加载后仍然有效
+//	DELETE /symbols/{symbol}/config   撤销修改，恢复为qt.yml中的配置
+type AdminServer struct {
+	m     *Manager
+	token string
+}
+
+func NewAdminServer(m *Manager, token string) *AdminServer {
+	return &AdminServer{m: m, token: token}
+}
+
+func (as *AdminServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if parts[0] != "symbols" || len(parts) > 3 || len(parts) == 2 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", req.URL.Path))
+		return
+	}
+	if len(parts) == 1 {
+		if req.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+			return
+		}
+		ss, err := as.m.Status()
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, ss)
+		return
+	}
+
+	if req.Method != http.MethodPost && !(req.Method == http.MethodDelete && parts[2] == "config") {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+	if !as.authorized(req) {
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+		return
+	}
+	symbol, action := parts[1], parts[2]
+	r := as.m.Runner(symbol)
+	if r == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s is not running", symbol))
+		return
+	}
+	log.Infof("%s,admin %s from %s", symbol, action, req.RemoteAddr)
+
+	var err error
+	left := 0
+	switch action {
+	case "pause":
+		left, err = r.Pause()
+	case "resume":
+		err = r.Resume()
+	case "cancel":
+		left, err = r.ForceCancel()
+	case "config":
+		if req.Method == http.MethodDelete {
+			err = as.m.ClearOverrides(symbol)
+			break
+		}
+		ov := &Overrides{}
+		if err = json.NewDecoder(req.Body).Decode(ov); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid body,%v", err))
+			return
+		}
+		if err = ov.validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		err = as.m.Adjust(symbol, ov)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %s", action))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"symbol": symbol, "action": action, "ordersLeft": left})
+}
+
+//authorized 比较token时不泄露耗时差异
+func (as *AdminServer) authorized(req *http.Request) bool {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return as.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(as.token)) == 1
+}
+
+func (ov *Overrides) validate() error {
+	for _, l := range []*int{ov.BuyLevel, ov.SellLevel} {
+		if l != nil && (*l < config.MIN_LEVEL || *l > config.MAX_LEVEL) {
+			return fmt.Errorf("level must be between %d and %d,got %d", config.MIN_LEVEL, config.MAX_LEVEL, *l)
+		}
+	}
+	if ov.Balance != nil && !ov.Balance.IsPositive() {
+		return fmt.Errorf("balance must be positive,got %s", ov.Balance)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("write admin response failed,%v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/MrChang666/qt/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	m := NewManager(ctx, se, nil)
+	if err := m.Start(&config.SymbolConfig{Symbol: "eosusdt", Strategy: "reconf", Balance: dec("100"), BuyLevel: 5, SellLevel: 5, Period: 60}); err != nil {
+		t.Fatal(err)
+	}
+	r := m.Runner("eosusdt")
+	srv := httptest.NewServer(NewAdminServer(m, "token"))
+	defer srv.Close()
+
+	call := func(method, path, token, body string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		res := make(map[string]interface{})
+		json.NewDecoder(resp.Body).Decode(&res)
+		return resp.StatusCode, res
+	}
+
+	resp, err := http.Get(srv.URL + "/symbols")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ss []*RunnerStatus
+	json.NewDecoder(resp.Body).Decode(&ss)
+	resp.Body.Close()
+	if len(ss) != 1 || ss[0].Strategy != "reconf" || ss[0].LastDepth.IsZero() || len(ss[0].Balances) != 1 {
+		t.Fatalf("status %+v", ss)
+	}
+
+	if code, _ := call("POST", "/symbols/eosusdt/pause", "", ""); code != http.StatusUnauthorized {
+		t.Fatalf("pause without token,%d", code)
+	}
+	if code, _ := call("POST", "/symbols/eosusdt/pause", "wrong", ""); code != http.StatusUnauthorized {
+		t.Fatalf("pause with wrong token,%d", code)
+	}
+
+	r.Do(func() { r.Place(client.BUY, dec("4.9"), dec("1")) })
+	if code, res := call("POST", "/symbols/eosusdt/pause", "token", ""); code != http.StatusOK || res["ordersLeft"].(float64) != 0 {
+		t.Fatalf("pause,%d,%v", code, res)
+	}
+	if !r.Paused() || len(r.OpenOrders()) != 0 {
+		t.Fatal("pause should cancel all orders")
+	}
+	if code, _ := call("POST", "/symbols/eosusdt/resume", "token", ""); code != http.StatusOK || r.Paused() {
+		t.Fatalf("resume,%d", code)
+	}
+
+	if code, _ := call("POST", "/symbols/eosusdt/config", "token", `{"buyLevel":99}`); code != http.StatusBadRequest {
+		t.Fatalf("invalid level,%d", code)
+	}
+	if code, res := call("POST", "/symbols/eosusdt/config", "token", `{"buyLevel":8,"balance":"50"}`); code != http.StatusOK {
+		t.Fatalf("adjust,%d,%v", code, res)
+	}
+	if sc := m.runners["eosusdt"].config(); sc.BuyLevel != 8 || sc.SellLevel != 5 || !sc.Balance.Equal(dec("50")) || r.pending != sc {
+		t.Fatalf("adjusted config %+v", sc)
+	}
+	if code, res := call("DELETE", "/symbols/eosusdt/config", "token", ""); code != http.StatusOK {
+		t.Fatalf("clear overrides,%d,%v", code, res)
+	}
+	if sc := m.runners["eosusdt"].config(); sc.BuyLevel != 5 || !sc.Balance.Equal(dec("100")) {
+		t.Fatalf("cleared config %+v", sc)
+	}
+	if code, _ := call("DELETE", "/symbols/eosusdt/pause", "token", ""); code != http.StatusMethodNotAllowed {
+		t.Fatalf("delete pause,%d", code)
+	}
+	if code, _ := call("POST", "/symbols/btcusdt/cancel", "token", ""); code != http.StatusNotFound {
+		t.Fatalf("unknown symbol,%d", code)
+	}
+}
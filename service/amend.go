@@ -0,0 +1,116 @@
+package service
+
+import (
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+//SetAmendTolerance 挂单价格偏离报价超过priceBps个基点，或者剩余数量偏离超过size比例时才撤单重挂，
+//默认都为0，只要有变化就重挂
+func (ds *DigService) SetAmendTolerance(priceBps, size decimal.Decimal) {
+	ds.priceTolerance = priceBps
+	ds.sizeTolerance = size
+}
+
+//amend 检查两侧挂着的订单，需要重挂的撤掉，之后createSellOrder和createBuyOrder会按新报价挂单。
+//每个周期只查询一次挂单列表和余额，不在挂单列表中的订单才单独查询。
+//bySide为1时同时只挂一侧，两侧都有挂单时(比如重启后接管了两侧的订单)两侧都撤掉，再按正常流程只挂一侧
+func (ds *DigService) amend(depth *Depth, buy, sell sideQuote) {
+	if len(ds.buyOrders) == 0 && len(ds.sellOrders) == 0 {
+		return
+	}
+	if ds.bySide == "1" && len(ds.buyOrders) > 0 && len(ds.sellOrders) > 0 {
+		log.Infof("%s,one side trade,cancel buy order %s and sell order %s", ds.symbol, orderIDs(ds.buyOrders), orderIDs(ds.sellOrders))
+		ds.cancelSellOrder()
+		ds.cancelBuyOrder()
+		return
+	}
+	orders, err := ds.ex.GetOpenOrders(ds.symbol)
+	if err != nil {
+		log.Errorf("%s,get open orders failed,%v", ds.symbol, err)
+		return
+	}
+	open := make(map[string]*Order, len(orders))
+	for _, o := range orders {
+		open[o.ID] = o
+	}
+	bals, err := ds.ex.GetBalances()
+	if err != nil {
+		log.Errorf("%s,get balances failed,%v", ds.symbol, err)
+		return
+	}
+	if ds.amendOrders(client.SELL, ds.sellOrders, open, availableOf(bals, getCurrency(ds.symbol)), depth, sell) {
+		ds.cancelSellOrder()
+	}
+	if ds.amendOrders(client.BUY, ds.buyOrders, open, availableOf(bals, getUSDT(ds.symbol)), depth, buy) {
+		ds.cancelBuyOrder()
+	}
+}
+
+//amendOrders 一侧的订单作为一个整体判断：有订单已经结束，或者任何一个订单偏离新报价超过容忍度，
+//都返回true，整侧撤掉重挂。open为交易所上的挂单，available为这一侧币种的可用余额
+func (ds *DigService) amendOrders(side string, orders []*OrderResult, open map[string]*Order, available decimal.Decimal, depth *Depth, q sideQuote) bool {
+	if len(orders) == 0 {
+		return false
+	}
+	resting := make([]*Order, 0, len(orders))
+	//挂单冻结的资金也可以用于新报价
+	locked := decimal.Zero
+	for _, res := range orders {
+		o, ok := open[res.ID]
+		if !ok {
+			ds.finishedOrder(res.ID)
+			return true
+		}
+		rest := o.Amount.Sub(o.FilledAmount)
+		if side == client.BUY {
+			locked = locked.Add(o.Price.Mul(rest))
+		} else {
+			locked = locked.Add(rest)
+		}
+		resting = append(resting, o)
+	}
+	if reason := ds.ladderReason(resting, ds.rungs(side, depth, q, available.Add(locked))); reason != "" {
+		log.Infof("%s,replace %s order %s,%s", ds.symbol, side, orderIDs(orders), reason)
+		return true
+	}
+	log.Debugf("%s,keep %s order %s", ds.symbol, side, orderIDs(orders))
+	return false
+}
+
+func orderIDs(orders []*OrderResult) string {
+	ids := make([]string, 0, len(orders))
+	for _, res := range orders {
+		ids = append(ids, res.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+//finishedOrder 查询不在挂单列表中的订单，已经成交或撤销的记录下来，查询失败时交给撤单流程处理
+func (ds *DigService) finishedOrder(id string) {
+	if _, err := ds.runner.Refresh(id); err != nil {
+		log.Errorf("%s,get order %s failed,%v", ds.symbol, id, err)
+	}
+}
+
+//replaceReason 挂单需要重挂的原因，不需要时返回空
+func (ds *DigService) replaceReason(restPrice, restAmount, price, amount decimal.Decimal) string {
+	if !amount.IsPositive() {
+		return "no amount to quote"
+	}
+	bps := price.Sub(restPrice).Abs().Div(restPrice).Mul(decimal.New(10000, 0))
+	if bps.GreaterThan(ds.priceTolerance) {
+		return fmt.Sprintf("price %s->%s(%sbps)", restPrice, price, bps.StringFixed(2))
+	}
+	if !restAmount.IsPositive() {
+		return "nothing left"
+	}
+	diff := amount.Sub(restAmount).Abs().Div(restAmount)
+	if diff.GreaterThan(ds.sizeTolerance) {
+		return fmt.Sprintf("amount %s->%s", restAmount, amount)
+	}
+	return ""
+}
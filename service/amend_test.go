@@ -0,0 +1,113 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"testing"
+)
+
+func TestDigService_KeepOrdersWhenQuoteUnchanged(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("4"))
+	ce := &orderCountingExchange{SimExchange: se}
+	ds := newTestDigService(ce)
+	ds.runner.runOnce()
+	buyID, sellID := ds.buyOrders[0].ID, ds.sellOrders[0].ID
+
+	ds.runner.runOnce()
+	if ds.buyOrders[0].ID != buyID || ds.sellOrders[0].ID != sellID {
+		t.Fatal("orders should be kept when the quote does not move")
+	}
+	//挂着的订单都在挂单列表中，不单独查询
+	if ce.orderCalls != 0 {
+		t.Fatalf("get order calls %d", ce.orderCalls)
+	}
+	if o, _ := se.GetOrder(buyID); o.State != ORDER_STATE_SUBMITTED {
+		t.Fatalf("buy order state %s", o.State)
+	}
+}
+
+func TestDigService_ReplaceBeyondTolerance(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	ds := newTestDigService(se)
+	ds.SetAmendTolerance(dec("30"), dec("0.1"))
+	ds.runner.runOnce()
+	buyID := ds.buyOrders[0].ID
+
+	//第5档从4.96变成4.97，约20bps，在容忍度内
+	se.SubmitExternal("eosusdt", client.BUY, dec("5.005"), dec("1"))
+	ds.runner.runOnce()
+	if ds.buyOrders[0].ID != buyID {
+		t.Fatal("20bps move is within tolerance")
+	}
+
+	//再抬高5档，价格变化超过30bps
+	for _, p := range []string{"5.006", "5.007", "5.008", "5.009"} {
+		se.SubmitExternal("eosusdt", client.BUY, dec(p), dec("1"))
+	}
+	ds.runner.runOnce()
+	if ds.buyOrders[0].ID == buyID {
+		t.Fatal("order should be replaced")
+	}
+	if o, _ := se.GetOrder(buyID); o.State != client.ORDER_STATE_CANCEL {
+		t.Fatalf("old order state %s", o.State)
+	}
+	if o, _ := se.GetOrder(ds.buyOrders[0].ID); !o.Price.Equal(dec("5.005")) {
+		t.Fatalf("new price %s", o.Price)
+	}
+}
+
+func TestDigService_AmendOneSide(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	ds := newTestDigService(se)
+	ds.bySide = "1"
+	ds.runner.runOnce()
+	buyID := ds.buyOrders[0].ID
+
+	//有了币以后买单还挂着，不挂卖单
+	se.SetBalance("eos", dec("4"))
+	ds.runner.runOnce()
+	if len(ds.sellOrders) != 0 || len(ds.buyOrders) == 0 || ds.buyOrders[0].ID != buyID {
+		t.Fatalf("only the buy side should be quoted,buy %s,sell %s", orderIDs(ds.buyOrders), orderIDs(ds.sellOrders))
+	}
+
+	//两侧都有挂单时两侧都撤掉，再按正常流程先挂卖单
+	ds.sellOrders, _ = ds.placeOrders(client.SELL, []rung{{price: dec("5.05"), amount: dec("1")}})
+	sellID := ds.sellOrders[0].ID
+	ds.runner.runOnce()
+	if len(ds.buyOrders) != 0 || len(ds.sellOrders) == 0 || ds.sellOrders[0].ID == sellID {
+		t.Fatalf("both sides should be cancelled,buy %s,sell %s", orderIDs(ds.buyOrders), orderIDs(ds.sellOrders))
+	}
+	for _, id := range []string{buyID, sellID} {
+		if o, _ := se.GetOrder(id); o.State != client.ORDER_STATE_CANCEL {
+			t.Fatalf("order %s state %s", id, o.State)
+		}
+	}
+
+	//只有卖单时和买单一样按新报价重挂，不挂买单
+	sellID = ds.sellOrders[0].ID
+	se.SubmitExternal("eosusdt", client.SELL, dec("5.005"), dec("1"))
+	ds.runner.runOnce()
+	if len(ds.buyOrders) != 0 || len(ds.sellOrders) == 0 || ds.sellOrders[0].ID == sellID {
+		t.Fatalf("sell orders should be replaced,buy %s,sell %s", orderIDs(ds.buyOrders), orderIDs(ds.sellOrders))
+	}
+}
+
+func TestReplaceReason(t *testing.T) {
+	ds := newTestDigService(nil)
+	ds.SetAmendTolerance(dec("10"), dec("0.2"))
+	if r := ds.replaceReason(dec("5"), dec("10"), dec("5.004"), dec("11")); r != "" {
+		t.Fatalf("within tolerance,%s", r)
+	}
+	if r := ds.replaceReason(dec("5"), dec("10"), dec("5.01"), dec("10")); r == "" {
+		t.Fatal("price moved 20bps")
+	}
+	if r := ds.replaceReason(dec("5"), dec("10"), dec("5"), dec("13")); r == "" {
+		t.Fatal("size moved 30%")
+	}
+	if r := ds.replaceReason(dec("5"), dec("10"), dec("5"), dec("0")); r == "" {
+		t.Fatal("nothing to quote")
+	}
+}
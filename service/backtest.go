@@ -0,0 +1,229 @@
+package service
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//LoadDepths 读取某个交易对录制下来的深度快照，按文件中的顺序返回。
+//.jsonl每行是Recorder写入的Record，或者一个fcoin深度接口的原始返回(client.Depth)；
+//.csv表头为ts,seq,bids,asks，bids和asks是用空格分隔的[价格 数量 价格 数量...]。
+//文件名以.gz结尾时先解压
+func LoadDepths(path, symbol string) ([]*Depth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	name := path
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+		name = strings.TrimSuffix(name, ".gz")
+	}
+
+	if strings.HasSuffix(name, ".csv") {
+		return readDepthCSV(r, symbol)
+	}
+	return readDepthJSONL(r, symbol)
+}
+
+func readDepthJSONL(r io.Reader, symbol string) ([]*Depth, error) {
+	depths := make([]*Depth, 0, 1024)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		//录制文件每行是一个Record，也兼容直接保存的深度接口返回
+		rec := &Record{}
+		if err := json.Unmarshal([]byte(text), rec); err != nil {
+			return nil, fmt.Errorf("line %d,%v", line, err)
+		}
+		raw := []byte(text)
+		if rec.Kind != "" {
+			if rec.Kind != RECORD_DEPTH || (rec.Symbol != "" && rec.Symbol != symbol) {
+				continue
+			}
+			raw = rec.Data
+		}
+		d := &client.Depth{}
+		if err := json.Unmarshal(raw, d); err != nil {
+			return nil, fmt.Errorf("line %d,%v", line, err)
+		}
+		depths = append(depths, ConvertDepth(symbol, d))
+	}
+	return depths, scanner.Err()
+}
+
+func readDepthCSV(r io.Reader, symbol string) ([]*Depth, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	depths := make([]*Depth, 0, len(rows))
+	for i, row := range rows {
+		if i == 0 && row[0] == "ts" {
+			continue
+		}
+		if len(row) != 4 {
+			return nil, fmt.Errorf("row %d,want 4 columns,got %d", i+1, len(row))
+		}
+		d := &client.Depth{}
+		if d.Data.Ts, err = strconv.ParseInt(row[0], 10, 64); err != nil {
+			return nil, fmt.Errorf("row %d,ts %v", i+1, err)
+		}
+		if d.Data.Seq, err = strconv.ParseInt(row[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("row %d,seq %v", i+1, err)
+		}
+		if d.Data.Bids, err = parseFloats(row[2]); err != nil {
+			return nil, fmt.Errorf("row %d,bids %v", i+1, err)
+		}
+		if d.Data.Asks, err = parseFloats(row[3]); err != nil {
+			return nil, fmt.Errorf("row %d,asks %v", i+1, err)
+		}
+		depths = append(depths, ConvertDepth(symbol, d))
+	}
+	return depths, nil
+}
+
+func parseFloats(s string) ([]float64, error) {
+	fields := strings.Fields(s)
+	fs := make([]float64, 0, len(fields))
+	for _, v := range fields {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+//BacktestResult 一个交易对的回测结果，金额都以计价币计
+type BacktestResult struct {
+	Symbol     string
+	Snapshots  int
+	Cycles     int
+	BuyFills   int
+	SellFills  int
+	Bought     decimal.Decimal //买入的基础币数量
+	Sold       decimal.Decimal //卖出的基础币数量
+	Fees       decimal.Decimal
+	StartBase  decimal.Decimal
+	EndBase    decimal.Decimal
+	StartQuote decimal.Decimal
+	EndQuote   decimal.Decimal
+	StartMid   decimal.Decimal
+	EndMid     decimal.Decimal
+	Accounting PnLSnapshot //DigService按成交核算的盈亏
+}
+
+//InventoryDrift 基础币持仓的变化
+func (br *BacktestResult) InventoryDrift() decimal.Decimal {
+	return br.EndBase.Sub(br.StartBase)
+}
+
+//PnL 按结束时的中间价对持仓变化估值后的收益，已扣除手续费
+func (br *BacktestResult) PnL() decimal.Decimal {
+	return br.EndQuote.Sub(br.StartQuote).Add(br.InventoryDrift().Mul(br.EndMid))
+}
+
+func (br *BacktestResult) String() string {
+	return fmt.Sprintf("symbol:%s,snapshots:%d,cycles:%d,buy fills:%d(%s),sell fills:%d(%s),fees:%s,inventory drift:%s,mid:%s->%s,pnl:%s,realized:%s,unrealized:%s",
+		br.Symbol, br.Snapshots, br.Cycles, br.BuyFills, br.Bought, br.SellFills, br.Sold,
+		br.Fees.StringFixed(8), br.InventoryDrift(), br.StartMid, br.EndMid, br.PnL().StringFixed(8),
+		br.Accounting.Realized.StringFixed(8), br.Accounting.Unrealized.StringFixed(8))
+}
+
+func midPrice(d *Depth) decimal.Decimal {
+	if len(d.Bids) == 0 || len(d.Asks) == 0 {
+		return decimal.Zero
+	}
+	return d.Bids[0].Price.Add(d.Asks[0].Price).Div(decimal.New(2, 0))
+}
+
+func totalBalance(ex Exchange, currency string) decimal.Decimal {
+	bals, _ := ex.GetBalances()
+	for _, b := range bals {
+		if b.Currency == currency {
+			return b.Available.Add(b.Frozen)
+		}
+	}
+	return decimal.Zero
+}
+
+//RunBacktest 把深度快照依次推给ex，每隔period秒(按快照时间)执行一次ds的挂单逻辑，
+//ds必须是用ex创建的。回测结束时撤掉剩余挂单
+func RunBacktest(ds *DigService, ex *SnapshotExchange, depths []*Depth) *BacktestResult {
+	base, quote := SplitSymbol(ds.symbol)
+	res := &BacktestResult{
+		Symbol:     ds.symbol,
+		Snapshots:  len(depths),
+		Bought:     decimal.Zero,
+		Sold:       decimal.Zero,
+		Fees:       decimal.Zero,
+		StartBase:  totalBalance(ex, base),
+		StartQuote: totalBalance(ex, quote),
+	}
+	if len(depths) == 0 {
+		res.EndBase, res.EndQuote = res.StartBase, res.StartQuote
+		return res
+	}
+	res.StartMid = midPrice(depths[0])
+
+	r := NewRunner(ds.symbol, ex, ds, ds.period)
+	periodMs := int64(ds.period) * 1000
+	var lastCycle int64
+	for i, d := range depths {
+		ex.Update(d)
+		if i == 0 || d.Ts-lastCycle >= periodMs {
+			r.runOnce()
+			lastCycle = d.Ts
+			res.Cycles++
+		}
+	}
+	ds.cancelBuyOrder()
+	ds.cancelSellOrder()
+
+	for _, f := range ex.Fills() {
+		if f.Symbol != ds.symbol {
+			continue
+		}
+		if f.Side == client.BUY {
+			res.BuyFills++
+			res.Bought = res.Bought.Add(f.Amount)
+			res.Fees = res.Fees.Add(f.Fee.Mul(f.Price))
+		} else {
+			res.SellFills++
+			res.Sold = res.Sold.Add(f.Amount)
+			res.Fees = res.Fees.Add(f.Fee)
+		}
+	}
+
+	res.EndMid = midPrice(depths[len(depths)-1])
+	ds.pnl.Mark(res.EndMid)
+	res.Accounting = ds.PnL()
+	res.EndBase = totalBalance(ex, base)
+	res.EndQuote = totalBalance(ex, quote)
+	return res
+}
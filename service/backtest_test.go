@@ -0,0 +1,90 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//flatBook 生成以bid为买一、bid+0.01为卖一，各20档的fcoin扁平深度
+func flatBook(bid float64) ([]float64, []float64) {
+	bids := make([]float64, 0, 40)
+	asks := make([]float64, 0, 40)
+	for i := 0; i < 20; i++ {
+		bids = append(bids, bid-float64(i)*0.01, 10)
+		asks = append(asks, bid+0.01+float64(i)*0.01, 10)
+	}
+	return bids, asks
+}
+
+func joinFloats(fs []float64) string {
+	ss := make([]string, len(fs))
+	for i, f := range fs {
+		ss[i] = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strings.Join(ss, " ")
+}
+
+func TestLoadDepths_CSV(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "qt")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "eosusdt.csv")
+	bids, asks := flatBook(5)
+	content := "ts,seq,bids,asks\n1000,1," + joinFloats(bids) + "," + joinFloats(asks) + "\n"
+	ioutil.WriteFile(path, []byte(content), 0644)
+
+	depths, err := LoadDepths(path, "eosusdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(depths) != 1 || len(depths[0].Bids) != 20 || depths[0].Ts != 1000 {
+		t.Fatalf("unexpected depths %v", depths)
+	}
+	if !depths[0].Asks[0].Price.Equal(dec("5.01")) {
+		t.Fatalf("best ask %s", depths[0].Asks[0].Price)
+	}
+}
+
+func TestRunBacktest(t *testing.T) {
+	//价格从5涨到5.1再跌回5，一来一回买卖单都能成交
+	mids := []float64{5, 5, 5.1, 5.1, 5, 5}
+	depths := make([]*Depth, 0, len(mids))
+	for i, m := range mids {
+		d := &client.Depth{}
+		d.Data.Bids, d.Data.Asks = flatBook(m)
+		d.Data.Ts = int64(i) * 2000
+		depths = append(depths, ConvertDepth("eosusdt", d))
+	}
+
+	ex := NewSnapshotExchange(dec("0.001"))
+	ex.AddMarket("eosusdt", "eos", "usdt")
+	ex.SetBalance("usdt", dec("50"))
+	ex.SetBalance("eos", dec("10"))
+	ds := NewDigService("eosusdt", dec("50"), dec("0.01"), dec("0.01"), 4, 3, ex, 5, 5, 2, "2")
+
+	res := RunBacktest(ds, ex, depths)
+	if res.Cycles != len(mids) {
+		t.Fatalf("cycles %d", res.Cycles)
+	}
+	if res.SellFills == 0 || res.BuyFills == 0 {
+		t.Fatalf("both sides should fill,%v", res)
+	}
+	if !res.Fees.IsPositive() {
+		t.Fatalf("fees should be charged,%v", res)
+	}
+	if bal := totalBalance(ex, "usdt"); !bal.Equal(res.EndQuote) {
+		t.Fatalf("end quote %s, balance %s", res.EndQuote, bal)
+	}
+	//没有挂单残留
+	bals, _ := ex.GetBalances()
+	for _, b := range bals {
+		if !b.Frozen.IsZero() {
+			t.Fatalf("%s still frozen %s", b.Currency, b.Frozen)
+		}
+	}
+	t.Log(res)
+}
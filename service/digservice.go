@@ -1,8 +1,10 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/MrChang666/qt/config"
 	"github.com/shopspring/decimal"
 	log "github.com/sirupsen/logrus"
 	"strings"
@@ -19,82 +21,186 @@ type DigService struct {
 	balance         decimal.Decimal //允许使用的金额，比如100
 	assetPrecision  int32
 	pricepPrecision int32
-	fcClient        *client.FCoinClient
-	buyOrderResult  *client.OrderResult
-	sellOrderResult *client.OrderResult
+	ex              Exchange
+	buyOrders       []*OrderResult //按梯度从近到远排列
+	sellOrders      []*OrderResult
 	minBalance      decimal.Decimal
 	minAsset        decimal.Decimal
 	buyLevel        int
 	sellLevel       int
 	period          int
 	bySide          string
+	runner          *Runner
+	orphanPolicy    string
+	reconcileRetry  RetryPolicy //启动时查询挂单的重试
+	pnl             *PnL
+	skew            *InventorySkew
+	spread          *SpreadQuoting
+	volBps          decimal.Decimal
+	volUpdated      time.Time
+	priceTolerance  decimal.Decimal //基点
+	sizeTolerance   decimal.Decimal //比例
+	ladder          *Ladder
 }
 
-func NewDigService(symbol string, balance, minBalance, minAsset decimal.Decimal, assetPrecision, pricepPrecision int32, fcClient *client.FCoinClient, sellLevel, buyLevel, period int, bySide string) *DigService {
+func NewDigService(symbol string, balance, minBalance, minAsset decimal.Decimal, assetPrecision, pricepPrecision int32, ex Exchange, sellLevel, buyLevel, period int, bySide string) *DigService {
 	ds := &DigService{
 		symbol:          symbol,
 		balance:         balance,
 		assetPrecision:  assetPrecision,
 		pricepPrecision: pricepPrecision,
-		fcClient:        fcClient,
+		ex:              ex,
 		minBalance:      minBalance,
 		minAsset:        minAsset,
 		buyLevel:        buyLevel,
 		sellLevel:       sellLevel,
 		period:          period,
 		bySide:          bySide,
+		orphanPolicy:    ORPHAN_IGNORE,
+		reconcileRetry:  RetryPolicy{MaxRetries: RECONCILE_RETRIES, BaseDelay: RECONCILE_DELAY, MaxDelay: RECONCILE_MAX_DELAY},
+		pnl:             NewPnL(symbol, PNL_AVERAGE),
 	}
 	return ds
 }
 
+//SetPnLMethod 设置持仓成本的计算方式，PNL_AVERAGE或PNL_FIFO，需要在Run之前调用
+func (ds *DigService) SetPnLMethod(method string) {
+	ds.pnl = NewPnL(ds.symbol, method)
+}
+
+//SetInventorySkew 开启按持仓调整报价，nil为关闭
+func (ds *DigService) SetInventorySkew(skew *InventorySkew) {
+	ds.skew = skew
+}
+
+//Reconfigure 按qt.yml中的配置修改参数，实现Reconfigurable。挂着的订单在下一个周期按新报价撤单重挂，
+//持仓成本的计算方式不在运行中修改
+func (ds *DigService) Reconfigure(sc *config.SymbolConfig) {
+	ds.balance = sc.Balance
+	ds.minBalance = sc.MinBalance
+	ds.minAsset = sc.MinAsset
+	ds.assetPrecision = sc.AssetPrecision
+	ds.pricepPrecision = sc.PricePrecision
+	ds.buyLevel = sc.BuyLevel
+	ds.sellLevel = sc.SellLevel
+	ds.period = sc.Period
+	ds.bySide = sc.BySide
+	ds.SetOrphanPolicy(sc.OrphanPolicy)
+	if sc.PnLMethod != ds.pnl.method {
+		log.Warnf("%s,pnlMethod change to %s takes effect after a restart", ds.symbol, sc.PnLMethod)
+	}
+	ds.SetInventorySkew(nil)
+	if sk := sc.Skew; sk != nil {
+		ds.SetInventorySkew(&InventorySkew{Target: sk.Target, Band: sk.Band, MaxLevels: sk.MaxLevels})
+	}
+	ds.SetAmendTolerance(sc.PriceTolerance, sc.SizeTolerance)
+	ds.SetSpreadQuoting(nil)
+	if sp := sc.Spread; sp != nil {
+		ds.SetSpreadQuoting(&SpreadQuoting{Bps: sp.Bps, VolResolution: sp.VolResolution, VolWindow: sp.VolWindow, VolMultiplier: sp.VolMultiplier, MaxBps: sp.MaxBps})
+	}
+	//波动率参数可能变了，下一次报价时重新计算
+	ds.volUpdated = time.Time{}
+	ds.SetLadder(nil)
+	if l := sc.Ladder; l != nil {
+		ds.SetLadder(&Ladder{Count: l.Count, LevelStep: l.Step, StepBps: l.StepBps, Weighting: l.Weighting, Ratio: l.Ratio})
+	}
+}
+
+//PnL 当前的盈亏
+func (ds *DigService) PnL() PnLSnapshot {
+	return ds.pnl.Snapshot()
+}
+
+//Init 绑定Runner，实现Strategy
+func (ds *DigService) Init(r *Runner) {
+	ds.runner = r
+}
+
+//OnStart 找回上次运行留下的订单，查询不到交易所上的挂单时返回错误
+func (ds *DigService) OnStart() error {
+	return ds.Reconcile()
+}
+
+func (ds *DigService) OnTimer(now time.Time) {
+}
+
+//OnFill 记录一个已经成交的订单
+func (ds *DigService) OnFill(o *Order) {
+	log.Infof("side:%s,symbol:%s,price:%s,amount:%s", o.Side, o.Symbol, o.Price, o.Amount)
+	ds.pnl.OnFill(o.Side, o.FilledAmount, o.ExecutedValue, o.FillFees)
+	log.Infof("%s,pnl %v", ds.symbol, ds.pnl.Snapshot())
+}
+
 func handlePanic() {
 	if err := recover(); err != nil {
 		log.Error("panic err:", err)
 	}
 }
 
-func (ds *DigService) Run() {
-	for {
+//OnShutdown 撤掉买单和卖单，已经成交的会在撤单时记录下来
+func (ds *DigService) OnShutdown() {
+	log.Infof("%s,shutting down,cancel resting orders", ds.symbol)
+	ds.cancelBuyOrder()
+	ds.cancelSellOrder()
+	if len(ds.buyOrders) > 0 || len(ds.sellOrders) > 0 {
+		log.Errorf("%s,orders left on the book after shutdown,buy:%s,sell:%s", ds.symbol, orderIDs(ds.buyOrders), orderIDs(ds.sellOrders))
+	}
+	log.Infof("%s,final pnl %v", ds.symbol, ds.pnl.Snapshot())
+}
 
+//OnDepth 执行一个周期：按最新深度计算报价，挂单价格或数量变化超过容忍度的撤单重挂，
+//没有挂单的一侧新挂。取不到深度时撤掉所有挂单，深度不足时返回false
+func (ds *DigService) OnDepth(depth *Depth) bool {
+	if depth == nil {
 		ds.cancelBuyOrder()
 		ds.cancelSellOrder()
-		depth, err := ds.fcClient.GetDepth(ds.symbol, "L20")
-		if err != nil {
-			log.Error(err)
-			continue
-		}
+		return true
+	}
 
-		if depth == nil || len(depth.Data.Asks) < 30 || len(depth.Data.Bids) < 30 {
-			log.Error("depth data is not enough")
-			return
-		}
+	if len(depth.Asks) < 15 || len(depth.Bids) < 15 {
+		log.Error("depth data is not enough")
+		ds.cancelBuyOrder()
+		ds.cancelSellOrder()
+		return false
+	}
+	ds.pnl.Mark(midPrice(depth))
+	buy, sell := ds.quotes(depth)
+	ds.amend(depth, buy, sell)
 
-		//创建卖单
-		err = ds.createSellOrder(depth)
-		if err != nil {
-			log.Errorf("create buy order failed,%v", err)
-		}
+	//创建卖单
+	if err := ds.createSellOrder(depth, sell); err != nil {
+		ds.logCreateError(client.SELL, err)
+	}
 
-		//创建买单
-		err = ds.createBuyOrder(depth)
-		if err != nil {
-			log.Errorf("create buy order failed,%v", err)
-		}
+	//创建买单
+	if err := ds.createBuyOrder(depth, buy); err != nil {
+		ds.logCreateError(client.BUY, err)
+	}
+	return true
+}
 
-		time.Sleep(time.Second * time.Duration(ds.period))
+//logCreateError 余额不足和限频是正常情况，下一个周期会重新挂单
+func (ds *DigService) logCreateError(side string, err error) {
+	switch {
+	case errors.Is(err, ErrInsufficientBalance):
+		log.Warnf("%s,insufficient balance for %s orders,retry next cycle", ds.symbol, side)
+	case errors.Is(err, ErrRateLimited):
+		log.Warnf("%s,rate limited when creating %s orders,retry next cycle", ds.symbol, side)
+	default:
+		log.Errorf("create %s order failed,%v", side, err)
 	}
 }
 
 /**
 1、创建6-15之间的买单 12
 */
-func (ds *DigService) createBuyOrder(depth *client.Depth) error {
+func (ds *DigService) createBuyOrder(depth *Depth, q sideQuote) error {
 
-	if ds.buyOrderResult != nil {
+	if len(ds.buyOrders) > 0 {
 		return nil
 	}
 
-	if ds.bySide == "1" && ds.sellOrderResult != nil {
+	if ds.oneSide(client.BUY) {
 		log.Infof("%s,one side trade", ds.symbol)
 		return nil
 	}
@@ -104,9 +210,9 @@ func (ds *DigService) createBuyOrder(depth *client.Depth) error {
 		return fmt.Errorf("can't get usdt")
 	}
 
-	available, err := ds.fcClient.GetAvailableBalance(usdt)
+	available, err := GetAvailableBalance(ds.ex, usdt)
 	if err != nil {
-		return fmt.Errorf("get available failed,%v", err)
+		return fmt.Errorf("get available failed,%w", err)
 	}
 
 	//如果available小于minBalance，直接返回
@@ -114,44 +220,20 @@ func (ds *DigService) createBuyOrder(depth *client.Depth) error {
 		return nil
 	}
 
-	if available.GreaterThan(ds.balance) {
-		available = ds.balance
-	}
-
 	log.Debugf("%s,begin to create buy order", ds.symbol)
-	buyPrice := decimal.NewFromFloat(depth.Data.Bids[(ds.buyLevel-1)*2])
-
-	p := decimal.New(1, ds.assetPrecision)
-
-	assetAmt := available.Div(buyPrice)
-	assetAmt = assetAmt.Mul(p).Floor().Div(p)
-	//构建买单
-	newOrder := &client.NewOrder{
-		Amount:    assetAmt.String(),
-		OrderType: client.ORDER_TYPE_LIMIT, //限价limit 市价 market
-		Exchange:  client.EXCHANGE_MAIN,    //主板
-		Side:      client.BUY,              //sell buy
-		Symbol:    ds.symbol,
-		Price:     buyPrice.String(),
-	}
-	res, err := ds.fcClient.CreateOrder(newOrder)
-	if err != nil {
-		return err
-	}
-
-	if res.Status != client.ORDER_STATES_SUCCESS {
-		log.Errorf("%s,buy order failed,%v", ds.symbol, res)
-		return err
-	}
-
-	ds.buyOrderResult = res
+	ds.buyOrders, err = ds.placeOrders(client.BUY, ds.rungs(client.BUY, depth, q, available))
 	defer handlePanic()
 	return err
 }
 
-func (ds *DigService) createSellOrder(depth *client.Depth) error {
+func (ds *DigService) createSellOrder(depth *Depth, q sideQuote) error {
 
-	if ds.sellOrderResult != nil {
+	if len(ds.sellOrders) > 0 {
+		return nil
+	}
+
+	if ds.oneSide(client.SELL) {
+		log.Infof("%s,one side trade", ds.symbol)
 		return nil
 	}
 
@@ -160,9 +242,9 @@ func (ds *DigService) createSellOrder(depth *client.Depth) error {
 		return fmt.Errorf("can't get currency")
 	}
 
-	available, err := ds.fcClient.GetAvailableBalance(currency)
+	available, err := GetAvailableBalance(ds.ex, currency)
 	if err != nil {
-		return fmt.Errorf("get available failed,%v", err)
+		return fmt.Errorf("get available failed,%w", err)
 	}
 
 	//如果available小于minAsset，直接返回
@@ -171,93 +253,55 @@ func (ds *DigService) createSellOrder(depth *client.Depth) error {
 	}
 
 	log.Debugf("%s,begin to create sell order", ds.symbol)
-	sellPrice := decimal.NewFromFloat(depth.Data.Asks[(ds.sellLevel-1)*2])
-
-	p := decimal.New(1, ds.assetPrecision)
-
-	assetAmt := available
-	assetAmt = assetAmt.Mul(p).Floor().Div(p)
-	//构建订单
-	newOrder := &client.NewOrder{
-		Amount:    assetAmt.String(),
-		OrderType: client.ORDER_TYPE_LIMIT, //限价limit 市价 market
-		Exchange:  client.EXCHANGE_MAIN,    //主板
-		Side:      client.SELL,             //sell buy
-		Symbol:    ds.symbol,
-		Price:     sellPrice.String(),
-	}
-	res, err := ds.fcClient.CreateOrder(newOrder)
-	if err != nil {
-		return err
-	}
-
-	if res.Status != client.ORDER_STATES_SUCCESS {
-		log.Errorf("%s sell order failed,%v", ds.symbol, res)
-		return err
-	}
-
-	ds.sellOrderResult = res
+	ds.sellOrders, err = ds.placeOrders(client.SELL, ds.rungs(client.SELL, depth, q, available))
 	defer handlePanic()
 	return err
 }
 
-func (ds *DigService) cancelBuyOrder() {
-	if ds.buyOrderResult == nil {
-		return
+//oneSide bySide为1时同时只挂一侧，另一侧已经有挂单时返回true
+func (ds *DigService) oneSide(side string) bool {
+	if ds.bySide != "1" {
+		return false
 	}
-	log.Debug("begin to cancel buy order")
-	res, err := ds.fcClient.CancelOrder(ds.buyOrderResult.Data)
-	if err != nil {
-		log.Errorf("cancel buy order failed,%v", err)
-		return
+	if side == client.BUY {
+		return len(ds.sellOrders) > 0
 	}
-	if res.Status == client.ORDER_STATES_SUCCESS {
-		ds.buyOrderResult = nil
-	} else if res.Status == client.CANCEL_SUCCESS_ORDER {
-		//记录成交的情况
-		orderInfo, err := ds.fcClient.GetOrderById(ds.buyOrderResult.Data)
+	return len(ds.buyOrders) > 0
+}
 
+//placeOrders 按梯度从近到远逐个下单，出错时返回已经挂上的订单
+func (ds *DigService) placeOrders(side string, rs []rung) ([]*OrderResult, error) {
+	orders := make([]*OrderResult, 0, len(rs))
+	for _, r := range rs {
+		res, err := ds.runner.Place(side, r.price, r.amount)
 		if err != nil {
-			log.Errorf("get order info failed,%v", err)
+			return orders, err
 		}
-
-		log.Infof("side:%s,symbol:%s,price:%s,amount:%s", orderInfo.Data.Side, orderInfo.Data.Symbol, orderInfo.Data.Price, orderInfo.Data.Amount)
-
-		ds.buyOrderResult = nil
-	} else { //都是非正常情况
-		log.Errorf("cancel buy order error,%v", res)
+		orders = append(orders, res)
 	}
+	return orders, nil
+}
 
-	defer handlePanic()
+func (ds *DigService) cancelBuyOrder() {
+	ds.buyOrders = ds.cancelOrders(ds.buyOrders)
 }
 
 func (ds *DigService) cancelSellOrder() {
-	if ds.sellOrderResult == nil {
-		return
-	}
-	log.Debug("begin to cancel sell order")
-	res, err := ds.fcClient.CancelOrder(ds.sellOrderResult.Data)
-	if err != nil {
-		log.Errorf("cancel sell order failed,%v", err)
-		return
-	}
-	if res.Status == client.ORDER_STATES_SUCCESS {
-		ds.sellOrderResult = nil
-	} else if res.Status == client.CANCEL_SUCCESS_ORDER {
-		//记录成交的情况
-		orderInfo, err := ds.fcClient.GetOrderById(ds.sellOrderResult.Data)
+	ds.sellOrders = ds.cancelOrders(ds.sellOrders)
+}
 
-		if err != nil {
-			log.Errorf("get sell order info failed,%v", err)
+//cancelOrders 撤掉一侧的所有订单，返回没有撤掉的，Runner已经不再跟踪的订单直接去掉
+func (ds *DigService) cancelOrders(orders []*OrderResult) []*OrderResult {
+	left := orders[:0]
+	for _, res := range orders {
+		if ds.runner.Tracked(res.ID) && !ds.runner.Cancel(res.ID) {
+			left = append(left, res)
 		}
-
-		log.Infof("side:%s,symbol:%s,price:%s,amount:%s", orderInfo.Data.Side, orderInfo.Data.Symbol, orderInfo.Data.Price, orderInfo.Data.Amount)
-
-		ds.sellOrderResult = nil
-	} else { //都是非正常情况
-		log.Errorf("cancel sell order error,%v", res)
 	}
-	defer handlePanic()
+	if len(left) == 0 {
+		return nil
+	}
+	return left
 }
 
 //btcusdt  btcpax btctusd
@@ -281,3 +325,8 @@ func getCurrency(symbol string) string {
 	}
 	return ""
 }
+
+//SplitSymbol 拆分交易对，如eosusdt返回eos,usdt
+func SplitSymbol(symbol string) (string, string) {
+	return getCurrency(symbol), getUSDT(symbol)
+}
@@ -1,27 +1,102 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"github.com/MrChang666/fcoin-api-go/client"
 	"github.com/shopspring/decimal"
 	"testing"
+	"time"
 )
 
-func initClient() *client.FCoinClient {
-	baseUrl := "https://api.fcoin.com/v2"
-	assKey := ""
-	secretKey := ""
-	return client.NewFCoinClient(secretKey, assKey, baseUrl)
+func newTestDigService(ex Exchange) *DigService {
+	ds := NewDigService("eosusdt", dec("50"), dec("0.01"), dec("0.01"), 4, 3, ex, 5, 5, 2, "2")
+	NewRunner(ds.symbol, ex, ds, ds.period)
+	return ds
 }
 
 func TestDigService_Run(t *testing.T) {
-	fc := initClient()
-	depth, err := fc.GetDepth("btcusdt", "L20")
-	if err != nil {
-		t.Fatal(err)
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("4"))
+	ds := newTestDigService(se)
+
+	if !ds.runner.runOnce() {
+		t.Fatal("depth should be enough")
+	}
+	if len(ds.buyOrders) == 0 || len(ds.sellOrders) == 0 {
+		t.Fatal("both sides should be quoted")
+	}
+	buy, _ := se.GetOrder(ds.buyOrders[0].ID)
+	sell, _ := se.GetOrder(ds.sellOrders[0].ID)
+	//第5档：买4.96，卖5.05
+	if !buy.Price.Equal(dec("4.96")) || !buy.Amount.Equal(dec("10.0806")) {
+		t.Fatalf("buy order %v", buy)
+	}
+	if !sell.Price.Equal(dec("5.05")) || !sell.Amount.Equal(dec("4")) {
+		t.Fatalf("sell order %v", sell)
+	}
+
+	//别人把卖盘扫到5.05，我们的卖单成交
+	se.SubmitExternal("eosusdt", client.BUY, dec("5.05"), dec("60"))
+
+	ds.runner.runOnce()
+	if o, _ := se.GetOrder(sell.ID); o.State != client.FILLED {
+		t.Fatalf("sell order state %s", o.State)
+	}
+	if o, _ := se.GetOrder(buy.ID); o.State != client.ORDER_STATE_CANCEL {
+		t.Fatalf("buy order state %s", o.State)
+	}
+	if len(ds.sellOrders) > 0 {
+		t.Fatal("no eos left, sell order should not be recreated")
+	}
+	if len(ds.buyOrders) == 0 || ds.buyOrders[0].ID == buy.ID {
+		t.Fatal("buy order should be recreated")
+	}
+}
+
+func TestDigService_RunCancelsOrdersOnShutdown(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("4"))
+	ds := newTestDigService(se)
+	ds.period = 60
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ds.runner.Run(ctx)
+		close(done)
+	}()
+
+	for i := 0; i < 100; i++ {
+		if open, _ := se.GetOpenOrders("eosusdt"); len(open) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+
+	if open, _ := se.GetOpenOrders("eosusdt"); len(open) != 0 {
+		t.Fatalf("orders left on the book %v", open)
+	}
+	if usdt := balanceOf(t, se, "usdt"); !usdt.Frozen.IsZero() {
+		t.Fatalf("usdt still frozen %v", usdt)
+	}
+}
+
+func TestDigService_NotEnoughDepth(t *testing.T) {
+	se := NewSimExchange(decimal.Zero)
+	se.AddMarket("eosusdt", "eos", "usdt")
+	ds := newTestDigService(se)
+	if ds.runner.runOnce() {
+		t.Fatal("empty book should stop the service")
 	}
-	fmt.Println(len(depth.Data.Bids))
-	fmt.Println(depth.Data.Bids)
 }
 
 func TestDe(t *testing.T) {
@@ -35,25 +110,22 @@ func TestDe(t *testing.T) {
 	fmt.Println(assetAmt)
 }
 
-func TestCrateOrder(t *testing.T) {
-	fc := initClient()
-	buyPrice := decimal.NewFromFloat(9232.1)
-	balance := decimal.New(100, 0)
-	p := decimal.New(1, 4)
-
-	assetAmt := balance.Div(buyPrice)
-	assetAmt = assetAmt.Mul(p).Floor().Div(p)
-	newOrder := &client.NewOrder{
-		Amount:    assetAmt.String(),
-		OrderType: "limit", //限价limit 市价 market
-		Exchange:  "main",  //主板
-		Side:      "buy",   //sell buy
-		Symbol:    "btcusdt",
-		Price:     buyPrice.String(),
-	}
-	res, err := fc.CreateOrder(newOrder)
-	fmt.Println(res)
+func TestDigService_CancelPartialFill(t *testing.T) {
+	se := NewSimExchange(decimal.Zero)
+	se.AddMarket("eosusdt", "eos", "usdt")
+	se.SetBalance("usdt", dec("100"))
+	ds := newTestDigService(se)
+	res, err := ds.runner.Place(client.BUY, dec("4.5"), dec("4"))
 	if err != nil {
 		t.Fatal(err)
 	}
+	ds.buyOrders = []*OrderResult{res}
+	se.SubmitExternal("eosusdt", client.SELL, dec("4.5"), dec("1"))
+
+	//撤单成功前成交的1个要记入盈亏
+	ds.cancelBuyOrder()
+	ps := ds.PnL()
+	if len(ds.buyOrders) != 0 || ds.runner.Tracked(res.ID) || !ps.Inventory.Equal(dec("1")) || !ps.AvgCost.Equal(dec("4.5")) {
+		t.Fatalf("partial fill should be recorded on cancel,%v", ps)
+	}
 }
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const (
+	ORDER_NOT_FOUND     = 3003 //订单不存在
+	STATUS_RATE_LIMITED = 429  //请求过于频繁
+)
+
+//可以用errors.Is判断的错误类型
+var (
+	ErrNetwork             = errors.New("network error")
+	ErrEmptyResponse       = errors.New("empty response")
+	ErrRateLimited         = errors.New("rate limited")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrOrderNotFound       = errors.New("order not found")
+	ErrOrderClosed         = errors.New("order already closed") //订单已经成交或撤销，不能再撤
+)
+
+//NetworkError 请求没有发出去或者读取返回失败
+type NetworkError struct {
+	Endpoint string
+	Err      error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("%s,network error,%v", e.Endpoint, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+func (e *NetworkError) Is(target error) bool {
+	return target == ErrNetwork
+}
+
+//HTTPError 返回的HTTP状态码不是2xx，并且返回的内容中没有fcoin的状态码
+type HTTPError struct {
+	Endpoint   string
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s,http status %d,%s", e.Endpoint, e.StatusCode, e.Body)
+}
+
+func (e *HTTPError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrOrderNotFound:
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+//APIError fcoin返回的状态码不是0
+type APIError struct {
+	Endpoint string
+	Status   int
+	Msg      string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s,status:%d,%s", e.Endpoint, e.Status, e.Msg)
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrInsufficientBalance:
+		return e.Status == client.ORDER_INSUFFICIENT
+	case ErrOrderClosed:
+		return e.Status == client.CANCEL_SUCCESS_ORDER
+	case ErrOrderNotFound:
+		return e.Status == ORDER_NOT_FOUND
+	case ErrRateLimited:
+		return e.Status == STATUS_RATE_LIMITED
+	}
+	return false
+}
+
+//RetryPolicy 幂等请求失败后的重试，第n次重试前等待min(BaseDelay*2^n, MaxDelay)的一半到全部之间的随机时间
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+//Retryable 网络错误、限频和服务端5xx错误可以重试
+func Retryable(err error) bool {
+	var he *HTTPError
+	if errors.As(err, &he) && he.StatusCode >= http.StatusInternalServerError {
+		return true
+	}
+	return errors.Is(err, ErrNetwork) || errors.Is(err, ErrRateLimited) || errors.Is(err, ErrEmptyResponse)
+}
+
+//Backoff 第attempt次重试(从0开始)前等待的时间
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 0; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+//retry 按重试策略执行call，只用于幂等的请求。开始退出或者请求被中断后不再重试，返回最后一次的错误
+func (f *RestClient) retry(endpoint string, call func() ([]byte, error)) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		content, err := call()
+		if err == nil || attempt >= f.retryPolicy.MaxRetries || !Retryable(err) || f.requestContext().Err() != nil {
+			return content, err
+		}
+		d := f.retryPolicy.Backoff(attempt)
+		log.Warnf("%s failed,retry %d/%d in %v,%v", endpoint, attempt+1, f.retryPolicy.MaxRetries, d, err)
+		select {
+		case <-time.After(d):
+		case <-f.shutdown:
+			log.Warnf("%s,shutting down,give up retrying", endpoint)
+			return content, err
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+//fcoin的订单状态，client中只定义了一部分
+const (
+	ORDER_STATE_SUBMITTED        = "submitted"
+	ORDER_STATE_PARTIAL_CANCELED = "partial_canceled"
+)
+
+//Exchange 交易所的抽象，DigService只依赖这个接口。
+//交易所拒绝的下单、撤单不返回error，fcoin的状态码放在结果的Status中；error只表示请求本身失败，
+//比如网络错误、限频，可以用errors.Is判断。Runner.Place把拒绝的状态码转换成*APIError
+type Exchange interface {
+	//GetDepth 获取交易对的深度，档位从最优价开始排列
+	GetDepth(symbol string) (*Depth, error)
+	//GetBalances 获取账户所有币种的余额
+	GetBalances() ([]*Balance, error)
+	//CreateOrder 下单，Status沿用fcoin的状态码
+	CreateOrder(req *OrderRequest) (*OrderResult, error)
+	//CancelOrder 撤单，已经成交或撤销的订单返回client.CANCEL_SUCCESS_ORDER，需要查询订单确认是哪一种
+	CancelOrder(id string) (*CancelResult, error)
+	//GetOrder 查询订单详情
+	GetOrder(id string) (*Order, error)
+	//GetOpenOrders 查询交易对上还挂着(submitted,partial_filled)的订单
+	GetOpenOrders(symbol string) ([]*Order, error)
+}
+
+//Level 深度中的一档
+type Level struct {
+	Price  decimal.Decimal
+	Amount decimal.Decimal
+}
+
+type Depth struct {
+	Symbol string
+	Bids   []Level //买盘，价格从高到低
+	Asks   []Level //卖盘，价格从低到高
+	Ts     int64
+	Seq    int64
+}
+
+type Balance struct {
+	Currency  string          `json:"currency"`
+	Available decimal.Decimal `json:"available"`
+	Frozen    decimal.Decimal `json:"frozen"`
+}
+
+type OrderRequest struct {
+	Symbol string
+	Side   string //buy sell
+	Price  decimal.Decimal
+	Amount decimal.Decimal
+}
+
+type OrderResult struct {
+	ID     string
+	Status int
+	Msg    string
+}
+
+type CancelResult struct {
+	Status int
+}
+
+type Order struct {
+	ID            string
+	Symbol        string
+	Side          string
+	Type          string
+	State         string
+	Price         decimal.Decimal
+	Amount        decimal.Decimal
+	FilledAmount  decimal.Decimal
+	ExecutedValue decimal.Decimal
+	FillFees      decimal.Decimal
+	CreatedAt     int64
+}
+
+//GetAvailableBalance 从账户余额中找出某个币种的可用余额，没有该币种时返回0
+func GetAvailableBalance(ex Exchange, currency string) (decimal.Decimal, error) {
+	bals, err := ex.GetBalances()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return availableOf(bals, currency), nil
+}
+
+//availableOf 余额列表中某个币种的可用余额
+func availableOf(bals []*Balance, currency string) decimal.Decimal {
+	for _, b := range bals {
+		if b.Currency == currency {
+			return b.Available
+		}
+	}
+	return decimal.Zero
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+	"strconv"
+)
+
+const (
+	DEPTH_LEVEL       = "L20"
+	OPEN_ORDER_STATES = ORDER_STATE_SUBMITTED + "," + client.PARTIAL_FILLED
+	OPEN_ORDER_LIMIT  = 100 //每页最多返回的订单数
+)
+
+//FCoinExchange 把RestClient适配成Exchange
+type FCoinExchange struct {
+	fcClient *RestClient
+}
+
+func NewFCoinExchange(fcClient *RestClient) *FCoinExchange {
+	return &FCoinExchange{fcClient: fcClient}
+}
+
+func (fe *FCoinExchange) GetDepth(symbol string) (*Depth, error) {
+	d, err := fe.fcClient.GetDepth(symbol, DEPTH_LEVEL)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, fmt.Errorf("%s,depth is nil", symbol)
+	}
+	if d.Status != client.ORDER_STATES_SUCCESS {
+		return nil, fmt.Errorf("%s,get depth failed,status:%d", symbol, d.Status)
+	}
+	return ConvertDepth(symbol, d), nil
+}
+
+//ConvertDepth fcoin的深度是[价格,数量,价格,数量...]的扁平数组，转换成按档位排列
+func ConvertDepth(symbol string, d *client.Depth) *Depth {
+	return &Depth{
+		Symbol: symbol,
+		Bids:   toLevels(d.Data.Bids),
+		Asks:   toLevels(d.Data.Asks),
+		Ts:     d.Data.Ts,
+		Seq:    d.Data.Seq,
+	}
+}
+
+func toLevels(flat []float64) []Level {
+	levels := make([]Level, 0, len(flat)/2)
+	for i := 0; i+1 < len(flat); i += 2 {
+		levels = append(levels, Level{
+			Price:  decimal.NewFromFloat(flat[i]),
+			Amount: decimal.NewFromFloat(flat[i+1]),
+		})
+	}
+	return levels
+}
+
+func (fe *FCoinExchange) GetBalances() ([]*Balance, error) {
+	info, err := fe.fcClient.GetBalance()
+	if err != nil {
+		return nil, err
+	}
+	if info.Status != client.ORDER_STATES_SUCCESS {
+		return nil, fmt.Errorf("get balance failed,status:%d", info.Status)
+	}
+	bals := make([]*Balance, 0, len(info.Data))
+	for _, v := range info.Data {
+		available, err := decimal.NewFromString(v.Available)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s available failed,%v", v.Currency, err)
+		}
+		frozen, err := decimal.NewFromString(v.Frozen)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s frozen failed,%v", v.Currency, err)
+		}
+		bals = append(bals, &Balance{Currency: v.Currency, Available: available, Frozen: frozen})
+	}
+	return bals, nil
+}
+
+func (fe *FCoinExchange) CreateOrder(req *OrderRequest) (*OrderResult, error) {
+	newOrder := &client.NewOrder{
+		Amount:    req.Amount.String(),
+		OrderType: client.ORDER_TYPE_LIMIT, //限价limit 市价 market
+		Exchange:  client.EXCHANGE_MAIN,    //主板
+		Side:      req.Side,                //sell buy
+		Symbol:    req.Symbol,
+		Price:     req.Price.String(),
+	}
+	res, err := fe.fcClient.CreateOrder(newOrder)
+	var ae *APIError
+	if errors.As(err, &ae) {
+		//fcoin拒绝的下单按Exchange的约定放在Status中
+		return &OrderResult{Status: ae.Status, Msg: ae.Msg}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &OrderResult{ID: res.Data, Status: res.Status, Msg: res.Msg}, nil
+}
+
+func (fe *FCoinExchange) CancelOrder(id string) (*CancelResult, error) {
+	res, err := fe.fcClient.CancelOrder(id)
+	var ae *APIError
+	if errors.As(err, &ae) {
+		//和下单一样放在Status中
+		return &CancelResult{Status: ae.Status}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &CancelResult{Status: res.Status}, nil
+}
+
+func (fe *FCoinExchange) GetOrder(id string) (*Order, error) {
+	info, err := fe.fcClient.GetOrderById(id)
+	if err != nil {
+		return nil, err
+	}
+	if info.Status != client.ORDER_STATES_SUCCESS {
+		return nil, fmt.Errorf("get order %s failed,status:%d", id, info.Status)
+	}
+	d := info.Data
+	return &Order{
+		ID:            d.ID,
+		Symbol:        d.Symbol,
+		Side:          d.Side,
+		Type:          d.Type,
+		State:         d.State,
+		Price:         parseDecimal(d.Price),
+		Amount:        parseDecimal(d.Amount),
+		FilledAmount:  parseDecimal(d.FilledAmount),
+		ExecutedValue: parseDecimal(d.ExecutedValue),
+		FillFees:      parseDecimal(d.FillFees),
+		CreatedAt:     int64(d.CreatedAt),
+	}, nil
+}
+
+//GetOpenOrders fcoin每页最多返回OPEN_ORDER_LIMIT个订单，从新到旧排列，用before按创建时间往前翻页。
+//before取最后一个订单的创建时间加1，同一毫秒创建的订单不会漏掉，重复的去掉
+func (fe *FCoinExchange) GetOpenOrders(symbol string) ([]*Order, error) {
+	orders := make([]*Order, 0)
+	seen := make(map[string]bool)
+	before := ""
+	for {
+		list, err := fe.fcClient.GetOrders(symbol, OPEN_ORDER_STATES, before, OPEN_ORDER_LIMIT)
+		if err != nil {
+			return nil, err
+		}
+		if list.Status != client.ORDER_STATES_SUCCESS {
+			return nil, fmt.Errorf("%s,get open orders failed,status:%d", symbol, list.Status)
+		}
+		added := 0
+		for _, d := range list.Data {
+			before = strconv.FormatInt(d.CreatedAt+1, 10)
+			if seen[d.ID] {
+				continue
+			}
+			seen[d.ID] = true
+			added++
+			orders = append(orders, &Order{
+				ID:            d.ID,
+				Symbol:        d.Symbol,
+				Side:          d.Side,
+				Type:          d.Type,
+				State:         d.State,
+				Price:         parseDecimal(d.Price),
+				Amount:        parseDecimal(d.Amount),
+				FilledAmount:  parseDecimal(d.FilledAmount),
+				ExecutedValue: parseDecimal(d.ExecutedValue),
+				FillFees:      parseDecimal(d.FillFees),
+				CreatedAt:     d.CreatedAt,
+			})
+		}
+		if len(list.Data) < OPEN_ORDER_LIMIT || added == 0 {
+			return orders, nil
+		}
+	}
+}
+
+//GetCandles fcoin返回的k线从新到旧排列，id是k线开始的秒级时间戳
+func (fe *FCoinExchange) GetCandles(symbol, resolution string, limit int) ([]*Candle, error) {
+	c, err := fe.fcClient.GetCandle(symbol, resolution, strconv.Itoa(limit))
+	if err != nil {
+		return nil, err
+	}
+	if c.Status != client.ORDER_STATES_SUCCESS {
+		return nil, fmt.Errorf("%s,get candle failed,status:%d", symbol, c.Status)
+	}
+	candles := make([]*Candle, 0, len(c.Data))
+	for _, d := range c.Data {
+		candles = append(candles, &Candle{Ts: int64(d.ID) * 1000, Open: d.Open, Close: d.Close, High: d.High, Low: d.Low})
+	}
+	return candles, nil
+}
+
+//parseDecimal fcoin返回的数值都是字符串，空串或非法值按0处理
+func parseDecimal(s string) decimal.Decimal {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return decimal.Zero
+	}
+	return d
+}
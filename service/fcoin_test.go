@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConvertDepth(t *testing.T) {
+	d := &client.Depth{}
+	d.Data.Bids = []float64{9200.1, 0.5, 9200, 1.2}
+	d.Data.Asks = []float64{9201, 0.3, 9202.5, 2}
+	d.Data.Seq = 42
+
+	depth := ConvertDepth("btcusdt", d)
+	if len(depth.Bids) != 2 || len(depth.Asks) != 2 {
+		t.Fatalf("levels not converted,%v", depth)
+	}
+	if depth.Bids[1].Price.String() != "9200" || depth.Bids[1].Amount.String() != "1.2" {
+		t.Fatalf("bid level 2 is %v", depth.Bids[1])
+	}
+	if depth.Asks[0].Price.String() != "9201" || depth.Seq != 42 {
+		t.Fatalf("unexpected asks,%v", depth)
+	}
+}
+
+func TestFCoinExchange_RateLimit(t *testing.T) {
+	paths := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		paths <- req.URL.Path
+		if strings.HasPrefix(req.URL.Path, "/market/depth") {
+			w.Write([]byte(`{"status":0,"data":{"bids":[5,1],"asks":[5.01,1],"seq":1}}`))
+			return
+		}
+		w.Write([]byte(`{"status":0,"data":{"id":"1","state":"submitted"}}`))
+	}))
+	defer srv.Close()
+
+	fc := NewRestClient("secret", "key", srv.URL)
+	fc.SetRateLimits(NewLimiter(100, 1), NewLimiter(20, 1))
+	fe := NewFCoinExchange(fc)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := fe.GetDepth("eosusdt"); err != nil {
+			t.Fatal(err)
+		}
+		<-paths
+	}
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Fatalf("5 requests at 100/s took %v", elapsed)
+	}
+
+	//令牌用完后，先等待的查询要排在后来的撤单后面
+	fe.GetOrder("1")
+	<-paths
+	go fe.GetOrder("2")
+	time.Sleep(10 * time.Millisecond)
+	go fe.CancelOrder("3")
+	if first, second := <-paths, <-paths; !strings.HasSuffix(first, "/submit-cancel") || second != "/orders/2" {
+		t.Fatalf("cancel should go first,got %s,%s", first, second)
+	}
+}
+
+func TestFCoinExchange_Errors(t *testing.T) {
+	var depthCalls, createCalls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case strings.HasPrefix(req.URL.Path, "/market/depth/L20/eosusdt"):
+			//前两次失败，第三次成功
+			if atomic.AddInt32(&depthCalls, 1) < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte(`{"status":0,"data":{"bids":[5,1],"asks":[5.01,1],"seq":1}}`))
+		case strings.HasPrefix(req.URL.Path, "/market/depth/L20/btcusdt"):
+			w.WriteHeader(http.StatusTooManyRequests)
+		case req.URL.Path == "/orders":
+			atomic.AddInt32(&createCalls, 1)
+			w.Write([]byte(`{"status":1016,"msg":"account balance insufficient"}`))
+		case strings.HasSuffix(req.URL.Path, "/submit-cancel"):
+			w.Write([]byte(`{"status":3008,"msg":"submit cancel invalid order state"}`))
+		case strings.HasPrefix(req.URL.Path, "/orders/"):
+			//返回为空
+		}
+	}))
+	defer srv.Close()
+
+	fc := NewRestClient("secret", "key", srv.URL)
+	fc.SetRetryPolicy(RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond})
+	fe := NewFCoinExchange(fc)
+	if _, err := fe.GetDepth("eosusdt"); err != nil || depthCalls != 3 {
+		t.Fatalf("depth should succeed after 2 retries,calls %d,%v", depthCalls, err)
+	}
+	_, err := fe.GetDepth("btcusdt")
+	var he *HTTPError
+	if !errors.Is(err, ErrRateLimited) || !errors.As(err, &he) || he.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("429 should be rate limited,%v", err)
+	}
+	if _, err = fe.GetOrder("1"); !errors.Is(err, ErrEmptyResponse) {
+		t.Fatalf("empty body should be an error,%v", err)
+	}
+
+	//fcoin拒绝的下单和撤单按Exchange的约定放在结果中，Runner再转换成错误
+	if res, err := fe.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.BUY, Price: dec("5"), Amount: dec("1")}); err != nil || res.Status != 1016 || createCalls != 1 {
+		t.Fatalf("create should not be retried,calls %d,%v,%v", createCalls, res, err)
+	}
+	if res, err := fe.CancelOrder("1"); err != nil || res.Status != client.CANCEL_SUCCESS_ORDER {
+		t.Fatalf("cancel result %v,%v", res, err)
+	}
+	r := NewRunner("eosusdt", fe, &recordingStrategy{}, 1)
+	if _, err = r.Place(client.BUY, dec("5"), dec("1")); !errors.Is(err, ErrInsufficientBalance) || createCalls != 2 {
+		t.Fatalf("place should report insufficient balance,%v", err)
+	}
+}
+
+func TestFCoinExchange_Shutdown(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	fc := NewRestClient("secret", "key", srv.URL)
+	fc.SetRetryPolicy(RetryPolicy{MaxRetries: 5, BaseDelay: time.Hour, MaxDelay: time.Hour})
+	shutdown := make(chan struct{})
+	close(shutdown)
+	fc.SetShutdown(shutdown)
+	//开始退出后失败的撤单不再等待重试
+	fe := NewFCoinExchange(fc)
+	if _, err := fe.CancelOrder("1"); err == nil || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("cancel should give up after one call,calls %d,%v", calls, err)
+	}
+}
+
+func TestFCoinExchange_GetOpenOrders(t *testing.T) {
+	//250个挂单，每两个在同一毫秒创建，从新到旧返回
+	var pages int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&pages, 1)
+		before, err := strconv.ParseInt(req.URL.Query().Get("before"), 10, 64)
+		if err != nil {
+			before = 1 << 40
+		}
+		limit, _ := strconv.Atoi(req.URL.Query().Get("limit"))
+		data := make([]string, 0, limit)
+		for i := 0; i < 250 && len(data) < limit; i++ {
+			if created := int64(10000 - i/2); created < before {
+				data = append(data, fmt.Sprintf(`{"id":"%d","symbol":"eosusdt","side":"buy","state":"submitted","created_at":%d}`, i, created))
+			}
+		}
+		w.Write([]byte(`{"status":0,"data":[` + strings.Join(data, ",") + `]}`))
+	}))
+	defer srv.Close()
+
+	fe := NewFCoinExchange(NewRestClient("secret", "key", srv.URL))
+	orders, err := fe.GetOpenOrders("eosusdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]bool)
+	for _, o := range orders {
+		ids[o.ID] = true
+	}
+	if len(orders) != 250 || len(ids) != 250 || pages < 3 {
+		t.Fatalf("%d orders,%d unique,%d pages", len(orders), len(ids), pages)
+	}
+}
+
+func TestFCoinExchange_Abort(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	fc := NewRestClient("secret", "key", srv.URL)
+	ctx, abort := context.WithCancel(context.Background())
+	fc.SetContext(ctx)
+	time.AfterFunc(20*time.Millisecond, abort)
+	//退出超时后还在进行的请求立即返回
+	start := time.Now()
+	if _, err := NewFCoinExchange(fc).GetOrder("1"); !errors.Is(err, ErrNetwork) || time.Since(start) > time.Second {
+		t.Fatalf("aborted request should fail at once,%v", err)
+	}
+
+	//令牌用完时等待中的撤单也立即返回
+	fc = NewRestClient("secret", "key", srv.URL)
+	private := NewLimiter(0.1, 1)
+	private.Wait(context.Background(), PRIORITY_LOW)
+	fc.SetRateLimits(nil, private)
+	ctx, abort = context.WithCancel(context.Background())
+	fc.SetContext(ctx)
+	time.AfterFunc(20*time.Millisecond, abort)
+	start = time.Now()
+	if _, err := NewFCoinExchange(fc).CancelOrder("1"); !errors.Is(err, context.Canceled) || time.Since(start) > time.Second {
+		t.Fatalf("cancel waiting for a token should fail at once,%v", err)
+	}
+}
@@ -0,0 +1,301 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	FEED_MAX_AGE             = 10 * time.Second //本地深度超过这个时间没有更新时改用REST查询
+	FEED_PING_INTERVAL       = 20 * time.Second
+	FEED_TRADES              = 100 //每个交易对保留的最近成交
+	FEED_RECONNECT_DELAY     = time.Second
+	FEED_RECONNECT_MAX_DELAY = time.Minute
+)
+
+//Trade 推送的一笔公开成交
+type Trade struct {
+	ID     int64
+	Symbol string
+	Side   string
+	Price  decimal.Decimal
+	Amount decimal.Decimal
+	Ts     int64
+}
+
+//marketStream 行情推送的连接，*Stream实现了它
+type marketStream interface {
+	Subscribe(topics ...string) error
+	Ping() error
+	Read() (*StreamMessage, error)
+	Close() error
+}
+
+//MarketFeed 用websocket推送维护每个交易对的本地深度和最近成交，包装Exchange：GetDepth在本地深度
+//新鲜时直接返回，没有连接或者深度太旧时用REST查询。第一次查询或Watch的交易对自动订阅，
+//断线后按退避时间重连并重新订阅，深度的seq回退或重复时丢弃，跳号时计数
+type MarketFeed struct {
+	Exchange
+	url      string
+	dial     func(url string) (marketStream, error)
+	backoff  RetryPolicy
+	maxAge   time.Duration
+	now      func() time.Time
+	mu       sync.Mutex
+	stream   marketStream //已经连接时不为nil
+	symbols  map[string]bool
+	books    map[string]*feedBook
+	watchers map[string][]chan struct{}
+}
+
+type feedBook struct {
+	depth   *Depth
+	updated time.Time
+	trades  []*Trade
+}
+
+//NewMarketFeed url为空时使用WS_URL，需要调用Run才会连接
+func NewMarketFeed(ex Exchange, url string) *MarketFeed {
+	return &MarketFeed{
+		Exchange: ex,
+		url:      url,
+		dial: func(url string) (marketStream, error) {
+			return DialStream(url)
+		},
+		backoff:  RetryPolicy{BaseDelay: FEED_RECONNECT_DELAY, MaxDelay: FEED_RECONNECT_MAX_DELAY},
+		maxAge:   FEED_MAX_AGE,
+		now:      time.Now,
+		symbols:  make(map[string]bool),
+		books:    make(map[string]*feedBook),
+		watchers: make(map[string][]chan struct{}),
+	}
+}
+
+func feedTopics(symbol string) []string {
+	return []string{DepthTopic(symbol, DEPTH_LEVEL), TradeTopic(symbol)}
+}
+
+//subscribe 记下交易对，已经连接时立即订阅，否则在连接后订阅。发送订阅时不持有锁，连接慢时不影响读取深度
+func (mf *MarketFeed) subscribe(symbol string) {
+	mf.mu.Lock()
+	if mf.symbols[symbol] {
+		mf.mu.Unlock()
+		return
+	}
+	mf.symbols[symbol] = true
+	s := mf.stream
+	mf.mu.Unlock()
+	if s != nil {
+		if err := s.Subscribe(feedTopics(symbol)...); err != nil {
+			log.Errorf("%s,subscribe market feed failed,%v", symbol, err)
+		}
+	}
+}
+
+//Run 连接并读取推送，断开后重连，ctx结束时返回
+func (mf *MarketFeed) Run(ctx context.Context) {
+	for attempt := 0; ; attempt++ {
+		received, err := mf.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			attempt = 0
+		}
+		d := mf.backoff.Backoff(attempt)
+		feedReconnects.With().Inc()
+		log.Warnf("market feed disconnected,reconnect in %v,%v", d, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d):
+		}
+	}
+}
+
+//session 一次连接，返回是否收到过推送以及断开的原因
+func (mf *MarketFeed) session(ctx context.Context) (bool, error) {
+	s, err := mf.dial(mf.url)
+	if err != nil {
+		return false, err
+	}
+	mf.mu.Lock()
+	mf.stream = s
+	var topics []string
+	for symbol := range mf.symbols {
+		topics = append(topics, feedTopics(symbol)...)
+	}
+	mf.mu.Unlock()
+	sort.Strings(topics)
+	defer func() {
+		//断线期间的深度不可信，重连后等新的推送
+		mf.mu.Lock()
+		mf.stream = nil
+		for _, b := range mf.books {
+			b.depth = nil
+		}
+		mf.mu.Unlock()
+		s.Close()
+	}()
+	if len(topics) > 0 {
+		if err = s.Subscribe(topics...); err != nil {
+			return false, err
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(FEED_PING_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				s.Close()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := s.Ping(); err != nil {
+					log.Warnf("market feed ping failed,%v", err)
+				}
+			}
+		}
+	}()
+
+	received := false
+	for {
+		msg, err := s.Read()
+		var me *StreamMessageError
+		if errors.As(err, &me) {
+			//一条内容不对的推送不影响连接
+			log.Warnf("market feed,skip %v", me)
+			received = true
+			continue
+		}
+		if err != nil {
+			return received, err
+		}
+		received = true
+		mf.handle(msg)
+	}
+}
+
+func (mf *MarketFeed) handle(msg *StreamMessage) {
+	switch msg.Kind {
+	case STREAM_DEPTH:
+		mf.onDepth(ConvertDepth(msg.Symbol, msg.Depth))
+	case STREAM_TRADE:
+		t := msg.Trade
+		mf.onTrade(&Trade{ID: t.Id, Symbol: msg.Symbol, Side: t.Side, Price: decimal.NewFromFloat(t.Price), Amount: decimal.NewFromFloat(t.Amount), Ts: t.Ts})
+	case STREAM_TOPICS:
+		log.Infof("market feed subscribed %v", msg.Topics)
+	case STREAM_HELLO, STREAM_PING:
+	default:
+		log.Debugf("market feed,unhandled message %s", msg.Raw)
+	}
+}
+
+func (mf *MarketFeed) book(symbol string) *feedBook {
+	b, ok := mf.books[symbol]
+	if !ok {
+		b = &feedBook{}
+		mf.books[symbol] = b
+	}
+	return b
+}
+
+//onDepth 推送的L20是完整的快照，跳号时只计数，新的快照仍然可用
+func (mf *MarketFeed) onDepth(d *Depth) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	b := mf.book(d.Symbol)
+	if b.depth != nil {
+		if d.Seq <= b.depth.Seq {
+			log.Debugf("%s,drop stale depth,seq %d after %d", d.Symbol, d.Seq, b.depth.Seq)
+			return
+		}
+		if d.Seq > b.depth.Seq+1 {
+			feedGaps.With(d.Symbol).Inc()
+			log.Debugf("%s,depth seq gap %d->%d", d.Symbol, b.depth.Seq, d.Seq)
+		}
+	}
+	b.depth = d
+	b.updated = mf.now()
+	for _, ch := range mf.watchers[d.Symbol] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (mf *MarketFeed) onTrade(t *Trade) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	b := mf.book(t.Symbol)
+	b.trades = append(b.trades, t)
+	if len(b.trades) > FEED_TRADES {
+		b.trades = append(b.trades[:0], b.trades[len(b.trades)-FEED_TRADES:]...)
+	}
+}
+
+//GetDepth 本地深度新鲜时直接返回，否则用REST查询
+func (mf *MarketFeed) GetDepth(symbol string) (*Depth, error) {
+	mf.subscribe(symbol)
+	mf.mu.Lock()
+	if b, ok := mf.books[symbol]; ok && mf.stream != nil && b.depth != nil && mf.now().Sub(b.updated) < mf.maxAge {
+		d := *b.depth
+		mf.mu.Unlock()
+		return &d, nil
+	}
+	mf.mu.Unlock()
+	return mf.Exchange.GetDepth(symbol)
+}
+
+//Trades 最近的成交，从旧到新排列，最多FEED_TRADES笔
+func (mf *MarketFeed) Trades(symbol string) []*Trade {
+	mf.subscribe(symbol)
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	b, ok := mf.books[symbol]
+	if !ok {
+		return nil
+	}
+	return append([]*Trade(nil), b.trades...)
+}
+
+//Watch 交易对的深度有更新时通知，多次更新合并成一次，返回的函数用来取消
+func (mf *MarketFeed) Watch(symbol string) (<-chan struct{}, func()) {
+	mf.subscribe(symbol)
+	ch := make(chan struct{}, 1)
+	mf.mu.Lock()
+	mf.watchers[symbol] = append(mf.watchers[symbol], ch)
+	mf.mu.Unlock()
+	return ch, func() {
+		mf.mu.Lock()
+		defer mf.mu.Unlock()
+		ws := mf.watchers[symbol]
+		for i, w := range ws {
+			if w == ch {
+				mf.watchers[symbol] = append(ws[:i:i], ws[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+//GetCandles k线直接取交易所的
+func (mf *MarketFeed) GetCandles(symbol, resolution string, limit int) ([]*Candle, error) {
+	cs, ok := mf.Exchange.(CandleSource)
+	if !ok {
+		return nil, fmt.Errorf("exchange does not provide candles")
+	}
+	return cs.GetCandles(symbol, resolution, limit)
+}
@@ -0,0 +1,323 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//wsTestServer 服务端的websocket握手，serve在握手后用连接收发帧
+func wsTestServer(t *testing.T, serve func(rw *bufio.ReadWriter)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h := sha1.Sum([]byte(req.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h[:]) + "\r\n\r\n")
+		rw.Flush()
+		serve(rw)
+	}))
+}
+
+//serverFrame 服务端发送的帧不带掩码
+func serverFrame(rw *bufio.ReadWriter, fin bool, op byte, payload string) {
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+	if len(payload) < 126 {
+		rw.Write([]byte{b0, byte(len(payload))})
+	} else {
+		rw.Write([]byte{b0, 126, byte(len(payload) >> 8), byte(len(payload))})
+	}
+	rw.WriteString(payload)
+	rw.Flush()
+}
+
+//clientFrame 读取客户端发送的帧并去掉掩码
+func clientFrame(rw *bufio.ReadWriter) (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(rw, head[:]); err != nil {
+		return 0, nil, err
+	}
+	if head[1]&0x80 == 0 {
+		return 0, nil, errors.New("client frame is not masked")
+	}
+	n := int(head[1] & 0x7f)
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(rw, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	var mask [4]byte
+	io.ReadFull(rw, mask[:])
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(rw, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return head[0] & 0x0f, payload, nil
+}
+
+func TestStream(t *testing.T) {
+	depth := `{"type":"depth.L20.eosusdt","ts":1523419946174,"seq":7,"bids":[5,1.5,4.99,2],"asks":[5.01,3]}`
+	srv := wsTestServer(t, func(rw *bufio.ReadWriter) {
+		serverFrame(rw, true, WS_OP_TEXT, `{"type":"hello","ts":1523419946174}`)
+		op, payload, err := clientFrame(rw)
+		cmd := struct {
+			Cmd  string
+			Args []string
+		}{}
+		json.Unmarshal(payload, &cmd)
+		if err != nil || op != WS_OP_TEXT || cmd.Cmd != "sub" || strings.Join(cmd.Args, ",") != "depth.L20.eosusdt,trade.eosusdt" {
+			t.Errorf("subscribe %d %s,%v", op, payload, err)
+			return
+		}
+		serverFrame(rw, true, WS_OP_TEXT, depth)
+		//ping需要回复同样内容的pong
+		serverFrame(rw, true, WS_OP_PING, "hb")
+		if op, payload, _ = clientFrame(rw); op != WS_OP_PONG || string(payload) != "hb" {
+			t.Errorf("pong %d %s", op, payload)
+		}
+		//分片的消息
+		serverFrame(rw, false, WS_OP_TEXT, `{"type":"trade.eosusdt","id":76,`)
+		serverFrame(rw, true, WS_OP_CONTINUATION, `"amount":1.5,"ts":1523419946175,"side":"sell","price":5.01}`)
+		serverFrame(rw, true, WS_OP_TEXT, `{"type":"depth.L20.eosusdt","bids":"x"}`)
+		serverFrame(rw, true, WS_OP_TEXT, `{"type":"topics","topics":["depth.L20.eosusdt","trade.eosusdt"],"id":"`+strings.Repeat("x", 200)+`"}`)
+		serverFrame(rw, true, WS_OP_CLOSE, "\x03\xe8")
+		clientFrame(rw)
+	})
+	defer srv.Close()
+
+	s, err := DialStream("ws" + strings.TrimPrefix(srv.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if msg, err := s.Read(); err != nil || msg.Kind != STREAM_HELLO {
+		t.Fatalf("hello %v,%v", msg, err)
+	}
+	if err = s.Subscribe(feedTopics("eosusdt")...); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := s.Read()
+	if err != nil || msg.Kind != STREAM_DEPTH || msg.Symbol != "eosusdt" {
+		t.Fatalf("depth %v,%v", msg, err)
+	}
+	if d := ConvertDepth(msg.Symbol, msg.Depth); d.Seq != 7 || len(d.Bids) != 2 || !d.Bids[1].Amount.Equal(dec("2")) || !d.Asks[0].Price.Equal(dec("5.01")) {
+		t.Fatalf("depth %+v", d)
+	}
+	if msg, err = s.Read(); err != nil || msg.Trade == nil || msg.Trade.Id != 76 || msg.Trade.Side != client.SELL {
+		t.Fatalf("trade %v,%v", msg, err)
+	}
+	//内容不对的推送返回StreamMessageError，连接可以继续读取
+	var me *StreamMessageError
+	if _, err = s.Read(); !errors.As(err, &me) || !strings.Contains(string(me.Raw), `"bids":"x"`) {
+		t.Fatalf("invalid message,%v", err)
+	}
+	if msg, err = s.Read(); err != nil || len(msg.Topics) != 2 {
+		t.Fatalf("topics %v,%v", msg, err)
+	}
+	if _, err = s.Read(); !errors.Is(err, ErrWsClosed) {
+		t.Fatalf("close,%v", err)
+	}
+}
+
+//fakeStream 用channel模拟的推送连接
+type fakeStream struct {
+	msgs   chan []byte
+	topics chan []string
+	closed chan struct{}
+}
+
+func newFakeStream() *fakeStream {
+	return &fakeStream{msgs: make(chan []byte, 10), topics: make(chan []string, 10), closed: make(chan struct{})}
+}
+
+func (fs *fakeStream) Subscribe(topics ...string) error {
+	fs.topics <- topics
+	return nil
+}
+func (fs *fakeStream) Ping() error { return nil }
+func (fs *fakeStream) Close() error {
+	select {
+	case <-fs.closed:
+	default:
+		close(fs.closed)
+	}
+	return nil
+}
+func (fs *fakeStream) Read() (*StreamMessage, error) {
+	select {
+	case content := <-fs.msgs:
+		msg, err := ParseStreamMessage(content)
+		if err != nil {
+			return nil, &StreamMessageError{Raw: content, Err: err}
+		}
+		return msg, nil
+	case <-fs.closed:
+		return nil, ErrWsClosed
+	}
+}
+
+func (fs *fakeStream) push(content string) {
+	fs.msgs <- []byte(content)
+}
+
+func (fs *fakeStream) subscribed(t *testing.T) string {
+	select {
+	case topics := <-fs.topics:
+		return strings.Join(topics, ",")
+	case <-time.After(time.Second):
+		t.Fatal("no subscription")
+		return ""
+	}
+}
+
+func feedDepth(seq int, bid string) string {
+	return `{"type":"depth.L20.eosusdt","ts":1,"seq":` + strconv.Itoa(seq) + `,"bids":[` + bid + `,1],"asks":[5.01,1]}`
+}
+
+func waitFor(t *testing.T, what string, ok func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMarketFeed(t *testing.T) {
+	se := newSimBook()
+	streams := make(chan *fakeStream, 2)
+	mf := NewMarketFeed(se, "")
+	mf.backoff = RetryPolicy{}
+	mf.dial = func(url string) (marketStream, error) {
+		return <-streams, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		mf.Run(ctx)
+		close(done)
+	}()
+
+	//没有连接时用REST，同时记下交易对，连接后订阅
+	if d, err := mf.GetDepth("eosusdt"); err != nil || len(d.Bids) != 20 {
+		t.Fatalf("rest depth %v,%v", d, err)
+	}
+	updates, stop := mf.Watch("eosusdt")
+	defer stop()
+	fs := newFakeStream()
+	streams <- fs
+	if topics := fs.subscribed(t); topics != "depth.L20.eosusdt,trade.eosusdt" {
+		t.Fatalf("topics %s", topics)
+	}
+
+	fs.push(feedDepth(10, "4.8"))
+	<-updates
+	if d, _ := mf.GetDepth("eosusdt"); d.Seq != 10 || len(d.Bids) != 1 {
+		t.Fatalf("feed depth %+v", d)
+	}
+	//seq回退的丢弃，跳号的计数后使用
+	gaps := feedGaps.With("eosusdt").Value()
+	fs.push(feedDepth(9, "4.7"))
+	fs.push(feedDepth(11, "4.9"))
+	//解析不了的推送跳过，不断开连接
+	fs.push(`{"type":"depth.L20.eosusdt","seq":"x"}`)
+	fs.push(feedDepth(14, "4.95"))
+	fs.push(`{"type":"trade.eosusdt","id":1,"amount":2,"ts":2,"side":"buy","price":5.01}`)
+	waitFor(t, "trade", func() bool { return len(mf.Trades("eosusdt")) == 1 })
+	mf.mu.Lock()
+	connected := mf.stream == fs
+	mf.mu.Unlock()
+	if !connected {
+		t.Fatal("invalid message should not end the session")
+	}
+	if d, _ := mf.GetDepth("eosusdt"); d.Seq != 14 || !d.Bids[0].Price.Equal(dec("4.95")) {
+		t.Fatalf("feed depth %+v", d)
+	}
+	if feedGaps.With("eosusdt").Value()-gaps != 1 {
+		t.Fatal("seq gap should be counted")
+	}
+
+	//深度太旧时改用REST
+	mf.mu.Lock()
+	mf.books["eosusdt"].updated = time.Now().Add(-FEED_MAX_AGE)
+	mf.mu.Unlock()
+	if d, _ := mf.GetDepth("eosusdt"); len(d.Bids) != 20 {
+		t.Fatalf("stale depth should fall back to rest,%+v", d)
+	}
+
+	//断线后改用REST，重连后重新订阅
+	fs.Close()
+	next := newFakeStream()
+	streams <- next
+	if topics := next.subscribed(t); topics != "depth.L20.eosusdt,trade.eosusdt" {
+		t.Fatalf("resubscribe %s", topics)
+	}
+	if d, _ := mf.GetDepth("eosusdt"); len(d.Bids) != 20 {
+		t.Fatalf("depth before the first push should come from rest,%+v", d)
+	}
+	//重连后seq从头开始也可以使用
+	next.push(feedDepth(3, "4.6"))
+	waitFor(t, "depth after reconnect", func() bool {
+		d, _ := mf.GetDepth("eosusdt")
+		return d.Seq == 3
+	})
+	//连接后新的交易对立即订阅
+	mf.Watch("btcusdt")
+	if topics := next.subscribed(t); topics != "depth.L20.btcusdt,trade.btcusdt" {
+		t.Fatalf("topics %s", topics)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestRunner_Feed(t *testing.T) {
+	se := newSimBook()
+	fs := newFakeStream()
+	mf := NewMarketFeed(se, "")
+	mf.dial = func(url string) (marketStream, error) {
+		return fs, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mf.Run(ctx)
+
+	rs := &recordingStrategy{}
+	r := NewRunner("eosusdt", mf, rs, 3600)
+	r.SetFeed(mf, 0)
+	go r.Run(ctx)
+	depths := func() int {
+		n := 0
+		r.Do(func() { n = rs.depths })
+		return n
+	}
+	fs.subscribed(t)
+	waitFor(t, "first cycle", func() bool { return depths() == 1 })
+	//有推送时不等period
+	fs.push(feedDepth(1, "5"))
+	waitFor(t, "cycle on push", func() bool { return depths() == 2 })
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//GridService 网格交易策略：在lower和upper之间等分levels格，每个格点挂amount个基础币的订单。
+//买单成交后在上一格挂卖单，卖单成交后在下一格挂买单。
+//挂着的订单保存在statePath中，退出或移除时撤单；keepOrders时不撤单，重启后接着跟踪
+type GridService struct {
+	symbol          string
+	lower           decimal.Decimal
+	upper           decimal.Decimal
+	levels          int
+	amount          decimal.Decimal
+	assetPrecision  int32
+	pricepPrecision int32
+	statePath       string
+	keepOrders      bool
+	stopping        bool //正在退出，成交后不再挂反向订单
+	runner          *Runner
+	pnl             *PnL
+	orders          []*gridOrder
+}
+
+//gridOrder 挂在某个格点上的订单，Level从0(lower)到levels(upper)。ID为空时下单失败，下一个周期重试
+type gridOrder struct {
+	ID    string `json:"id"`
+	Side  string `json:"side"`
+	Level int    `json:"level"`
+}
+
+//gridState 网格的持久化状态，网格参数变化后旧状态作废
+type gridState struct {
+	Symbol string          `json:"symbol"`
+	Lower  decimal.Decimal `json:"lower"`
+	Upper  decimal.Decimal `json:"upper"`
+	Levels int             `json:"levels"`
+	Orders []*gridOrder    `json:"orders"`
+}
+
+func NewGridService(symbol string, lower, upper, amount decimal.Decimal, levels int, assetPrecision, pricepPrecision int32, statePath string) *GridService {
+	return &GridService{
+		symbol:          symbol,
+		lower:           lower,
+		upper:           upper,
+		levels:          levels,
+		amount:          amount,
+		assetPrecision:  assetPrecision,
+		pricepPrecision: pricepPrecision,
+		statePath:       statePath,
+		pnl:             NewPnL(symbol, PNL_AVERAGE),
+	}
+}
+
+//Init 绑定Runner，实现Strategy
+func (gs *GridService) Init(r *Runner) {
+	gs.runner = r
+}
+
+//SetKeepOrders 退出时订单留在交易所上，重启后从statePath接着跟踪，需要配置statePath
+func (gs *GridService) SetKeepOrders(keep bool) {
+	gs.keepOrders = keep
+}
+
+//SetPnLMethod 设置持仓成本的计算方式，需要在Run之前调用
+func (gs *GridService) SetPnLMethod(method string) {
+	gs.pnl = NewPnL(gs.symbol, method)
+}
+
+//PnL 当前的盈亏
+func (gs *GridService) PnL() PnLSnapshot {
+	return gs.pnl.Snapshot()
+}
+
+//OnStart 读取上次运行保存的网格，文件不存在时从空网格开始；
+//网格参数和配置不一致时撤掉旧网格的订单重新开始
+func (gs *GridService) OnStart() error {
+	if gs.statePath == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(gs.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	st := &gridState{}
+	if err = json.Unmarshal(b, st); err != nil {
+		return fmt.Errorf("parse grid state %s failed,%v", gs.statePath, err)
+	}
+	if st.Symbol != gs.symbol || !st.Lower.Equal(gs.lower) || !st.Upper.Equal(gs.upper) || st.Levels != gs.levels {
+		log.Infof("%s,grid changed,cancel %d orders of the old grid", gs.symbol, len(st.Orders))
+		for _, g := range st.Orders {
+			if g.ID == "" {
+				continue
+			}
+			gs.runner.Track(g.ID, g.Side)
+			gs.runner.Cancel(g.ID)
+		}
+		gs.save()
+		return nil
+	}
+	gs.orders = st.Orders
+	for _, g := range gs.orders {
+		if g.ID != "" {
+			gs.runner.Track(g.ID, g.Side)
+		}
+	}
+	log.Infof("%s,restore %d grid orders", gs.symbol, len(gs.orders))
+	return nil
+}
+
+func (gs *GridService) save() {
+	if gs.statePath == "" {
+		return
+	}
+	b, err := json.MarshalIndent(&gridState{Symbol: gs.symbol, Lower: gs.lower, Upper: gs.upper, Levels: gs.levels, Orders: gs.orders}, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(gs.statePath), 0755)
+	}
+	//先写临时文件再改名，避免写到一半退出
+	tmp := gs.statePath + ".tmp"
+	if err == nil {
+		err = ioutil.WriteFile(tmp, b, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, gs.statePath)
+	}
+	if err != nil {
+		log.Errorf("%s,save grid state failed,%v", gs.symbol, err)
+	}
+}
+
+func (gs *GridService) OnTimer(now time.Time) {
+}
+
+//OnShutdown 撤掉网格订单，没有撤掉的保存下来，重启后接着跟踪；keepOrders时只保存网格
+func (gs *GridService) OnShutdown() {
+	if gs.keepOrders {
+		gs.save()
+		log.Infof("%s,grid stopped with %d orders on the book,final pnl %v", gs.symbol, len(gs.orders), gs.pnl.Snapshot())
+		return
+	}
+	log.Infof("%s,shutting down,cancel grid orders", gs.symbol)
+	gs.stopping = true
+	left := make([]*gridOrder, 0)
+	for _, g := range gs.orders {
+		if g.ID == "" || !gs.runner.Tracked(g.ID) || gs.runner.Cancel(g.ID) {
+			continue
+		}
+		left = append(left, g)
+	}
+	gs.orders = left
+	gs.save()
+	if len(left) > 0 {
+		log.Errorf("%s,%d grid orders left on the book after shutdown", gs.symbol, len(left))
+	}
+	log.Infof("%s,final pnl %v", gs.symbol, gs.pnl.Snapshot())
+}
+
+//price 第level格的价格
+func (gs *GridService) price(level int) decimal.Decimal {
+	step := gs.upper.Sub(gs.lower).Div(decimal.New(int64(gs.levels), 0))
+	return gs.lower.Add(step.Mul(decimal.New(int64(level), 0))).Round(gs.pricepPrecision)
+}
+
+//OnDepth 还没有网格时按中间价建立网格，否则每个周期查询一次挂单，只查询不在其中的订单，
+//成交的在OnFill中挂出反向订单，上次下单失败的格点重新下单
+func (gs *GridService) OnDepth(depth *Depth) bool {
+	defer handlePanic()
+	if depth == nil {
+		return true
+	}
+	mid := midPrice(depth)
+	gs.pnl.Mark(mid)
+	if len(gs.orders) == 0 {
+		gs.setup(mid)
+		return true
+	}
+
+	orders, err := gs.runner.Exchange().GetOpenOrders(gs.symbol)
+	if err != nil {
+		log.Errorf("%s,get open orders failed,%v", gs.symbol, err)
+		return true
+	}
+	open := make(map[string]bool, len(orders))
+	for _, o := range orders {
+		open[o.ID] = true
+	}
+
+	for _, g := range append([]*gridOrder(nil), gs.orders...) {
+		if g.ID == "" {
+			if gs.submit(g) {
+				gs.save()
+			}
+			continue
+		}
+		if !gs.runner.Tracked(g.ID) {
+			//qt自己撤掉的订单，比如暂停或者强制撤单，在原来的格点重新挂单
+			log.Infof("%s,grid %s order %s at level %d was cancelled by qt,place it again", gs.symbol, g.Side, g.ID, g.Level)
+			g.ID = ""
+			gs.submit(g)
+			gs.save()
+			continue
+		}
+		if open[g.ID] {
+			continue
+		}
+		o, err := gs.runner.Refresh(g.ID)
+		if err != nil {
+			log.Errorf("%s,get grid order %s failed,%v", gs.symbol, g.ID, err)
+			continue
+		}
+		if o.State == client.ORDER_STATE_CANCEL || o.State == ORDER_STATE_PARTIAL_CANCELED {
+			log.Errorf("%s,grid %s order %s at level %d was cancelled outside qt", gs.symbol, g.Side, g.ID, g.Level)
+			if gs.remove(g.ID) != nil {
+				gs.save()
+			}
+		}
+	}
+	return true
+}
+
+//OnFill 买单成交后在上一格卖出，卖单成交后在下一格买回
+func (gs *GridService) OnFill(o *Order) {
+	log.Infof("grid side:%s,symbol:%s,price:%s,amount:%s", o.Side, o.Symbol, o.Price, o.Amount)
+	gs.pnl.OnFill(o.Side, o.FilledAmount, o.ExecutedValue, o.FillFees)
+	log.Infof("%s,pnl %v", gs.symbol, gs.pnl.Snapshot())
+
+	//部分成交后撤销的留在网格中，在OnDepth中区分是qt自己撤的还是外部撤的
+	if o.State != client.FILLED {
+		return
+	}
+	g := gs.remove(o.ID)
+	if g == nil || gs.stopping {
+		return
+	}
+	level, side := g.Level+1, client.SELL
+	if g.Side == client.SELL {
+		level, side = g.Level-1, client.BUY
+	}
+	if level >= 0 && level <= gs.levels && !gs.occupied(level) {
+		gs.place(side, level)
+	}
+	gs.save()
+}
+
+//remove 不再跟踪某个订单，不在网格中时返回nil
+func (gs *GridService) remove(id string) *gridOrder {
+	for i, g := range gs.orders {
+		if g.ID == id {
+			gs.orders = append(gs.orders[:i:i], gs.orders[i+1:]...)
+			return g
+		}
+	}
+	return nil
+}
+
+//setup 建立网格：离中间价最近的格点空着，下方挂买单，上方挂卖单
+func (gs *GridService) setup(mid decimal.Decimal) {
+	if !mid.IsPositive() {
+		return
+	}
+	empty := 0
+	for i := 1; i <= gs.levels; i++ {
+		if gs.price(i).Sub(mid).Abs().LessThan(gs.price(empty).Sub(mid).Abs()) {
+			empty = i
+		}
+	}
+	log.Infof("%s,setup grid %s-%s,%d levels,mid:%s", gs.symbol, gs.lower, gs.upper, gs.levels, mid)
+	for i := 0; i <= gs.levels; i++ {
+		if i < empty {
+			gs.place(client.BUY, i)
+		} else if i > empty {
+			gs.place(client.SELL, i)
+		}
+	}
+	gs.save()
+}
+
+func (gs *GridService) occupied(level int) bool {
+	for _, g := range gs.orders {
+		if g.Level == level {
+			return true
+		}
+	}
+	return false
+}
+
+//place 在格点上挂单，失败时格点仍然留在网格中
+func (gs *GridService) place(side string, level int) {
+	g := &gridOrder{Side: side, Level: level}
+	gs.orders = append(gs.orders, g)
+	gs.submit(g)
+}
+
+//submit 为格点下单，成功后记下订单ID
+func (gs *GridService) submit(g *gridOrder) bool {
+	price := gs.price(g.Level)
+	p := decimal.New(1, gs.assetPrecision)
+	amount := gs.amount.Mul(p).Floor().Div(p)
+	res, err := gs.runner.Place(g.Side, price, amount)
+	if err != nil {
+		log.Errorf("%s,create grid %s order at %s failed,retry next cycle,%v", gs.symbol, g.Side, price, err)
+		return false
+	}
+	g.ID = res.ID
+	return true
+}
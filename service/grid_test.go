@@ -0,0 +1,230 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestGridService(se Exchange, statePath string) *GridService {
+	gs := NewGridService("eosusdt", dec("4.95"), dec("5.05"), dec("1"), 10, 4, 3, statePath)
+	NewRunner(gs.symbol, se, gs, 2)
+	return gs
+}
+
+func gridLevels(gs *GridService, side string) map[int]bool {
+	levels := make(map[int]bool)
+	for _, g := range gs.orders {
+		if g.Side == side {
+			levels[g.Level] = true
+		}
+	}
+	return levels
+}
+
+func TestGridService_Setup(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("10"))
+	gs := newTestGridService(se, "")
+	gs.runner.runOnce()
+
+	//中间价5.005，5.00这一格空着
+	buys, sells := gridLevels(gs, client.BUY), gridLevels(gs, client.SELL)
+	if len(buys) != 5 || len(sells) != 5 || buys[5] || sells[5] {
+		t.Fatalf("buys %v,sells %v", buys, sells)
+	}
+	for _, g := range gs.orders {
+		o, _ := se.GetOrder(g.ID)
+		if !o.Price.Equal(gs.price(g.Level)) || !o.Amount.Equal(dec("1")) {
+			t.Fatalf("level %d,%v", g.Level, o)
+		}
+	}
+}
+
+func TestGridService_CounterOrders(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("10"))
+	gs := newTestGridService(se, "")
+	gs.runner.runOnce()
+
+	//吃掉5.00和4.99的外部买单以及4.99上我们的买单
+	se.SubmitExternal("eosusdt", client.SELL, dec("4.99"), dec("21"))
+	gs.runner.runOnce()
+	if gridLevels(gs, client.BUY)[4] || !gridLevels(gs, client.SELL)[5] {
+		t.Fatalf("buy at 4.99 filled,sell at 5.00 expected,%v", gs.orders)
+	}
+	if ps := gs.PnL(); ps.Fills != 1 || !ps.Inventory.IsPositive() {
+		t.Fatalf("pnl %v", ps)
+	}
+
+	//卖单在5.00成交，再在4.99买回
+	se.SubmitExternal("eosusdt", client.BUY, dec("5"), dec("1"))
+	gs.runner.runOnce()
+	if !gridLevels(gs, client.BUY)[4] || gridLevels(gs, client.SELL)[5] {
+		t.Fatalf("sell at 5.00 filled,buy at 4.99 expected,%v", gs.orders)
+	}
+	if ps := gs.PnL(); ps.Fills != 2 || !ps.Realized.IsPositive() {
+		t.Fatalf("round trip should be profitable,%v", ps)
+	}
+}
+
+func TestGridService_RetryCounterOrder(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("5"))
+	gs := newTestGridService(se, "")
+	gs.runner.runOnce()
+
+	//4.99的买单成交后扣掉手续费不够1个，5.00的卖单挂不上，格点留着
+	se.SubmitExternal("eosusdt", client.SELL, dec("4.99"), dec("21"))
+	gs.runner.runOnce()
+	gs.runner.runOnce()
+	if !gridLevels(gs, client.SELL)[5] || len(gs.orders) != 10 {
+		t.Fatalf("failed counter order should stay pending,%v", gs.orders)
+	}
+	if open, _ := se.GetOpenOrders("eosusdt"); len(open) != 9 {
+		t.Fatalf("%d orders on the book", len(open))
+	}
+
+	//余额够了以后下一个周期挂上
+	se.SetBalance("eos", dec("1"))
+	gs.runner.runOnce()
+	for _, g := range gs.orders {
+		if g.Level == 5 {
+			if o, err := se.GetOrder(g.ID); err != nil || o.Side != client.SELL || !o.Price.Equal(dec("5")) {
+				t.Fatalf("counter order %v,%v", o, err)
+			}
+		}
+	}
+	if open, _ := se.GetOpenOrders("eosusdt"); len(open) != 10 {
+		t.Fatalf("%d orders on the book", len(open))
+	}
+}
+
+func TestGridService_ForceCancel(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("10"))
+	gs := newTestGridService(se, "")
+	gs.runner.runOnce()
+	buys, sells := gridLevels(gs, client.BUY), gridLevels(gs, client.SELL)
+
+	//暂停或强制撤单撤掉的订单不是外部撤的，下一个周期在原来的格点重新挂单，撤单只记一次
+	cancelled := ordersCancelled.With("eosusdt", client.BUY).Value() + ordersCancelled.With("eosusdt", client.SELL).Value()
+	if left := gs.runner.CancelAll(); left != 0 {
+		t.Fatalf("%d orders left", left)
+	}
+	gs.runner.runOnce()
+	if n := ordersCancelled.With("eosusdt", client.BUY).Value() + ordersCancelled.With("eosusdt", client.SELL).Value() - cancelled; n != 10 {
+		t.Fatalf("cancelled %v times", n)
+	}
+	if len(gs.orders) != 10 || !reflect.DeepEqual(gridLevels(gs, client.BUY), buys) || !reflect.DeepEqual(gridLevels(gs, client.SELL), sells) {
+		t.Fatalf("grid should be placed again,%v", gs.orders)
+	}
+	if open, _ := se.GetOpenOrders("eosusdt"); len(open) != 10 {
+		t.Fatalf("%d orders on the book", len(open))
+	}
+}
+
+func TestGridService_Persist(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("10"))
+	dir, _ := ioutil.TempDir("", "qt")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "grid-eosusdt.json")
+	gs := newTestGridService(se, path)
+	if err := gs.OnStart(); err != nil {
+		t.Fatal(err)
+	}
+	gs.SetKeepOrders(true)
+	gs.runner.runOnce()
+	gs.OnShutdown()
+	if open, _ := se.GetOpenOrders("eosusdt"); len(open) != 10 {
+		t.Fatalf("grid orders should stay on the book,%d", len(open))
+	}
+
+	//停机期间4.99的买单成交，重启后挂出卖单
+	se.SubmitExternal("eosusdt", client.SELL, dec("4.99"), dec("21"))
+	restarted := newTestGridService(se, path)
+	restarted.SetKeepOrders(true)
+	if err := restarted.OnStart(); err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.orders) != 10 {
+		t.Fatalf("restored %d orders", len(restarted.orders))
+	}
+	restarted.runner.runOnce()
+	if !gridLevels(restarted, client.SELL)[5] {
+		t.Fatalf("sell at 5.00 expected after restart,%v", restarted.orders)
+	}
+
+	//网格参数变了，旧订单撤掉
+	changed := NewGridService("eosusdt", dec("4.9"), dec("5.1"), dec("1"), 10, 4, 3, path)
+	NewRunner(changed.symbol, se, changed, 2)
+	if err := changed.OnStart(); err != nil {
+		t.Fatal(err)
+	}
+	if open, _ := se.GetOpenOrders("eosusdt"); len(changed.orders) != 0 || len(open) != 0 {
+		t.Fatalf("old grid should be cancelled,%d open", len(open))
+	}
+}
+
+func TestGridService_OpenOrdersOncePerCycle(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("10"))
+	ce := &orderCountingExchange{SimExchange: se}
+	gs := newTestGridService(ce, "")
+	gs.runner.runOnce()
+	gs.runner.runOnce()
+	if ce.orderCalls != 0 {
+		t.Fatalf("resting orders should be found in open orders,%d get order calls", ce.orderCalls)
+	}
+
+	//只查询不在挂单中的4.99的买单
+	se.SubmitExternal("eosusdt", client.SELL, dec("4.99"), dec("21"))
+	gs.runner.runOnce()
+	if ce.orderCalls != 1 || !gridLevels(gs, client.SELL)[5] {
+		t.Fatalf("%d get order calls,%v", ce.orderCalls, gs.orders)
+	}
+}
+
+func TestGridService_Shutdown(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("10"))
+	dir, _ := ioutil.TempDir("", "qt")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "grid-eosusdt.json")
+	gs := newTestGridService(se, path)
+	if err := gs.OnStart(); err != nil {
+		t.Fatal(err)
+	}
+	gs.runner.runOnce()
+
+	//默认退出时撤掉网格订单，撤单时不再挂反向订单
+	se.SubmitExternal("eosusdt", client.SELL, dec("4.99"), dec("21"))
+	gs.OnShutdown()
+	if open, _ := se.GetOpenOrders("eosusdt"); len(open) != 0 || len(gs.orders) != 0 {
+		t.Fatalf("grid orders should be cancelled,%d open,%v", len(open), gs.orders)
+	}
+
+	//重启后重新建立网格
+	restarted := newTestGridService(se, path)
+	if err := restarted.OnStart(); err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.orders) != 0 {
+		t.Fatalf("restored %d orders", len(restarted.orders))
+	}
+	restarted.runner.runOnce()
+	if open, _ := se.GetOpenOrders("eosusdt"); len(open) != 10 {
+		t.Fatalf("%d orders on the book", len(open))
+	}
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JOURNAL_PLACED    = "placed"
+	JOURNAL_CANCELLED = "cancelled"
+	JOURNAL_FILLED    = "filled"
+)
+
+//JournalEntry 订单日志中的一条记录
+type JournalEntry struct {
+	Seq           int64           `json:"seq"`
+	Ts            int64           `json:"ts"`
+	Event         string          `json:"event"`
+	OrderID       string          `json:"order_id"`
+	Symbol        string          `json:"symbol"`
+	Side          string          `json:"side"`
+	Price         decimal.Decimal `json:"price"`
+	Amount        decimal.Decimal `json:"amount"`
+	FilledAmount  decimal.Decimal `json:"filled_amount"`
+	ExecutedValue decimal.Decimal `json:"executed_value"`
+	FillFees      decimal.Decimal `json:"fill_fees"`
+}
+
+//Journal 只追加的订单日志，记录每一笔下单、撤单和成交，重启后用来找回还挂着的订单。
+//每行格式为"<crc32> <json>"，写入后立即fsync；打开时从头回放，
+//遇到校验失败的行(进程在写入中途退出)就把它和之后的内容截掉。Compact把日志重写为只剩还没有结束的订单
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	seq  int64
+	open map[string]*JournalEntry //还没有撤单或成交的订单
+	now  func() time.Time
+}
+
+func OpenJournal(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{path: path, f: f, open: make(map[string]*JournalEntry), now: time.Now}
+
+	valid, err := j.replay()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err = f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err = f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+//replay 回放日志，返回最后一条完整记录结束的位置
+func (j *Journal) replay() (int64, error) {
+	var valid int64
+	r := bufio.NewReader(j.f)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return valid, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		e, ok := decodeJournalLine(strings.TrimSuffix(line, "\n"))
+		if !ok {
+			return valid, nil
+		}
+		j.apply(e)
+		valid += int64(len(line))
+	}
+}
+
+func encodeJournalLine(e *JournalEntry) ([]byte, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(b), b)), nil
+}
+
+func decodeJournalLine(line string) (*JournalEntry, bool) {
+	i := strings.IndexByte(line, ' ')
+	if i < 0 {
+		return nil, false
+	}
+	body := line[i+1:]
+	if fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(body))) != line[:i] {
+		return nil, false
+	}
+	e := &JournalEntry{}
+	if err := json.Unmarshal([]byte(body), e); err != nil {
+		return nil, false
+	}
+	return e, true
+}
+
+func (j *Journal) apply(e *JournalEntry) {
+	if e.Seq > j.seq {
+		j.seq = e.Seq
+	}
+	switch e.Event {
+	case JOURNAL_PLACED:
+		j.open[e.OrderID] = e
+	case JOURNAL_CANCELLED, JOURNAL_FILLED:
+		delete(j.open, e.OrderID)
+	}
+}
+
+//Append 写入一条记录并落盘
+func (j *Journal) Append(e *JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.seq++
+	e.Seq = j.seq
+	if e.Ts == 0 {
+		e.Ts = j.now().UnixNano() / 1000000
+	}
+	line, err := encodeJournalLine(e)
+	if err != nil {
+		return err
+	}
+	if _, err = j.f.Write(line); err != nil {
+		return err
+	}
+	if err = j.f.Sync(); err != nil {
+		return err
+	}
+	j.apply(e)
+	return nil
+}
+
+//Compact 把还没有结束的订单按原来的顺序写到临时文件，落盘后替换日志，已经结束的订单不再保留。
+//替换前退出时原来的日志不受影响
+func (j *Journal) Compact() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := make([]*JournalEntry, 0, len(j.open))
+	for _, e := range j.open {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Seq < entries[b].Seq })
+
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		line, err := encodeJournalLine(e)
+		if err == nil {
+			_, err = f.Write(line)
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, j.path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(j.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	j.f.Close()
+	j.f = f
+	return nil
+}
+
+//OpenOrders 日志中某个交易对还没有结束的订单
+func (j *Journal) OpenOrders(symbol string) []*JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	orders := make([]*JournalEntry, 0, len(j.open))
+	for _, e := range j.open {
+		if e.Symbol == symbol {
+			c := *e
+			orders = append(orders, &c)
+		}
+	}
+	return orders
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
+
+//orderEntry 根据订单详情生成一条成交或撤单记录
+func orderEntry(event string, o *Order) *JournalEntry {
+	return &JournalEntry{
+		Event:         event,
+		OrderID:       o.ID,
+		Symbol:        o.Symbol,
+		Side:          o.Side,
+		Price:         o.Price,
+		Amount:        o.Amount,
+		FilledAmount:  o.FilledAmount,
+		ExecutedValue: o.ExecutedValue,
+		FillFees:      o.FillFees,
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJournal_ReplayAndTornTail(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "qt")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.wal")
+
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Append(&JournalEntry{Event: JOURNAL_PLACED, OrderID: "1", Symbol: "eosusdt", Side: client.BUY, Price: dec("4.9"), Amount: dec("2")})
+	j.Append(&JournalEntry{Event: JOURNAL_PLACED, OrderID: "2", Symbol: "eosusdt", Side: client.SELL, Price: dec("5.1"), Amount: dec("2")})
+	j.Append(&JournalEntry{Event: JOURNAL_FILLED, OrderID: "2", Symbol: "eosusdt", Side: client.SELL})
+	j.Close()
+
+	//模拟写到一半退出
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`1234abcd {"seq":4,"event":"placed","order_id":"3"`)
+	f.Close()
+
+	j, err = OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := j.OpenOrders("eosusdt")
+	if len(open) != 1 || open[0].OrderID != "1" || !open[0].Price.Equal(dec("4.9")) {
+		t.Fatalf("open orders %v", open)
+	}
+	if err = j.Append(&JournalEntry{Event: JOURNAL_CANCELLED, OrderID: "1", Symbol: "eosusdt"}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	j, _ = OpenJournal(path)
+	defer j.Close()
+	if open := j.OpenOrders("eosusdt"); len(open) != 0 {
+		t.Fatalf("open orders %v", open)
+	}
+	if j.seq != 4 {
+		t.Fatalf("torn entry should be dropped,seq:%d", j.seq)
+	}
+}
+
+func TestJournal_Compact(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "qt")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.wal")
+
+	j, _ := OpenJournal(path)
+	j.Append(&JournalEntry{Event: JOURNAL_PLACED, OrderID: "1", Symbol: "eosusdt", Side: client.BUY, Price: dec("4.9"), Amount: dec("2")})
+	j.Append(&JournalEntry{Event: JOURNAL_PLACED, OrderID: "2", Symbol: "eosusdt", Side: client.SELL, Price: dec("5.1"), Amount: dec("2")})
+	j.Append(&JournalEntry{Event: JOURNAL_PLACED, OrderID: "3", Symbol: "btcusdt", Side: client.BUY, Price: dec("9000"), Amount: dec("1")})
+	j.Append(&JournalEntry{Event: JOURNAL_FILLED, OrderID: "2", Symbol: "eosusdt", Side: client.SELL})
+	if err := j.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(path); strings.Count(string(b), "\n") != 2 {
+		t.Fatalf("compacted journal:\n%s", b)
+	}
+	//压缩后继续追加
+	j.Append(&JournalEntry{Event: JOURNAL_CANCELLED, OrderID: "1", Symbol: "eosusdt", Side: client.BUY})
+	j.Close()
+
+	j, _ = OpenJournal(path)
+	defer j.Close()
+	if open := j.OpenOrders("eosusdt"); len(open) != 0 {
+		t.Fatalf("open orders %v", open)
+	}
+	if open := j.OpenOrders("btcusdt"); len(open) != 1 || open[0].OrderID != "3" || !open[0].Price.Equal(dec("9000")) {
+		t.Fatalf("open orders %v", open)
+	}
+	if j.seq != 5 {
+		t.Fatalf("seq %d", j.seq)
+	}
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+)
+
+//梯度挂单的下单量分配方式
+const (
+	LADDER_FLAT      = "flat"      //每个订单一样多
+	LADDER_LINEAR    = "linear"    //第i个订单的权重为i，离盘口越远越多
+	LADDER_GEOMETRIC = "geometric" //第i个订单的权重为Ratio的i-1次方
+)
+
+//Ladder 每一侧挂Count个订单，第一个按报价挂，之后每隔LevelStep档挂一个；
+//StepBps大于0时改为每隔StepBps个基点挂一个。价差模式下没有档位，StepBps为0时按最小价格单位递增
+type Ladder struct {
+	Count     int
+	LevelStep int
+	StepBps   decimal.Decimal
+	Weighting string
+	Ratio     decimal.Decimal
+}
+
+//SetLadder 开启梯度挂单，nil为每侧只挂一个订单
+func (ds *DigService) SetLadder(l *Ladder) {
+	ds.ladder = l
+}
+
+//rung 梯度中的一个订单
+type rung struct {
+	price  decimal.Decimal
+	amount decimal.Decimal
+}
+
+func (l *Ladder) count() int {
+	if l == nil || l.Count < 1 {
+		return 1
+	}
+	return l.Count
+}
+
+//weights n个订单的权重，总和为1
+func (l *Ladder) weights(n int) []decimal.Decimal {
+	weighting := LADDER_FLAT
+	if l != nil && (l.Weighting != LADDER_GEOMETRIC || l.Ratio.IsPositive()) {
+		weighting = l.Weighting
+	}
+	ws := make([]decimal.Decimal, n)
+	total := decimal.Zero
+	w := decimal.New(1, 0)
+	for i := range ws {
+		switch weighting {
+		case LADDER_LINEAR:
+			ws[i] = decimal.New(int64(i+1), 0)
+		case LADDER_GEOMETRIC:
+			ws[i] = w
+			w = w.Mul(l.Ratio)
+		default:
+			ws[i] = decimal.New(1, 0)
+		}
+		total = total.Add(ws[i])
+	}
+	for i := range ws {
+		ws[i] = ws[i].Div(total)
+	}
+	return ws
+}
+
+//ladderPrices 从报价开始向远离盘口的方向排列的挂单价格，取整后重复的价格只保留一个
+func (ds *DigService) ladderPrices(side string, depth *Depth, q sideQuote) []decimal.Decimal {
+	n := ds.ladder.count()
+	prices := make([]decimal.Decimal, 0, n)
+	for i := 0; i < n; i++ {
+		price := ds.rungPrice(side, depth, q, i)
+		if len(prices) > 0 && price.Equal(prices[len(prices)-1]) {
+			break
+		}
+		prices = append(prices, price)
+	}
+	return prices
+}
+
+func (ds *DigService) rungPrice(side string, depth *Depth, q sideQuote, i int) decimal.Decimal {
+	if i == 0 {
+		return q.price
+	}
+	if q.level > 0 && !ds.ladder.StepBps.IsPositive() {
+		step := ds.ladder.LevelStep
+		if step < 1 {
+			step = 1
+		}
+		levels := depth.Asks
+		if side == client.BUY {
+			levels = depth.Bids
+		}
+		return ds.roundPrice(side, levels[clampLevel(q.level+i*step, len(levels))-1].Price)
+	}
+	offset := decimal.New(int64(i), -ds.pricepPrecision)
+	if ds.ladder.StepBps.IsPositive() {
+		offset = q.price.Mul(ds.ladder.StepBps).Mul(decimal.New(int64(i), -4))
+	}
+	if side == client.BUY {
+		return ds.roundPrice(side, q.price.Sub(offset))
+	}
+	return ds.roundPrice(side, q.price.Add(offset))
+}
+
+//rungs 把available(买单不超过balance)按权重分到梯度的每个价格上，数量按assetPrecision向下取整，
+//分不到数量的价格不挂
+func (ds *DigService) rungs(side string, depth *Depth, q sideQuote, available decimal.Decimal) []rung {
+	if side == client.BUY && available.GreaterThan(ds.balance) {
+		available = ds.balance
+	}
+	budget := available.Mul(q.scale)
+	prices := ds.ladderPrices(side, depth, q)
+	weights := ds.ladder.weights(len(prices))
+	p := decimal.New(1, ds.assetPrecision)
+	rs := make([]rung, 0, len(prices))
+	for i, price := range prices {
+		amount := budget.Mul(weights[i])
+		if side == client.BUY {
+			amount = amount.Div(price)
+		}
+		amount = amount.Mul(p).Floor().Div(p)
+		if amount.IsPositive() {
+			rs = append(rs, rung{price: price, amount: amount})
+		}
+	}
+	return rs
+}
+
+//ladderReason 挂着的梯度需要整体重挂的原因，不需要时返回空
+func (ds *DigService) ladderReason(resting []*Order, rs []rung) string {
+	if len(rs) == 0 {
+		return "no amount to quote"
+	}
+	if len(resting) != len(rs) {
+		return fmt.Sprintf("orders %d->%d", len(resting), len(rs))
+	}
+	for i, o := range resting {
+		if reason := ds.replaceReason(o.Price, o.Amount.Sub(o.FilledAmount), rs[i].price, rs[i].amount); reason != "" {
+			if len(rs) == 1 {
+				return reason
+			}
+			return fmt.Sprintf("rung %d,%s", i+1, reason)
+		}
+	}
+	return ""
+}
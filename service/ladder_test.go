@@ -0,0 +1,92 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"testing"
+)
+
+func TestLadder_Weights(t *testing.T) {
+	cases := []struct {
+		l    *Ladder
+		want []string
+	}{
+		{nil, []string{"1"}},
+		{&Ladder{Weighting: LADDER_FLAT}, []string{"0.25", "0.25", "0.25", "0.25"}},
+		{&Ladder{Weighting: LADDER_LINEAR}, []string{"0.1", "0.2", "0.3", "0.4"}},
+		{&Ladder{Weighting: LADDER_GEOMETRIC, Ratio: dec("2")}, []string{"0.0666666666666667", "0.1333333333333333", "0.2666666666666667", "0.5333333333333333"}},
+	}
+	for _, c := range cases {
+		ws := c.l.weights(len(c.want))
+		for i, w := range ws {
+			if w.StringFixed(16) != dec(c.want[i]).StringFixed(16) {
+				t.Fatalf("%v,weight %d is %s", c.l, i, w)
+			}
+		}
+	}
+}
+
+func TestDigService_LadderByLevels(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	ds := newTestDigService(se)
+	ds.SetLadder(&Ladder{Count: 3, LevelStep: 2, Weighting: LADDER_LINEAR})
+	ds.runner.runOnce()
+	if len(ds.buyOrders) != 3 {
+		t.Fatalf("%d buy orders", len(ds.buyOrders))
+	}
+	//第5、7、9档，50按1:2:3分配
+	want := []struct{ price, amount string }{{"4.96", "1.6801"}, {"4.94", "3.3738"}, {"4.92", "5.0813"}}
+	var ids []string
+	for i, res := range ds.buyOrders {
+		o, _ := se.GetOrder(res.ID)
+		if !o.Price.Equal(dec(want[i].price)) || !o.Amount.Equal(dec(want[i].amount)) {
+			t.Fatalf("rung %d,%v", i+1, o)
+		}
+		ids = append(ids, res.ID)
+	}
+
+	ds.runner.runOnce()
+	for i, res := range ds.buyOrders {
+		if res.ID != ids[i] {
+			t.Fatal("ladder should be kept when the quote does not move")
+		}
+	}
+
+	//盘口多了一档，整个梯度撤掉重挂
+	se.SubmitExternal("eosusdt", client.BUY, dec("5.005"), dec("1"))
+	ds.runner.runOnce()
+	for _, id := range ids {
+		if o, _ := se.GetOrder(id); o.State != client.ORDER_STATE_CANCEL {
+			t.Fatalf("old order %s state %s", id, o.State)
+		}
+	}
+	if len(ds.buyOrders) != 3 {
+		t.Fatalf("%d buy orders after replace", len(ds.buyOrders))
+	}
+	if o, _ := se.GetOrder(ds.buyOrders[0].ID); !o.Price.Equal(dec("4.97")) {
+		t.Fatalf("new price %s", o.Price)
+	}
+
+	ds.OnShutdown()
+	if len(ds.buyOrders) > 0 || len(openOrders(se.orders, "eosusdt")) != 0 {
+		t.Fatal("all rungs should be cancelled")
+	}
+}
+
+func TestDigService_LadderByBps(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("eos", dec("4"))
+	ds := newTestDigService(se)
+	ds.SetLadder(&Ladder{Count: 2, StepBps: dec("10"), Weighting: LADDER_FLAT})
+	ds.runner.runOnce()
+	if len(ds.sellOrders) != 2 {
+		t.Fatalf("%d sell orders", len(ds.sellOrders))
+	}
+	//5.05上浮10bps为5.05505，向上取整到5.056
+	for i, price := range []string{"5.05", "5.056"} {
+		o, _ := se.GetOrder(ds.sellOrders[i].ID)
+		if !o.Price.Equal(dec(price)) || !o.Amount.Equal(dec("2")) {
+			t.Fatalf("rung %d,%v", i+1, o)
+		}
+	}
+}
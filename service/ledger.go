@@ -0,0 +1,81 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+	"sort"
+)
+
+//ledger 模拟账户的余额，负责下单冻结、撤单解冻和成交结算
+type ledger struct {
+	balances map[string]*Balance
+	feeRate  decimal.Decimal
+}
+
+func newLedger(feeRate decimal.Decimal) *ledger {
+	return &ledger{balances: make(map[string]*Balance), feeRate: feeRate}
+}
+
+func (l *ledger) balance(currency string) *Balance {
+	b, ok := l.balances[currency]
+	if !ok {
+		b = &Balance{Currency: currency}
+		l.balances[currency] = b
+	}
+	return b
+}
+
+func (l *ledger) snapshot() []*Balance {
+	bals := make([]*Balance, 0, len(l.balances))
+	for _, b := range l.balances {
+		c := *b
+		bals = append(bals, &c)
+	}
+	sort.Slice(bals, func(i, j int) bool { return bals[i].Currency < bals[j].Currency })
+	return bals
+}
+
+//freeze 冻结下单所需的资金，买单冻结计价币，卖单冻结基础币，余额不足返回false
+func (l *ledger) freeze(base, quote, side string, price, amount decimal.Decimal) bool {
+	bal, need := l.balance(base), amount
+	if side == client.BUY {
+		bal, need = l.balance(quote), price.Mul(amount)
+	}
+	if bal.Available.LessThan(need) {
+		return false
+	}
+	bal.Available = bal.Available.Sub(need)
+	bal.Frozen = bal.Frozen.Add(need)
+	return true
+}
+
+//unfreeze 撤单时解冻剩余未成交部分
+func (l *ledger) unfreeze(base, quote, side string, price, rest decimal.Decimal) {
+	bal, amt := l.balance(base), rest
+	if side == client.BUY {
+		bal, amt = l.balance(quote), price.Mul(rest)
+	}
+	bal.Frozen = bal.Frozen.Sub(amt)
+	bal.Available = bal.Available.Add(amt)
+}
+
+//settle 结算一笔成交，手续费从收到的币种中扣除，返回手续费
+func (l *ledger) settle(base, quote, side string, limitPrice, qty, fillPrice decimal.Decimal) decimal.Decimal {
+	value := qty.Mul(fillPrice)
+	if side == client.BUY {
+		fee := qty.Mul(l.feeRate)
+		q := l.balance(quote)
+		//冻结的是挂单价，成交价更优时把差价退回
+		q.Frozen = q.Frozen.Sub(qty.Mul(limitPrice))
+		q.Available = q.Available.Add(qty.Mul(limitPrice).Sub(value))
+		b := l.balance(base)
+		b.Available = b.Available.Add(qty.Sub(fee))
+		return fee
+	}
+	fee := value.Mul(l.feeRate)
+	b := l.balance(base)
+	b.Frozen = b.Frozen.Sub(qty)
+	q := l.balance(quote)
+	q.Available = q.Available.Add(value.Sub(fee))
+	return fee
+}
@@ -0,0 +1,396 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/MrChang666/qt/config"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Manager 管理所有交易对的Runner，qt.yml修改后用Apply增加、停止或修改交易对
+type Manager struct {
+	ctx     context.Context
+	ex      Exchange
+	journal *Journal
+	feed    *MarketFeed
+	feedGap time.Duration
+	mu      sync.Mutex
+	runners map[string]*managed
+	adjusts map[string]*Overrides //通过管理接口修改的配置，交易对从qt.yml中删掉前一直有效
+	applyMu sync.Mutex            //Apply依次执行
+	wg      sync.WaitGroup
+}
+
+type managed struct {
+	mu     sync.Mutex
+	file   *config.SymbolConfig //qt.yml中的配置
+	conf   *config.SymbolConfig //加上Overrides后正在使用的配置
+	runner *Runner
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (mr *managed) config() *config.SymbolConfig {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	return mr.conf
+}
+
+func (mr *managed) fileConfig() *config.SymbolConfig {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	return mr.file
+}
+
+func (mr *managed) setConfig(file, sc *config.SymbolConfig) {
+	mr.mu.Lock()
+	mr.file = file
+	mr.conf = sc
+	mr.mu.Unlock()
+}
+
+//Overrides 通过管理接口修改的配置，qt.yml重新加载后仍然覆盖在上面，为nil的字段不修改
+type Overrides struct {
+	BuyLevel  *int             `json:"buyLevel,omitempty"`
+	SellLevel *int             `json:"sellLevel,omitempty"`
+	Balance   *decimal.Decimal `json:"balance,omitempty"`
+}
+
+//merge 用o中设置了的字段替换ov中的，返回新的Overrides
+func (ov *Overrides) merge(o *Overrides) *Overrides {
+	c := &Overrides{}
+	if ov != nil {
+		*c = *ov
+	}
+	if o.BuyLevel != nil {
+		c.BuyLevel = o.BuyLevel
+	}
+	if o.SellLevel != nil {
+		c.SellLevel = o.SellLevel
+	}
+	if o.Balance != nil {
+		c.Balance = o.Balance
+	}
+	return c
+}
+
+//apply 在复制的配置上修改，ov为nil时只复制
+func (ov *Overrides) apply(sc *config.SymbolConfig) *config.SymbolConfig {
+	c := sc.Clone()
+	if ov == nil {
+		return c
+	}
+	if ov.BuyLevel != nil {
+		c.BuyLevel = *ov.BuyLevel
+	}
+	if ov.SellLevel != nil {
+		c.SellLevel = *ov.SellLevel
+	}
+	if ov.Balance != nil {
+		c.Balance = *ov.Balance
+	}
+	return c
+}
+
+//finished Runner是否已经停止
+func (mr *managed) finished() bool {
+	select {
+	case <-mr.done:
+		return true
+	default:
+		return false
+	}
+}
+
+//NewManager ctx结束时所有Runner停止，journal为nil时不记录订单日志
+func NewManager(ctx context.Context, ex Exchange, journal *Journal) *Manager {
+	return &Manager{
+		ctx:     ctx,
+		ex:      ex,
+		journal: journal,
+		runners: make(map[string]*managed),
+		adjusts: make(map[string]*Overrides),
+	}
+}
+
+//SetFeed 之后开始运行的交易对在深度有推送时提前执行，见Runner.SetFeed
+func (m *Manager) SetFeed(f *MarketFeed, minInterval time.Duration) {
+	m.feed = f
+	m.feedGap = minInterval
+}
+
+//Symbols 正在运行的交易对，不包括策略自己停止的
+func (m *Manager) Symbols() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ss := make([]string, 0, len(m.runners))
+	for s, mr := range m.runners {
+		if !mr.finished() {
+			ss = append(ss, s)
+		}
+	}
+	sort.Strings(ss)
+	return ss
+}
+
+//Runner 交易对的Runner，没有运行时返回nil
+func (m *Manager) Runner(symbol string) *Runner {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mr, ok := m.runners[symbol]; ok {
+		return mr.runner
+	}
+	return nil
+}
+
+//overrides 交易对通过管理接口修改的配置，没有修改过时返回nil
+func (m *Manager) overrides(symbol string) *Overrides {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.adjusts[symbol]
+}
+
+//Start 创建策略并开始运行一个交易对，通过管理接口修改过的配置覆盖在file上
+func (m *Manager) Start(file *config.SymbolConfig) error {
+	m.mu.Lock()
+	mr, ok := m.runners[file.Symbol]
+	m.mu.Unlock()
+	if ok && !mr.finished() {
+		return fmt.Errorf("%s is already running", file.Symbol)
+	}
+	sc := m.overrides(file.Symbol).apply(file)
+
+	st, err := NewStrategy(sc, m.ex)
+	if err != nil {
+		return err
+	}
+	r := NewRunner(sc.Symbol, m.ex, st, sc.Period)
+	if m.journal != nil {
+		r.SetJournal(m.journal)
+	}
+	if m.feed != nil {
+		r.SetFeed(m.feed, m.feedGap)
+	}
+	if err = r.Start(); err != nil {
+		return fmt.Errorf("start %s failed,%v", sc.Strategy, err)
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	mr = &managed{file: file, conf: sc, runner: r, cancel: cancel, done: make(chan struct{})}
+	m.mu.Lock()
+	m.runners[sc.Symbol] = mr
+	m.mu.Unlock()
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(mr.done)
+		//策略自己停止时留在列表中，qt.yml中这个交易对的配置修改后才重新开始，
+		//避免已经执行完的twap因为其它交易对的修改再执行一次
+		r.Run(ctx)
+	}()
+	log.Infof("%s,started %s", sc.Symbol, sc.Strategy)
+	return nil
+}
+
+//Stop 停止一个交易对，等待策略撤单后返回
+func (m *Manager) Stop(symbol string) {
+	m.mu.Lock()
+	mr, ok := m.runners[symbol]
+	delete(m.runners, symbol)
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	mr.cancel()
+	<-mr.done
+	log.Infof("%s,stopped", symbol)
+}
+
+//Apply 按新的交易对配置调整：删掉的交易对停止，新增的开始运行，策略没变且支持Reconfigure的
+//在下一个周期修改参数，其它有变化的停止后重新开始。策略自己停止的交易对配置没变时不再开始。
+//通过管理接口修改过的配置仍然覆盖在新的配置上，删掉的交易对不再保留。返回所有开始运行失败的交易对
+func (m *Manager) Apply(symbols []*config.SymbolConfig) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+	next := make(map[string]*config.SymbolConfig)
+	for _, sc := range symbols {
+		next[sc.Symbol] = sc
+	}
+
+	m.mu.Lock()
+	running := make(map[string]*managed)
+	for s, mr := range m.runners {
+		running[s] = mr
+	}
+	m.mu.Unlock()
+
+	for s := range running {
+		if _, ok := next[s]; !ok {
+			log.Infof("%s,removed from config", s)
+			m.Stop(s)
+			m.mu.Lock()
+			delete(m.adjusts, s)
+			m.mu.Unlock()
+		}
+	}
+
+	msgs := make([]string, 0)
+	for _, sc := range symbols {
+		mr, ok := running[sc.Symbol]
+		if ok {
+			if reflect.DeepEqual(mr.fileConfig(), sc) {
+				continue
+			}
+			if _, rc := mr.runner.Strategy().(Reconfigurable); rc && !mr.finished() && mr.config().Strategy == sc.Strategy {
+				conf := m.overrides(sc.Symbol).apply(sc)
+				mr.setConfig(sc, conf)
+				mr.runner.Reconfigure(conf)
+				log.Infof("%s,config changed,applies on the next cycle", sc.Symbol)
+				continue
+			}
+			log.Infof("%s,config changed,restarting %s", sc.Symbol, sc.Strategy)
+			m.Stop(sc.Symbol)
+		}
+		if err := m.Start(sc); err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s,%v", sc.Symbol, err))
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("start symbols failed:\n%s", strings.Join(msgs, "\n"))
+	}
+	return nil
+}
+
+//RunnerStatus 一个交易对的运行情况
+type RunnerStatus struct {
+	Symbol    string            `json:"symbol"`
+	Strategy  string            `json:"strategy"`
+	Paused    bool              `json:"paused"`
+	LastDepth time.Time         `json:"lastDepth"`
+	Orders    map[string]string `json:"orders"` //订单id->方向
+	Balances  []*Balance        `json:"balances"`
+	PnL       *PnLSnapshot      `json:"pnl,omitempty"`
+	BuyLevel  int               `json:"buyLevel"`
+	SellLevel int               `json:"sellLevel"`
+	Balance   decimal.Decimal   `json:"balance"`
+	Overrides *Overrides        `json:"overrides,omitempty"` //通过管理接口修改过的配置
+}
+
+//pnlStrategy 记录盈亏的策略
+type pnlStrategy interface {
+	PnL() PnLSnapshot
+}
+
+//Status 所有交易对的运行情况，余额只包含交易对的两个币种
+func (m *Manager) Status() ([]*RunnerStatus, error) {
+	m.mu.Lock()
+	list := make([]*managed, 0, len(m.runners))
+	for _, mr := range m.runners {
+		list = append(list, mr)
+	}
+	m.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].config().Symbol < list[j].config().Symbol })
+
+	bs, err := m.ex.GetBalances()
+	if err != nil {
+		return nil, fmt.Errorf("get balances failed,%v", err)
+	}
+	ss := make([]*RunnerStatus, 0, len(list))
+	for _, mr := range list {
+		conf := mr.config()
+		st := &RunnerStatus{
+			Symbol:    conf.Symbol,
+			Strategy:  conf.Strategy,
+			Paused:    mr.runner.Paused(),
+			LastDepth: mr.runner.LastDepth(),
+			Orders:    mr.runner.Orders(),
+			Balances:  make([]*Balance, 0, 2),
+			BuyLevel:  conf.BuyLevel,
+			SellLevel: conf.SellLevel,
+			Balance:   conf.Balance,
+			Overrides: m.overrides(conf.Symbol),
+		}
+		base, quote := SplitSymbol(conf.Symbol)
+		for _, b := range bs {
+			if b.Currency == base || b.Currency == quote {
+				st.Balances = append(st.Balances, b)
+			}
+		}
+		if ps, ok := mr.runner.Strategy().(pnlStrategy); ok {
+			pnl := ps.PnL()
+			st.PnL = &pnl
+		}
+		ss = append(ss, st)
+	}
+	return ss, nil
+}
+
+//Adjust 修改一个交易对的配置，在下一个周期生效，策略需要支持Reconfigure。
+//修改的字段在qt.yml重新加载后仍然有效，直到ClearOverrides或者交易对从qt.yml中删掉
+func (m *Manager) Adjust(symbol string, o *Overrides) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+	m.mu.Lock()
+	mr, ok := m.runners[symbol]
+	m.mu.Unlock()
+	if !ok || mr.finished() {
+		return fmt.Errorf("%s is not running", symbol)
+	}
+	if _, rc := mr.runner.Strategy().(Reconfigurable); !rc {
+		return fmt.Errorf("%s strategy %s can not be adjusted at runtime", symbol, mr.config().Strategy)
+	}
+	m.mu.Lock()
+	ov := m.adjusts[symbol].merge(o)
+	m.adjusts[symbol] = ov
+	m.mu.Unlock()
+	file := mr.fileConfig()
+	sc := ov.apply(file)
+	mr.setConfig(file, sc)
+	mr.runner.Reconfigure(sc)
+	log.Infof("%s,config adjusted,applies on the next cycle", symbol)
+	return nil
+}
+
+//ClearOverrides 撤销通过Adjust修改的配置，恢复为qt.yml中的配置
+func (m *Manager) ClearOverrides(symbol string) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+	m.mu.Lock()
+	mr, ok := m.runners[symbol]
+	_, adjusted := m.adjusts[symbol]
+	delete(m.adjusts, symbol)
+	m.mu.Unlock()
+	if !ok || mr.finished() {
+		return fmt.Errorf("%s is not running", symbol)
+	}
+	if !adjusted {
+		return nil
+	}
+	file := mr.fileConfig()
+	sc := file.Clone()
+	mr.setConfig(file, sc)
+	mr.runner.Reconfigure(sc)
+	log.Infof("%s,overrides cleared,applies on the next cycle", symbol)
+	return nil
+}
+
+//Wait 等待所有Runner停止，超时返回false
+func (m *Manager) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"github.com/MrChang666/qt/config"
+	"github.com/shopspring/decimal"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//reconfStrategy 支持Reconfigure的recordingStrategy
+type reconfStrategy struct {
+	recordingStrategy
+	conf *config.SymbolConfig
+}
+
+func (rs *reconfStrategy) Reconfigure(conf *config.SymbolConfig) { rs.conf = conf }
+
+//onceStrategy 执行一个周期后自己停止，像执行完的twap
+type onceStrategy struct {
+	recordingStrategy
+}
+
+func (st *onceStrategy) OnDepth(depth *Depth) bool { return false }
+
+//onceStarts 创建过的onceStrategy数量
+var onceStarts int32
+
+func init() {
+	RegisterStrategy("reconf", func(conf *config.SymbolConfig, ex Exchange) (Strategy, error) {
+		return &reconfStrategy{}, nil
+	})
+	RegisterStrategy("once", func(conf *config.SymbolConfig, ex Exchange) (Strategy, error) {
+		atomic.AddInt32(&onceStarts, 1)
+		return &onceStrategy{}, nil
+	})
+	RegisterStrategy("recording", func(conf *config.SymbolConfig, ex Exchange) (Strategy, error) {
+		return &recordingStrategy{}, nil
+	})
+}
+
+func TestManager_Apply(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewManager(ctx, newSimBook(), nil)
+	eos := &config.SymbolConfig{Symbol: "eosusdt", Strategy: "reconf", Balance: dec("100"), Period: 60}
+	if err := m.Apply([]*config.SymbolConfig{eos}); err != nil {
+		t.Fatal(err)
+	}
+	r := m.Runner("eosusdt")
+	if ss := m.Symbols(); len(ss) != 1 || r == nil {
+		t.Fatalf("symbols %v", ss)
+	}
+
+	//没有变化
+	same := *eos
+	m.Apply([]*config.SymbolConfig{&same})
+	if m.Runner("eosusdt") != r || r.pending != nil {
+		t.Fatal("unchanged config should keep the runner")
+	}
+
+	//修改余额，下一个周期生效
+	changed := same
+	changed.Balance = dec("50")
+	changed.Period = 5
+	m.Apply([]*config.SymbolConfig{&changed})
+	if m.Runner("eosusdt") != r {
+		t.Fatal("reconfigurable strategy should not be restarted")
+	}
+	r.Do(func() { r.runOnce() })
+	if st := r.Strategy().(*reconfStrategy); !reflect.DeepEqual(st.conf, &changed) || r.period != 5 {
+		t.Fatalf("config should apply on the next cycle,period %d", r.period)
+	}
+
+	//策略不支持Reconfigure时重启
+	restart := changed
+	restart.Strategy = "recording"
+	m.Apply([]*config.SymbolConfig{&restart})
+	if next := m.Runner("eosusdt"); next == nil || next == r || !r.Strategy().(*reconfStrategy).shutdown {
+		t.Fatal("changed strategy should restart the runner")
+	}
+
+	//删除的交易对停止，错误的配置返回错误
+	old := m.Runner("eosusdt")
+	bad := &config.SymbolConfig{Symbol: "paxusdt", Strategy: "nope", Balance: decimal.Zero, Period: 60}
+	if err := m.Apply([]*config.SymbolConfig{bad}); err == nil {
+		t.Fatal("unknown strategy should be an error")
+	}
+	if ss := m.Symbols(); len(ss) != 0 || !old.Strategy().(*recordingStrategy).shutdown {
+		t.Fatalf("removed symbol should be stopped,%v", ss)
+	}
+	if !m.Wait(time.Second) {
+		t.Fatal("all runners should be stopped")
+	}
+}
+
+func TestManager_ApplyFinished(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewManager(ctx, newSimBook(), nil)
+	twap := &config.SymbolConfig{Symbol: "btcusdt", Strategy: "once", Period: 60}
+	eos := &config.SymbolConfig{Symbol: "eosusdt", Strategy: "reconf", Balance: dec("100"), Period: 60}
+	starts := atomic.LoadInt32(&onceStarts)
+	if err := m.Apply([]*config.SymbolConfig{twap, eos}); err != nil {
+		t.Fatal(err)
+	}
+	r := m.Runner("btcusdt")
+	waitFor(t, "strategy to finish", func() bool { return len(m.Symbols()) == 1 })
+
+	//修改其它交易对或者重复的修改事件都不会重新开始已经停止的策略
+	same := *twap
+	changed := *eos
+	changed.Balance = dec("50")
+	m.Apply([]*config.SymbolConfig{&same, &changed})
+	m.Apply([]*config.SymbolConfig{&same, &changed})
+	if m.Runner("btcusdt") != r || atomic.LoadInt32(&onceStarts)-starts != 1 {
+		t.Fatalf("finished strategy should not be restarted,starts %d", atomic.LoadInt32(&onceStarts)-starts)
+	}
+	if err := m.Adjust("btcusdt", &Overrides{}); err == nil {
+		t.Fatal("finished symbol can not be adjusted")
+	}
+
+	//自己的配置修改后重新开始
+	again := same
+	again.Period = 30
+	if err := m.Apply([]*config.SymbolConfig{&again, &changed}); err != nil {
+		t.Fatal(err)
+	}
+	if m.Runner("btcusdt") == r || atomic.LoadInt32(&onceStarts)-starts != 2 {
+		t.Fatal("changed config should start the strategy again")
+	}
+	cancel()
+	if !m.Wait(time.Second) {
+		t.Fatal("all runners should be stopped")
+	}
+}
+
+func TestManager_Adjust(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	se := newSimBook()
+	m := NewManager(ctx, se, nil)
+	eos := &config.SymbolConfig{Symbol: "eosusdt", Strategy: "reconf", Balance: dec("100"), BuyLevel: 5, SellLevel: 5, Period: 60,
+		Ladder: &config.LadderConfig{Count: 1, Step: 1, Weighting: "flat"}}
+	pax := &config.SymbolConfig{Symbol: "paxusdt", Strategy: "reconf", Balance: dec("100"), Period: 60}
+	if err := m.Apply([]*config.SymbolConfig{eos, pax}); err != nil {
+		t.Fatal(err)
+	}
+	r := m.Runner("eosusdt")
+	level := 8
+	if err := m.Adjust("eosusdt", &Overrides{BuyLevel: &level}); err != nil {
+		t.Fatal(err)
+	}
+	sc := m.runners["eosusdt"].config()
+	if sc.BuyLevel != 8 || eos.BuyLevel != 5 || sc.Ladder == eos.Ladder {
+		t.Fatalf("adjust should change a copy,%+v", sc)
+	}
+
+	//qt.yml重新加载后修改仍然有效，包括这个交易对自己的配置变了
+	same, changedPax := *eos, *pax
+	changedPax.Balance = dec("50")
+	m.Apply([]*config.SymbolConfig{&same, &changedPax})
+	if sc := m.runners["eosusdt"].config(); sc.BuyLevel != 8 {
+		t.Fatalf("override lost after reload,%+v", sc)
+	}
+	changed := *eos
+	changed.SellLevel = 3
+	m.Apply([]*config.SymbolConfig{&changed, &changedPax})
+	r.Do(func() { r.runOnce() })
+	if st := r.Strategy().(*reconfStrategy); st.conf.BuyLevel != 8 || st.conf.SellLevel != 3 {
+		t.Fatalf("override should apply on top of the new config,%+v", st.conf)
+	}
+	ss, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss[0].Symbol != "eosusdt" || ss[0].Overrides == nil || *ss[0].Overrides.BuyLevel != 8 || ss[0].BuyLevel != 8 || ss[1].Overrides != nil {
+		t.Fatalf("status should show overrides,%+v", ss[0])
+	}
+
+	//撤销后恢复为qt.yml中的配置
+	if err = m.ClearOverrides("eosusdt"); err != nil {
+		t.Fatal(err)
+	}
+	if sc := m.runners["eosusdt"].config(); sc.BuyLevel != 5 || sc.SellLevel != 3 || m.overrides("eosusdt") != nil {
+		t.Fatalf("cleared config %+v", sc)
+	}
+
+	//删掉的交易对不再保留修改
+	m.Adjust("eosusdt", &Overrides{BuyLevel: &level})
+	m.Apply([]*config.SymbolConfig{&changedPax})
+	m.Apply([]*config.SymbolConfig{&changed, &changedPax})
+	if sc := m.runners["eosusdt"].config(); sc.BuyLevel != 5 {
+		t.Fatalf("override should be dropped with the symbol,%+v", sc)
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"errors"
+	"github.com/MrChang666/qt/metrics"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+//导出到/metrics的指标
+var (
+	ordersCreated   = metrics.Default.Counter("qt_orders_created_total", "Orders accepted by the exchange.", "symbol", "side")
+	ordersCancelled = metrics.Default.Counter("qt_orders_cancelled_total", "Orders cancelled, including partially filled ones.", "symbol", "side")
+	ordersFilled    = metrics.Default.Counter("qt_orders_filled_total", "Orders completely filled.", "symbol", "side")
+	apiErrors       = metrics.Default.Counter("qt_api_errors_total", "FCoin API calls that failed, by FCoin status code, http_<code> or error for network errors.", "endpoint", "status")
+	apiLatency      = metrics.Default.Histogram("qt_api_request_duration_seconds", "FCoin API request latency.", metrics.DEFAULT_BUCKETS, "endpoint")
+	balanceAvail    = metrics.Default.Gauge("qt_balance_available", "Available balance of the symbol's currencies.", "symbol", "currency")
+	balanceFrozen   = metrics.Default.Gauge("qt_balance_frozen", "Balance frozen in open orders.", "symbol", "currency")
+	inventory       = metrics.Default.Gauge("qt_inventory", "Base currency position accumulated from fills.", "symbol")
+	pnlRealized     = metrics.Default.Gauge("qt_pnl_realized", "Realized PnL net of fees, in quote currency.", "symbol")
+	pnlUnrealized   = metrics.Default.Gauge("qt_pnl_unrealized", "Unrealized PnL of the position at the mid price.", "symbol")
+	openOrdersGauge = metrics.Default.Gauge("qt_open_orders", "Orders tracked as resting on the book.", "symbol")
+	feedGaps        = metrics.Default.Counter("qt_feed_seq_gaps_total", "Depth pushes whose seq skipped ahead of the previous one.", "symbol")
+	feedReconnects  = metrics.Default.Counter("qt_feed_reconnects_total", "Market feed websocket reconnects.")
+)
+
+//ObserveRequest 统计RestClient每个请求的耗时和错误，用RestClient.SetObserver设置
+func ObserveRequest(endpoint string, status int, elapsed time.Duration, err error) {
+	apiLatency.With(endpoint).Observe(elapsed.Seconds())
+	var he *HTTPError
+	if errors.As(err, &he) {
+		apiErrors.With(endpoint, "http_"+strconv.Itoa(he.StatusCode)).Inc()
+	} else if err != nil {
+		apiErrors.With(endpoint, "error").Inc()
+	} else if status != 0 {
+		apiErrors.With(endpoint, strconv.Itoa(status)).Inc()
+	}
+}
+
+//CollectMetrics 每次导出前用Status更新余额、持仓和盈亏，查询余额失败时保留上一次的值
+func (m *Manager) CollectMetrics() {
+	metrics.Default.OnCollect(func() {
+		ss, err := m.Status()
+		if err != nil {
+			log.Errorf("collect metrics failed,%v", err)
+			return
+		}
+		for _, st := range ss {
+			for _, b := range st.Balances {
+				f, _ := b.Available.Float64()
+				balanceAvail.With(st.Symbol, b.Currency).Set(f)
+				f, _ = b.Frozen.Float64()
+				balanceFrozen.With(st.Symbol, b.Currency).Set(f)
+			}
+			openOrdersGauge.With(st.Symbol).Set(float64(len(st.Orders)))
+			if st.PnL != nil {
+				f, _ := st.PnL.Inventory.Float64()
+				inventory.With(st.Symbol).Set(f)
+				f, _ = st.PnL.Realized.Float64()
+				pnlRealized.With(st.Symbol).Set(f)
+				f, _ = st.PnL.Unrealized.Float64()
+				pnlUnrealized.With(st.Symbol).Set(f)
+			}
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/MrChang666/qt/config"
+	"github.com/MrChang666/qt/metrics"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	ObserveRequest("test_create", client.ORDER_INSUFFICIENT, 20*time.Millisecond, nil)
+	ObserveRequest("test_depth", 0, time.Second, errors.New("timeout"))
+
+	//其它测试也会下单，计数器按差值比较
+	created := ordersCreated.With("eosusdt", client.BUY).Value()
+	filled := ordersFilled.With("eosusdt", client.BUY).Value()
+	cancelled := ordersCancelled.With("eosusdt", client.SELL).Value()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	m := NewManager(ctx, se, nil)
+	m.Start(&config.SymbolConfig{Symbol: "eosusdt", Strategy: "reconf", Period: 60})
+	r := m.Runner("eosusdt")
+	r.Do(func() {
+		r.Place(client.BUY, dec("5.01"), dec("1"))
+		res, _ := r.Place(client.SELL, dec("5.5"), dec("0.5"))
+		r.Refresh(r.OpenOrders()[0])
+		r.Cancel(res.ID)
+	})
+	if ordersCreated.With("eosusdt", client.BUY).Value()-created != 1 ||
+		ordersFilled.With("eosusdt", client.BUY).Value()-filled != 1 ||
+		ordersCancelled.With("eosusdt", client.SELL).Value()-cancelled != 1 {
+		t.Fatal("order counters not updated")
+	}
+
+	m.CollectMetrics()
+	var buf bytes.Buffer
+	metrics.Default.Write(&buf)
+	out := buf.String()
+	for _, line := range []string{
+		`qt_api_errors_total{endpoint="test_create",status="1016"} 1`,
+		`qt_api_errors_total{endpoint="test_depth",status="error"} 1`,
+		`qt_api_request_duration_seconds_count{endpoint="test_create"} 1`,
+		`qt_balance_available{symbol="eosusdt",currency="eos"} 0.999`,
+		`qt_open_orders{symbol="eosusdt"} 0`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+}
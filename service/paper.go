@@ -0,0 +1,84 @@
+package service
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const PAPER_DEPTH_MAX_AGE = time.Second
+
+//PaperExchange 模拟盘：深度来自真实交易所，下单和撤单只记在本地账本上，
+//每次拉到新深度时用SnapshotExchange的规则判断挂单是否成交
+type PaperExchange struct {
+	*SnapshotExchange
+	live      Exchange
+	maxAge    time.Duration
+	mu        sync.Mutex
+	refreshed map[string]time.Time
+	now       func() time.Time
+}
+
+func NewPaperExchange(live Exchange, feeRate decimal.Decimal) *PaperExchange {
+	return &PaperExchange{
+		SnapshotExchange: NewSnapshotExchange(feeRate),
+		live:             live,
+		maxAge:           PAPER_DEPTH_MAX_AGE,
+		refreshed:        make(map[string]time.Time),
+		now:              time.Now,
+	}
+}
+
+//refresh 拉取最新深度并撮合挂单，maxAge内拉过的直接用缓存，
+//这样一个周期里撤单和取深度只会请求一次交易所。请求期间不持有锁，其它交易对不用等待
+func (pe *PaperExchange) refresh(symbol string) (*Depth, error) {
+	pe.mu.Lock()
+	t, ok := pe.refreshed[symbol]
+	start := pe.now()
+	pe.mu.Unlock()
+	if ok && start.Sub(t) < pe.maxAge {
+		return pe.SnapshotExchange.GetDepth(symbol)
+	}
+	depth, err := pe.live.GetDepth(symbol)
+	if err != nil {
+		return nil, err
+	}
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	if t, ok := pe.refreshed[symbol]; ok && t.After(start) {
+		//请求期间已经用更新的深度撮合过
+		return pe.SnapshotExchange.GetDepth(symbol)
+	}
+	for _, f := range pe.Update(depth) {
+		log.Infof("paper fill,side:%s,symbol:%s,price:%s,amount:%s,fee:%s", f.Side, f.Symbol, f.Price, f.Amount, f.Fee)
+	}
+	pe.refreshed[symbol] = pe.now()
+	return depth, nil
+}
+
+func (pe *PaperExchange) GetDepth(symbol string) (*Depth, error) {
+	return pe.refresh(symbol)
+}
+
+//GetCandles k线直接取真实交易所的
+func (pe *PaperExchange) GetCandles(symbol, resolution string, limit int) ([]*Candle, error) {
+	cs, ok := pe.live.(CandleSource)
+	if !ok {
+		return nil, fmt.Errorf("exchange does not provide candles")
+	}
+	return cs.GetCandles(symbol, resolution, limit)
+}
+
+//CancelOrder 撤单前先用最新深度撮合，已经成交的返回client.CANCEL_SUCCESS_ORDER
+func (pe *PaperExchange) CancelOrder(id string) (*CancelResult, error) {
+	o, err := pe.GetOrder(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := pe.refresh(o.Symbol); err != nil {
+		log.Errorf("%s,refresh paper depth failed,%v", o.Symbol, err)
+	}
+	return pe.SnapshotExchange.CancelOrder(id)
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"testing"
+	"time"
+)
+
+func TestPaperExchange_FillsFromLiveDepth(t *testing.T) {
+	//用SimExchange充当真实行情
+	live := newSimBook()
+	pe := NewPaperExchange(live, dec("0.001"))
+	pe.AddMarket("eosusdt", "eos", "usdt")
+	pe.SetBalance("usdt", dec("100"))
+	clock := time.Unix(1561000000, 0)
+	pe.now = func() time.Time { return clock }
+	live.now = func() time.Time { return clock }
+
+	ds := newTestDigService(pe)
+	ds.runner.runOnce()
+	if len(ds.buyOrders) == 0 {
+		t.Fatal("buy order should be placed on the paper ledger")
+	}
+	buyID := ds.buyOrders[0].ID
+
+	//真实盘口被砸到4.95，卖一4.95穿过了我们4.96的买单
+	live.SubmitExternal("eosusdt", client.SELL, dec("4.95"), dec("61"))
+	clock = clock.Add(2 * time.Second)
+
+	ds.runner.runOnce()
+	o, _ := pe.GetOrder(buyID)
+	if o.State != client.FILLED || !o.Price.Equal(dec("4.96")) {
+		t.Fatalf("paper buy order %v", o)
+	}
+	if eos := totalBalance(pe, "eos"); !eos.Equal(o.Amount.Sub(o.FillFees)) {
+		t.Fatalf("eos balance %s", eos)
+	}
+	//真实交易所上没有我们的订单
+	if bal := totalBalance(live, "usdt"); !bal.IsZero() {
+		t.Fatalf("live balance touched %s", bal)
+	}
+}
+
+func TestPaperExchange_ImproveBook(t *testing.T) {
+	live := newSimBook()
+	pe := NewPaperExchange(live, dec("0.001"))
+	pe.AddMarket("eosusdt", "eos", "usdt")
+	pe.SetBalance("usdt", dec("100"))
+	clock := time.Unix(1561000000, 0)
+	pe.now = func() time.Time { return clock }
+	live.now = func() time.Time { return clock }
+	pe.GetDepth("eosusdt")
+
+	//挂在买一和卖一之间的买单只是改善了盘口，没有人卖给我们
+	res, err := pe.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.BUY, Price: dec("5.005"), Amount: dec("1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock = clock.Add(2 * time.Second)
+	pe.GetDepth("eosusdt")
+	if o, _ := pe.GetOrder(res.ID); o.State != ORDER_STATE_SUBMITTED {
+		t.Fatalf("order improving the book should stay open,%v", o)
+	}
+
+	//卖一降到5.005以后成交
+	live.SubmitExternal("eosusdt", client.SELL, dec("5.005"), dec("1"))
+	clock = clock.Add(2 * time.Second)
+	pe.GetDepth("eosusdt")
+	if o, _ := pe.GetOrder(res.ID); o.State != client.FILLED {
+		t.Fatalf("order should be filled by the new ask,%v", o)
+	}
+}
+
+//slowDepthExchange 取btcusdt的深度时通知started，等到release才返回
+type slowDepthExchange struct {
+	*SimExchange
+	started chan struct{}
+	release chan struct{}
+}
+
+func (sd *slowDepthExchange) GetDepth(symbol string) (*Depth, error) {
+	if symbol == "btcusdt" {
+		close(sd.started)
+		<-sd.release
+	}
+	return sd.SimExchange.GetDepth(symbol)
+}
+
+func TestPaperExchange_SlowDepth(t *testing.T) {
+	live := &slowDepthExchange{SimExchange: newSimBook(), started: make(chan struct{}), release: make(chan struct{})}
+	pe := NewPaperExchange(live, dec("0.001"))
+	go pe.GetDepth("btcusdt")
+	defer close(live.release)
+	<-live.started
+
+	//一个交易对取深度慢不影响其它交易对
+	done := make(chan error)
+	go func() {
+		_, err := pe.GetDepth("eosusdt")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("eosusdt depth should not wait for btcusdt")
+	}
+}
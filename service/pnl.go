@@ -0,0 +1,155 @@
+package service
+
+import (
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+	"sync"
+)
+
+//持仓成本的计算方式
+const (
+	PNL_AVERAGE = "average" //加权平均成本
+	PNL_FIFO    = "fifo"    //先进先出
+)
+
+//lot 一笔还没有平掉的持仓，Amount为正是多头，为负是空头
+type lot struct {
+	Amount decimal.Decimal
+	Price  decimal.Decimal
+}
+
+//PnLSnapshot 某一时刻的盈亏，金额都以计价币计
+type PnLSnapshot struct {
+	Symbol     string          `json:"symbol"`
+	Method     string          `json:"method"`
+	Inventory  decimal.Decimal `json:"inventory"`  //通过成交累计的基础币持仓，卖出多于买入时为负
+	AvgCost    decimal.Decimal `json:"avgCost"`    //持仓的平均成本价，含手续费
+	Realized   decimal.Decimal `json:"realized"`   //已实现盈亏，已扣除手续费
+	Unrealized decimal.Decimal `json:"unrealized"` //持仓按中间价估值的浮动盈亏
+	Fees       decimal.Decimal `json:"fees"`
+	Mark       decimal.Decimal `json:"mark"` //最近一次的中间价
+	Fills      int             `json:"fills"`
+}
+
+func (ps PnLSnapshot) Total() decimal.Decimal {
+	return ps.Realized.Add(ps.Unrealized)
+}
+
+func (ps PnLSnapshot) String() string {
+	return fmt.Sprintf("symbol:%s,inventory:%s,avg cost:%s,realized:%s,unrealized:%s,fees:%s,mark:%s",
+		ps.Symbol, ps.Inventory, ps.AvgCost.StringFixed(8), ps.Realized.StringFixed(8),
+		ps.Unrealized.StringFixed(8), ps.Fees.StringFixed(8), ps.Mark)
+}
+
+//PnL 一个交易对的盈亏核算。手续费计入成交价：买入的成本是支付的计价币除以实际到手的基础币，
+//卖出的价格是扣除手续费后收到的计价币除以卖出数量，所以已实现盈亏是扣除手续费之后的
+type PnL struct {
+	mu       sync.Mutex
+	symbol   string
+	method   string
+	lots     []lot //平均成本法时最多只有一笔
+	realized decimal.Decimal
+	fees     decimal.Decimal
+	mark     decimal.Decimal
+	fills    int
+}
+
+func NewPnL(symbol, method string) *PnL {
+	if method != PNL_FIFO {
+		method = PNL_AVERAGE
+	}
+	return &PnL{symbol: symbol, method: method}
+}
+
+//OnFill 记录一个订单的成交，amount、value、fee对应订单的filled_amount、executed_value、fill_fees，
+//fcoin买单的手续费收取基础币，卖单收取计价币
+func (p *PnL) OnFill(side string, amount, value, fee decimal.Decimal) {
+	if !amount.IsPositive() {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fills++
+
+	if side == client.BUY {
+		net := amount.Sub(fee)
+		if !net.IsPositive() {
+			return
+		}
+		p.fees = p.fees.Add(fee.Mul(value).Div(amount))
+		p.trade(net, value.Div(net))
+		return
+	}
+	p.fees = p.fees.Add(fee)
+	p.trade(amount.Neg(), value.Sub(fee).Div(amount))
+}
+
+//trade 买入为正卖出为负，先平掉方向相反的持仓，剩余部分开新仓
+func (p *PnL) trade(qty, price decimal.Decimal) {
+	for !qty.IsZero() && len(p.lots) > 0 && p.lots[0].Amount.Sign() != qty.Sign() {
+		l := &p.lots[0]
+		closed := decimal.Min(qty.Abs(), l.Amount.Abs())
+		if l.Amount.IsPositive() {
+			p.realized = p.realized.Add(closed.Mul(price.Sub(l.Price)))
+			l.Amount = l.Amount.Sub(closed)
+			qty = qty.Add(closed)
+		} else {
+			p.realized = p.realized.Add(closed.Mul(l.Price.Sub(price)))
+			l.Amount = l.Amount.Add(closed)
+			qty = qty.Sub(closed)
+		}
+		if l.Amount.IsZero() {
+			p.lots = p.lots[1:]
+		}
+	}
+	if qty.IsZero() {
+		return
+	}
+	if p.method == PNL_AVERAGE && len(p.lots) == 1 {
+		l := &p.lots[0]
+		total := l.Amount.Add(qty)
+		l.Price = l.Amount.Mul(l.Price).Add(qty.Mul(price)).Div(total)
+		l.Amount = total
+		return
+	}
+	p.lots = append(p.lots, lot{Amount: qty, Price: price})
+}
+
+//Mark 更新估值用的中间价
+func (p *PnL) Mark(mid decimal.Decimal) {
+	if !mid.IsPositive() {
+		return
+	}
+	p.mu.Lock()
+	p.mark = mid
+	p.mu.Unlock()
+}
+
+func (p *PnL) Snapshot() PnLSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ps := PnLSnapshot{
+		Symbol:     p.symbol,
+		Method:     p.method,
+		Inventory:  decimal.Zero,
+		AvgCost:    decimal.Zero,
+		Realized:   p.realized,
+		Unrealized: decimal.Zero,
+		Fees:       p.fees,
+		Mark:       p.mark,
+		Fills:      p.fills,
+	}
+	cost := decimal.Zero
+	for _, l := range p.lots {
+		ps.Inventory = ps.Inventory.Add(l.Amount)
+		cost = cost.Add(l.Amount.Mul(l.Price))
+		if p.mark.IsPositive() {
+			ps.Unrealized = ps.Unrealized.Add(l.Amount.Mul(p.mark.Sub(l.Price)))
+		}
+	}
+	if !ps.Inventory.IsZero() {
+		ps.AvgCost = cost.Div(ps.Inventory)
+	}
+	return ps
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestPnL_Average(t *testing.T) {
+	p := NewPnL("eosusdt", PNL_AVERAGE)
+	p.OnFill(client.BUY, dec("10"), dec("50"), decimal.Zero)
+	p.OnFill(client.BUY, dec("10"), dec("52"), decimal.Zero)
+	p.OnFill(client.SELL, dec("5"), dec("27"), decimal.Zero)
+	p.Mark(dec("5.3"))
+
+	ps := p.Snapshot()
+	//均价5.1，卖出5个@5.4
+	if !ps.AvgCost.Equal(dec("5.1")) || !ps.Inventory.Equal(dec("15")) {
+		t.Fatalf("inventory %s avg %s", ps.Inventory, ps.AvgCost)
+	}
+	if !ps.Realized.Equal(dec("1.5")) || !ps.Unrealized.Equal(dec("3")) {
+		t.Fatalf("realized %s unrealized %s", ps.Realized, ps.Unrealized)
+	}
+}
+
+func TestPnL_FIFO(t *testing.T) {
+	p := NewPnL("eosusdt", PNL_FIFO)
+	p.OnFill(client.BUY, dec("10"), dec("50"), decimal.Zero)
+	p.OnFill(client.BUY, dec("10"), dec("52"), decimal.Zero)
+	p.OnFill(client.SELL, dec("15"), dec("81"), decimal.Zero)
+	p.Mark(dec("5"))
+
+	ps := p.Snapshot()
+	//先平5.0的10个，再平5.2的5个，卖价5.4
+	if !ps.Realized.Equal(dec("5")) || !ps.Inventory.Equal(dec("5")) || !ps.AvgCost.Equal(dec("5.2")) {
+		t.Fatalf("%v", ps)
+	}
+	if !ps.Unrealized.Equal(dec("-1")) {
+		t.Fatalf("unrealized %s", ps.Unrealized)
+	}
+}
+
+func TestPnL_FeesAndShort(t *testing.T) {
+	p := NewPnL("eosusdt", PNL_AVERAGE)
+	//先卖出已有的币：收到50，手续费0.05，开空5.0-0.005
+	p.OnFill(client.SELL, dec("10"), dec("50"), dec("0.05"))
+	//买回10个，手续费0.01个币，实际到手9.99
+	p.OnFill(client.BUY, dec("10"), dec("48"), dec("0.01"))
+
+	ps := p.Snapshot()
+	if !ps.Fees.Equal(dec("0.098")) {
+		t.Fatalf("fees %s", ps.Fees)
+	}
+	if !ps.Inventory.Equal(dec("-0.01")) {
+		t.Fatalf("inventory %s", ps.Inventory)
+	}
+	//9.99个按4.995空、按48/9.99平
+	want := dec("9.99").Mul(dec("4.995").Sub(dec("48").Div(dec("9.99"))))
+	if !ps.Realized.Equal(want) {
+		t.Fatalf("realized %s,want %s", ps.Realized, want)
+	}
+}
+
+func TestDigService_PnLFromFills(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	ds := newTestDigService(se)
+	ds.runner.runOnce()
+
+	//买单4.96成交
+	se.SubmitExternal("eosusdt", client.SELL, dec("4.96"), dec("100"))
+	ds.runner.runOnce()
+
+	ps := ds.PnL()
+	if ps.Fills != 1 || !ps.Inventory.IsPositive() {
+		t.Fatalf("%v", ps)
+	}
+	if !ps.Mark.IsPositive() {
+		t.Fatalf("mark should follow depth,%v", ps)
+	}
+}
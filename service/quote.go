@@ -0,0 +1,76 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+)
+
+//sideQuote 一侧挂单的价格和下单量比例，level是价格所在的档位，价差模式下为0
+type sideQuote struct {
+	price decimal.Decimal
+	scale decimal.Decimal
+	level int
+}
+
+//quotes 计算本周期买卖两侧的报价。默认按buyLevel/sellLevel取盘口价格，
+//配置了spread时按中间价加减价差；开启了持仓调整时再按基础币总额移动档位和减量
+func (ds *DigService) quotes(depth *Depth) (sideQuote, sideQuote) {
+	one := decimal.New(1, 0)
+	buyShift, sellShift, buyScale, sellScale := 0, 0, one, one
+	if ds.skew != nil {
+		inventory, err := ds.inventory()
+		if err != nil {
+			log.Errorf("%s,get balances for inventory skew failed,%v", ds.symbol, err)
+		} else {
+			buyShift, sellShift, buyScale, sellScale = ds.skew.Adjust(inventory)
+			if buyShift != 0 || sellShift != 0 {
+				log.Debugf("%s,inventory %s,skew buy %d levels scale %s,sell %d levels scale %s", ds.symbol, inventory, buyShift, buyScale, sellShift, sellScale)
+			}
+		}
+	}
+
+	if ds.spread != nil {
+		bid, ask := ds.spreadPrices(depth, buyShift, sellShift)
+		return sideQuote{price: bid, scale: buyScale}, sideQuote{price: ask, scale: sellScale}
+	}
+	buyLevel := clampLevel(ds.buyLevel+buyShift, len(depth.Bids))
+	sellLevel := clampLevel(ds.sellLevel+sellShift, len(depth.Asks))
+	buy := sideQuote{price: ds.roundPrice(client.BUY, depth.Bids[buyLevel-1].Price), scale: buyScale, level: buyLevel}
+	sell := sideQuote{price: ds.roundPrice(client.SELL, depth.Asks[sellLevel-1].Price), scale: sellScale, level: sellLevel}
+	return buy, sell
+}
+
+//roundPrice 按pricePrecison取整，买价向下、卖价向上，取整后不会比原价更靠近对手盘
+func (ds *DigService) roundPrice(side string, price decimal.Decimal) decimal.Decimal {
+	p := decimal.New(1, ds.pricepPrecision)
+	if side == client.BUY {
+		return price.Mul(p).Floor().Div(p)
+	}
+	return price.Mul(p).Ceil().Div(p)
+}
+
+func clampLevel(level, max int) int {
+	if level < 1 {
+		return 1
+	}
+	if level > max {
+		return max
+	}
+	return level
+}
+
+//inventory 基础币的总额(可用+冻结)
+func (ds *DigService) inventory() (decimal.Decimal, error) {
+	base, _ := SplitSymbol(ds.symbol)
+	bals, err := ds.ex.GetBalances()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	for _, b := range bals {
+		if b.Currency == base {
+			return b.Available.Add(b.Frozen), nil
+		}
+	}
+	return decimal.Zero, nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//请求的优先级，令牌不够时高优先级的请求先拿到令牌
+const (
+	PRIORITY_LOW    = 0 //查询
+	PRIORITY_NORMAL = 1 //下单
+	PRIORITY_HIGH   = 2 //撤单
+)
+
+//Limiter 令牌桶限频，每秒补充rate个令牌，最多攒burst个。
+//有高优先级的请求在等待时，低优先级的请求不会拿到令牌
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	waiting [PRIORITY_HIGH + 1]int
+}
+
+//NewLimiter rate为每秒的请求数，不大于0时返回nil，不限频；burst小于1时按1处理
+func NewLimiter(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+//Wait 等到拿到一个令牌，limiter为nil时不限频。ctx结束时不再等待，返回ctx的错误
+func (l *Limiter) Wait(ctx context.Context, priority int) error {
+	if l == nil {
+		return nil
+	}
+	if priority < PRIORITY_LOW {
+		priority = PRIORITY_LOW
+	} else if priority > PRIORITY_HIGH {
+		priority = PRIORITY_HIGH
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	registered := false
+	for {
+		l.refill()
+		if l.tokens >= 1 && !l.higherWaiting(priority) {
+			l.tokens--
+			if registered {
+				l.waiting[priority]--
+			}
+			return nil
+		}
+		if !registered {
+			l.waiting[priority]++
+			registered = true
+		}
+		wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		if wait < time.Millisecond {
+			wait = time.Millisecond
+		}
+		l.mu.Unlock()
+		select {
+		case <-time.After(wait):
+			l.mu.Lock()
+		case <-ctx.Done():
+			l.mu.Lock()
+			l.waiting[priority]--
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *Limiter) refill() {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+func (l *Limiter) higherWaiting(priority int) bool {
+	for p := priority + 1; p <= PRIORITY_HIGH; p++ {
+		if l.waiting[p] > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+//启动时发现交易所上有不在订单日志里的订单时的处理方式，这些订单可能是手动下的
+const (
+	ORPHAN_IGNORE = "ignore" //不处理，只记日志
+	ORPHAN_CANCEL = "cancel" //全部撤掉
+	ORPHAN_ADOPT  = "adopt"  //接管到DigService中，每个方向最多接管梯度的订单数，多余的撤掉
+)
+
+//启动时查询挂单失败后的重试，一直失败时不启动这个交易对
+const (
+	RECONCILE_RETRIES   = 5
+	RECONCILE_DELAY     = time.Second
+	RECONCILE_MAX_DELAY = 30 * time.Second
+)
+
+//SetOrphanPolicy 设置启动时对遗留订单的处理方式，默认ORPHAN_IGNORE。
+//订单日志里上次运行留下的订单不受影响，ORPHAN_ADOPT时接管，否则撤掉
+func (ds *DigService) SetOrphanPolicy(policy string) {
+	ds.orphanPolicy = policy
+}
+
+//Reconcile 启动时找回上次运行留下的订单。先从交易所查询submitted,partial_filled状态的订单，
+//订单日志里不在其中的已经在停机期间成交或撤销，逐个查询后补记；日志里的订单ORPHAN_ADOPT时接管否则撤掉，
+//不在日志里的按orphanPolicy处理。接管的订单下一个周期会按正常流程撤单重挂。
+//查询挂单按reconcileRetry重试，仍然失败时返回错误，这时不知道交易所上有哪些订单，不能开始运行。
+//找回完成后压缩订单日志，只保留还没有结束的订单
+func (ds *DigService) Reconcile() error {
+	orders, err := ds.openOrders()
+	if err != nil {
+		return fmt.Errorf("%s,get open orders failed,%v", ds.symbol, err)
+	}
+	open := make(map[string]bool, len(orders))
+	for _, o := range orders {
+		open[o.ID] = true
+	}
+
+	journaled := make(map[string]bool)
+	if ds.runner.journal != nil {
+		for _, e := range ds.runner.journal.OpenOrders(ds.symbol) {
+			journaled[e.OrderID] = true
+			if open[e.OrderID] {
+				continue
+			}
+			ds.runner.Track(e.OrderID, e.Side)
+			o, err := ds.runner.Refresh(e.OrderID)
+			if err != nil {
+				log.Errorf("%s,reconcile order %s failed,%v", ds.symbol, e.OrderID, err)
+				continue
+			}
+			if o.State == client.FILLED {
+				log.Infof("%s,order %s filled while stopped", ds.symbol, o.ID)
+			}
+		}
+	}
+
+	for _, o := range orders {
+		if !journaled[o.ID] {
+			if ds.orphanPolicy == ORPHAN_IGNORE {
+				log.Infof("%s,ignore %s order %s not placed by qt,price:%s,amount:%s", ds.symbol, o.Side, o.ID, o.Price, o.Amount)
+				continue
+			}
+			ds.runner.Record(orderEntry(JOURNAL_PLACED, o))
+		}
+		ds.runner.Track(o.ID, o.Side)
+		if ds.orphanPolicy == ORPHAN_ADOPT && ds.adopt(o) {
+			continue
+		}
+		log.Infof("%s,cancel orphan %s order %s", ds.symbol, o.Side, o.ID)
+		ds.runner.Cancel(o.ID)
+	}
+
+	if ds.runner.journal != nil {
+		if err = ds.runner.journal.Compact(); err != nil {
+			log.Errorf("%s,compact journal failed,%v", ds.symbol, err)
+		}
+	}
+	return nil
+}
+
+//openOrders 查询交易所上的挂单，失败时按reconcileRetry退避重试
+func (ds *DigService) openOrders() ([]*Order, error) {
+	for attempt := 0; ; attempt++ {
+		orders, err := ds.ex.GetOpenOrders(ds.symbol)
+		if err == nil || attempt >= ds.reconcileRetry.MaxRetries {
+			return orders, err
+		}
+		d := ds.reconcileRetry.Backoff(attempt)
+		log.Warnf("%s,get open orders failed,retry %d/%d in %v,%v", ds.symbol, attempt+1, ds.reconcileRetry.MaxRetries, d, err)
+		time.Sleep(d)
+	}
+}
+
+//adopt 接管一个还挂着的订单，对应方向的订单已经满了时返回false
+func (ds *DigService) adopt(o *Order) bool {
+	res := &OrderResult{ID: o.ID, Status: client.ORDER_STATES_SUCCESS}
+	if o.Side == client.BUY && len(ds.buyOrders) < ds.ladder.count() {
+		ds.buyOrders = append(ds.buyOrders, res)
+	} else if o.Side == client.SELL && len(ds.sellOrders) < ds.ladder.count() {
+		ds.sellOrders = append(ds.sellOrders, res)
+	} else {
+		return false
+	}
+	log.Infof("%s,adopt open %s order %s,price:%s,amount:%s", ds.symbol, o.Side, o.ID, o.Price, o.Amount)
+	return true
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//flakyOpenOrdersExchange 查询挂单前failures次失败
+type flakyOpenOrdersExchange struct {
+	*SimExchange
+	failures int
+	calls    int
+}
+
+func (fe *flakyOpenOrdersExchange) GetOpenOrders(symbol string) ([]*Order, error) {
+	fe.calls++
+	if fe.calls <= fe.failures {
+		return nil, ErrNetwork
+	}
+	return fe.SimExchange.GetOpenOrders(symbol)
+}
+
+//orderCountingExchange 统计查询单个订单的次数
+type orderCountingExchange struct {
+	*SimExchange
+	orderCalls int
+}
+
+func (oc *orderCountingExchange) GetOrder(id string) (*Order, error) {
+	oc.orderCalls++
+	return oc.SimExchange.GetOrder(id)
+}
+
+func TestDigService_Reconcile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "qt")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.wal")
+
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("4"))
+	j, _ := OpenJournal(path)
+	ds := newTestDigService(se)
+	ds.runner.SetJournal(j)
+	ds.runner.runOnce()
+	buyID, sellID := ds.buyOrders[0].ID, ds.sellOrders[0].ID
+	sells := len(ds.sellOrders)
+	j.Close()
+
+	//进程退出期间卖单成交了
+	se.SubmitExternal("eosusdt", client.BUY, dec("5.05"), dec("60"))
+
+	j, _ = OpenJournal(path)
+	defer j.Close()
+	ce := &orderCountingExchange{SimExchange: se}
+	ds = newTestDigService(ce)
+	ds.runner.SetJournal(j)
+	ds.SetOrphanPolicy(ORPHAN_ADOPT)
+	if err := ds.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	//还挂着的订单从挂单列表中找到，只查询已经结束的
+	if ce.orderCalls != sells {
+		t.Fatalf("get order calls %d,want %d", ce.orderCalls, sells)
+	}
+
+	if len(ds.buyOrders) == 0 || ds.buyOrders[0].ID != buyID {
+		t.Fatalf("open buy order should be adopted")
+	}
+	if len(ds.sellOrders) > 0 {
+		t.Fatalf("filled sell order %s should not be adopted", sellID)
+	}
+	open := j.OpenOrders("eosusdt")
+	if len(open) != 1 || open[0].OrderID != buyID {
+		t.Fatalf("open orders %v", open)
+	}
+	//找回后日志只剩还挂着的买单
+	if b, _ := ioutil.ReadFile(path); strings.Count(string(b), "\n") != 1 {
+		t.Fatalf("journal should be compacted:\n%s", b)
+	}
+
+	//接管的买单在下一个周期被正常撤掉
+	ds.runner.runOnce()
+	if o, _ := se.GetOrder(buyID); o.State != client.ORDER_STATE_CANCEL {
+		t.Fatalf("adopted order state %s", o.State)
+	}
+}
+
+func TestDigService_ReconcileOrphans(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	//上次运行留下的两个买单，不在日志里
+	first, _ := se.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.BUY, Price: dec("4.9"), Amount: dec("2")})
+	second, _ := se.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.BUY, Price: dec("4.8"), Amount: dec("2")})
+
+	ds := newTestDigService(se)
+	ds.SetOrphanPolicy(ORPHAN_ADOPT)
+	if err := ds.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ds.buyOrders) == 0 || ds.buyOrders[0].ID != first.ID {
+		t.Fatalf("first orphan should be adopted")
+	}
+	if o, _ := se.GetOrder(second.ID); o.State != client.ORDER_STATE_CANCEL {
+		t.Fatalf("extra orphan should be cancelled,state %s", o.State)
+	}
+
+	//默认不处理不在日志里的订单，可能是手动下的
+	ds = newTestDigService(se)
+	if err := ds.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := se.GetOrder(first.ID); o.State != ORDER_STATE_SUBMITTED || ds.runner.Tracked(first.ID) || len(ds.buyOrders) > 0 {
+		t.Fatalf("ignored orphan should be left alone")
+	}
+
+	ds = newTestDigService(se)
+	ds.SetOrphanPolicy(ORPHAN_CANCEL)
+	if err := ds.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ds.buyOrders) > 0 {
+		t.Fatalf("cancel policy should not adopt")
+	}
+	if open, _ := se.GetOpenOrders("eosusdt"); len(open) != 0 {
+		t.Fatalf("open orders left %v", open)
+	}
+	if usdt := balanceOf(t, se, "usdt"); !usdt.Available.Equal(dec("100")) {
+		t.Fatalf("usdt %v", usdt)
+	}
+}
+
+func TestDigService_ReconcileRetry(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	buy, _ := se.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.BUY, Price: dec("4.9"), Amount: dec("2")})
+
+	fe := &flakyOpenOrdersExchange{SimExchange: se, failures: 2}
+	ds := newTestDigService(fe)
+	ds.reconcileRetry = RetryPolicy{MaxRetries: 2}
+	ds.SetOrphanPolicy(ORPHAN_ADOPT)
+	if err := ds.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if fe.calls != 3 || len(ds.buyOrders) == 0 || ds.buyOrders[0].ID != buy.ID {
+		t.Fatalf("calls %d,open buy order should be adopted after retrying", fe.calls)
+	}
+
+	//一直查询不到挂单时不启动
+	fe = &flakyOpenOrdersExchange{SimExchange: se, failures: 10}
+	ds = newTestDigService(fe)
+	ds.reconcileRetry = RetryPolicy{MaxRetries: 2}
+	if err := ds.runner.Start(); err == nil {
+		t.Fatalf("start should fail,%v", err)
+	}
+	if fe.calls != 3 {
+		t.Fatalf("calls %d", fe.calls)
+	}
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	RECORD_DEPTH  = "depth"
+	RECORD_TICKER = "ticker"
+	RECORD_CANDLE = "candle"
+)
+
+//MarketData 行情接口，RestClient实现了它
+type MarketData interface {
+	GetDepth(symbol, depth string) (*client.Depth, error)
+	GetLatestTickerBySymbol(symbol string) (*client.TickerInfo, error)
+	GetCandle(symbol, resolution, limit string) (*client.Candle, error)
+}
+
+//Record 录制文件中的一行，Data是fcoin接口的原始返回
+type Record struct {
+	Ts     int64           `json:"ts"`
+	Symbol string          `json:"symbol"`
+	Kind   string          `json:"kind"`
+	Data   json.RawMessage `json:"data"`
+}
+
+//Recorder 定时拉取深度、ticker和k线，按交易对和类型写入按时间切分的jsonl文件，
+//文件名形如depth-eosusdt-20190625T1500.jsonl.gz
+type Recorder struct {
+	src              MarketData
+	symbols          []string
+	dir              string
+	rotate           time.Duration
+	compress         bool
+	candleResolution string
+	writers          map[string]*rotatingWriter
+}
+
+func NewRecorder(src MarketData, symbols []string, dir string, rotate time.Duration, compress bool, candleResolution string) *Recorder {
+	return &Recorder{
+		src:              src,
+		symbols:          symbols,
+		dir:              dir,
+		rotate:           rotate,
+		compress:         compress,
+		candleResolution: candleResolution,
+		writers:          make(map[string]*rotatingWriter),
+	}
+}
+
+//Run 每隔interval录制一次深度和ticker，每隔candleInterval录制一次k线，直到ctx结束
+func (r *Recorder) Run(ctx context.Context, interval, candleInterval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastCandle time.Time
+	for {
+		now := time.Now()
+		withCandle := now.Sub(lastCandle) >= candleInterval
+		if withCandle {
+			lastCandle = now
+		}
+		r.RecordOnce(now, withCandle)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//RecordOnce 录制所有交易对一次，单个接口失败只记录日志
+func (r *Recorder) RecordOnce(now time.Time, withCandle bool) {
+	for _, symbol := range r.symbols {
+		depth, err := r.src.GetDepth(symbol, DEPTH_LEVEL)
+		if err != nil {
+			log.Errorf("%s,record depth failed,%v", symbol, err)
+		} else if err = r.write(now, symbol, RECORD_DEPTH, depth); err != nil {
+			log.Errorf("%s,write depth failed,%v", symbol, err)
+		}
+
+		ticker, err := r.src.GetLatestTickerBySymbol(symbol)
+		if err != nil {
+			log.Errorf("%s,record ticker failed,%v", symbol, err)
+		} else if err = r.write(now, symbol, RECORD_TICKER, ticker); err != nil {
+			log.Errorf("%s,write ticker failed,%v", symbol, err)
+		}
+
+		if !withCandle {
+			continue
+		}
+		candle, err := r.src.GetCandle(symbol, r.candleResolution, "")
+		if err != nil {
+			log.Errorf("%s,record candle failed,%v", symbol, err)
+		} else if err = r.write(now, symbol, RECORD_CANDLE, candle); err != nil {
+			log.Errorf("%s,write candle failed,%v", symbol, err)
+		}
+	}
+}
+
+func (r *Recorder) write(now time.Time, symbol, kind string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(&Record{Ts: now.UnixNano() / 1000000, Symbol: symbol, Kind: kind, Data: data})
+	if err != nil {
+		return err
+	}
+	key := kind + "-" + symbol
+	w, ok := r.writers[key]
+	if !ok {
+		w = &rotatingWriter{dir: r.dir, prefix: key, rotate: r.rotate, compress: r.compress}
+		r.writers[key] = w
+	}
+	return w.writeLine(now, line)
+}
+
+//Close 关闭所有文件，gzip文件只有关闭后才完整
+func (r *Recorder) Close() error {
+	var first error
+	for _, w := range r.writers {
+		if err := w.close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+type rotatingWriter struct {
+	dir      string
+	prefix   string
+	rotate   time.Duration
+	compress bool
+	bucket   time.Time
+	file     *os.File
+	gz       *gzip.Writer
+	w        io.Writer
+}
+
+func (rw *rotatingWriter) writeLine(now time.Time, line []byte) error {
+	bucket := now.Truncate(rw.rotate)
+	if rw.w == nil || !bucket.Equal(rw.bucket) {
+		if err := rw.close(); err != nil {
+			return err
+		}
+		if err := rw.open(bucket); err != nil {
+			return err
+		}
+	}
+	_, err := rw.w.Write(append(line, '\n'))
+	return err
+}
+
+func (rw *rotatingWriter) open(bucket time.Time) error {
+	if err := os.MkdirAll(rw.dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.jsonl", rw.prefix, bucket.Format("20060102T1504"))
+	if rw.compress {
+		name += ".gz"
+	}
+	//同一时间段重启时追加，多段gzip可以被连续读取
+	f, err := os.OpenFile(filepath.Join(rw.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	rw.file, rw.bucket, rw.w = f, bucket, f
+	if rw.compress {
+		rw.gz = gzip.NewWriter(f)
+		rw.w = rw.gz
+	}
+	return nil
+}
+
+func (rw *rotatingWriter) close() error {
+	if rw.file == nil {
+		return nil
+	}
+	var err error
+	if rw.gz != nil {
+		err = rw.gz.Close()
+	}
+	if cerr := rw.file.Close(); err == nil {
+		err = cerr
+	}
+	rw.file, rw.gz, rw.w = nil, nil, nil
+	return err
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeMarketData struct {
+	bid float64
+}
+
+func (f *fakeMarketData) GetDepth(symbol, depth string) (*client.Depth, error) {
+	d := &client.Depth{}
+	d.Data.Bids, d.Data.Asks = flatBook(f.bid)
+	d.Data.Ts = time.Now().UnixNano() / 1000000
+	d.Data.Type = "depth.L20." + symbol
+	f.bid += 0.01
+	return d, nil
+}
+
+func (f *fakeMarketData) GetLatestTickerBySymbol(symbol string) (*client.TickerInfo, error) {
+	t := &client.TickerInfo{}
+	t.Data.Ticker = []float64{f.bid, 1}
+	return t, nil
+}
+
+func (f *fakeMarketData) GetCandle(symbol, resolution, limit string) (*client.Candle, error) {
+	return &client.Candle{}, nil
+}
+
+func TestRecorder_RotateAndReplay(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "qt")
+	defer os.RemoveAll(dir)
+
+	rec := NewRecorder(&fakeMarketData{bid: 5}, []string{"eosusdt"}, dir, time.Hour, true, "M1")
+	start := time.Date(2019, 6, 25, 14, 59, 0, 0, time.UTC)
+	rec.RecordOnce(start, true)
+	rec.RecordOnce(start.Add(30*time.Second), false)
+	rec.RecordOnce(start.Add(2*time.Minute), false)
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "depth-eosusdt-*.jsonl.gz"))
+	if len(files) != 2 {
+		t.Fatalf("want 2 rotated depth files,got %v", files)
+	}
+	if candles, _ := filepath.Glob(filepath.Join(dir, "candle-eosusdt-*")); len(candles) != 1 {
+		t.Fatalf("candle files %v", candles)
+	}
+
+	//重启后追加到同一个文件
+	rec = NewRecorder(&fakeMarketData{bid: 6}, []string{"eosusdt"}, dir, time.Hour, true, "M1")
+	rec.RecordOnce(start.Add(3*time.Minute), false)
+	rec.Close()
+
+	depths, err := LoadDepths(filepath.Join(dir, "depth-eosusdt-20190625T1500.jsonl.gz"), "eosusdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(depths) != 2 || !depths[1].Bids[0].Price.Equal(dec("6")) {
+		t.Fatalf("unexpected depths %v", depths)
+	}
+	//ticker文件中没有深度
+	tickers, _ := filepath.Glob(filepath.Join(dir, "ticker-eosusdt-*"))
+	if depths, _ := LoadDepths(tickers[0], "eosusdt"); len(depths) != 0 {
+		t.Fatalf("ticker file should not yield depths")
+	}
+}
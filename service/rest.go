@@ -0,0 +1,296 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+//REQUEST_TIMEOUT 单个请求的超时时间
+const REQUEST_TIMEOUT = 10 * time.Second
+
+var httpClient = &http.Client{Timeout: REQUEST_TIMEOUT}
+
+//RequestObserver 每个请求结束后调用，endpoint如depth、create_order，status为fcoin返回的状态码，
+//请求失败或返回的不是fcoin的状态码时err不为nil
+type RequestObserver func(endpoint string, status int, elapsed time.Duration, err error)
+
+//RestClient fcoin的REST接口，签名方式和返回的结构与client.FCoinClient相同，
+//另外加上了请求超时、限频、错误分类(见errors.go)、幂等请求的重试和请求的观察者
+type RestClient struct {
+	secretKey   string
+	assetKey    string
+	baseUrl     string
+	observer    RequestObserver
+	public      *Limiter //行情接口
+	private     *Limiter //账户和订单接口
+	retryPolicy RetryPolicy
+	shutdown    <-chan struct{} //关闭后不再重试
+	ctx         context.Context //结束后中断正在进行的请求
+}
+
+func NewRestClient(secretKey, assKey, baseUrl string) *RestClient {
+	return &RestClient{secretKey: secretKey, assetKey: assKey, baseUrl: baseUrl}
+}
+
+//SetObserver 设置请求的观察者，用来统计耗时和错误
+func (f *RestClient) SetObserver(o RequestObserver) {
+	f.observer = o
+}
+
+//SetRateLimits 设置行情接口和私有接口的限频，同一个client的所有请求共用，nil为不限频
+func (f *RestClient) SetRateLimits(public, private *Limiter) {
+	f.public = public
+	f.private = private
+}
+
+//SetRetryPolicy 设置查询和撤单失败后的重试，下单不重试
+func (f *RestClient) SetRetryPolicy(p RetryPolicy) {
+	f.retryPolicy = p
+}
+
+//SetShutdown shutdown关闭后失败的请求不再重试，正在等待重试的请求立即返回错误
+func (f *RestClient) SetShutdown(shutdown <-chan struct{}) {
+	f.shutdown = shutdown
+}
+
+//SetContext ctx结束后正在进行和之后的请求立即返回NetworkError
+func (f *RestClient) SetContext(ctx context.Context) {
+	f.ctx = ctx
+}
+
+func (f *RestClient) requestContext() context.Context {
+	if f.ctx == nil {
+		return context.Background()
+	}
+	return f.ctx
+}
+
+func (f *RestClient) observe(endpoint string, start time.Time, err error) {
+	if f.observer == nil {
+		return
+	}
+	var ae *APIError
+	if errors.As(err, &ae) {
+		f.observer(endpoint, ae.Status, time.Since(start), nil)
+		return
+	}
+	f.observer(endpoint, 0, time.Since(start), err)
+}
+
+//sign fcoin的签名：base64(method+url+时间戳+参数)再用secretKey做hmac-sha1
+func (f *RestClient) sign(method, url, params string) (string, string) {
+	timeStamp := time.Now().UnixNano() / 1000000
+	ts := strconv.FormatInt(timeStamp, 10)
+	firstBase64 := base64.StdEncoding.EncodeToString([]byte(method + url + ts + params))
+	mac := hmac.New(sha1.New, []byte(f.secretKey))
+	mac.Write([]byte(firstBase64))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), ts
+}
+
+//getResponse GET请求是幂等的，失败时按重试策略重试，每次重试重新拿令牌和签名
+func (f *RestClient) getResponse(endpoint, url string, isPrivate bool) ([]byte, error) {
+	return f.retry(endpoint, func() ([]byte, error) {
+		limiter := f.public
+		if isPrivate {
+			limiter = f.private
+		}
+		if err := limiter.Wait(f.requestContext(), priorityOf(endpoint)); err != nil {
+			return nil, &NetworkError{Endpoint: endpoint, Err: err}
+		}
+		request, err := http.NewRequestWithContext(f.requestContext(), http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		if isPrivate {
+			encoded, timeStamp := f.sign(http.MethodGet, url, "")
+			request.Header.Set("FC-ACCESS-KEY", f.assetKey)
+			request.Header.Set("FC-ACCESS-TIMESTAMP", timeStamp)
+			request.Header.Set("FC-ACCESS-SIGNATURE", encoded)
+		}
+		return f.do(endpoint, request)
+	})
+}
+
+//postResponse 拿到private的令牌后再签名，避免等待后时间戳过期
+func (f *RestClient) postResponse(endpoint, url, params string, body []byte) ([]byte, error) {
+	if err := f.private.Wait(f.requestContext(), priorityOf(endpoint)); err != nil {
+		return nil, &NetworkError{Endpoint: endpoint, Err: err}
+	}
+	encoded, timeStamp := f.sign(http.MethodPost, url, params)
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequestWithContext(f.requestContext(), http.MethodPost, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("FC-ACCESS-KEY", f.assetKey)
+	request.Header.Set("FC-ACCESS-TIMESTAMP", timeStamp)
+	request.Header.Set("FC-ACCESS-SIGNATURE", encoded)
+	return f.do(endpoint, request)
+}
+
+//do 发送请求，发送或读取失败为NetworkError，fcoin的状态码不是0为APIError，
+//没有状态码的非2xx返回为HTTPError，返回为空为ErrEmptyResponse
+func (f *RestClient) do(endpoint string, request *http.Request) (content []byte, err error) {
+	start := time.Now()
+	defer func() { f.observe(endpoint, start, err) }()
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return nil, &NetworkError{Endpoint: endpoint, Err: err}
+	}
+	defer resp.Body.Close()
+	content, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &NetworkError{Endpoint: endpoint, Err: err}
+	}
+	return content, checkResponse(endpoint, resp.StatusCode, content)
+}
+
+func checkResponse(endpoint string, statusCode int, content []byte) error {
+	res := struct {
+		Status int    `json:"status"`
+		Msg    string `json:"msg"`
+	}{}
+	if len(content) > 0 && json.Unmarshal(content, &res) == nil && res.Status != client.ORDER_STATES_SUCCESS {
+		return &APIError{Endpoint: endpoint, Status: res.Status, Msg: res.Msg}
+	}
+	if statusCode < http.StatusOK || statusCode >= http.StatusMultipleChoices {
+		body := string(content)
+		if len(body) > 200 {
+			body = body[:200]
+		}
+		return &HTTPError{Endpoint: endpoint, StatusCode: statusCode, Body: body}
+	}
+	if len(content) == 0 {
+		return fmt.Errorf("%s,%w", endpoint, ErrEmptyResponse)
+	}
+	return nil
+}
+
+func (f *RestClient) GetBalance() (*client.BalanceInfo, error) {
+	content, err := f.getResponse("balance", f.baseUrl+"/accounts/balance", true)
+	if err != nil {
+		return nil, err
+	}
+	info := &client.BalanceInfo{}
+	err = json.Unmarshal(content, info)
+	return info, err
+}
+
+//GetOrders 查询订单列表，states多个状态用逗号隔开，before为创建时间，limit最大100
+func (f *RestClient) GetOrders(symbol, states, before string, limit int) (*client.OrderList, error) {
+	q := url.Values{}
+	q.Set("symbol", symbol)
+	q.Set("states", states)
+	q.Set("limit", strconv.Itoa(limit))
+	if before != "" {
+		q.Set("before", before)
+	}
+	content, err := f.getResponse("orders", f.baseUrl+"/orders?"+q.Encode(), true)
+	if err != nil {
+		return nil, err
+	}
+	list := &client.OrderList{}
+	err = json.Unmarshal(content, list)
+	return list, err
+}
+
+func (f *RestClient) GetOrderById(id string) (*client.OrderInfo, error) {
+	content, err := f.getResponse("order", f.baseUrl+"/orders/"+id, true)
+	if err != nil {
+		return nil, err
+	}
+	info := &client.OrderInfo{}
+	err = json.Unmarshal(content, info)
+	return info, err
+}
+
+//CreateOrder 下单不是幂等的，失败时不重试，余额不足返回的错误满足errors.Is(err, ErrInsufficientBalance)
+func (f *RestClient) CreateOrder(newOrder *client.NewOrder) (*client.OrderResult, error) {
+	formatStr := "account_ype=%s&amount=%s&exchange=%s&price=%s&side=%s&symbol=%s&type=%s"
+	params := fmt.Sprintf(formatStr, newOrder.AccountType, newOrder.Amount, newOrder.Exchange, newOrder.Price, newOrder.Side, newOrder.Symbol, newOrder.OrderType)
+	b, _ := json.Marshal(newOrder)
+	content, err := f.postResponse("create_order", f.baseUrl+"/orders", params, b)
+	if err != nil {
+		return nil, err
+	}
+	result := &client.OrderResult{}
+	err = json.Unmarshal(content, result)
+	return result, err
+}
+
+//CancelOrder 撤单是幂等的，失败时按重试策略重试，已经成交或撤销的订单返回的错误满足errors.Is(err, ErrOrderClosed)
+func (f *RestClient) CancelOrder(id string) (*client.CancelResult, error) {
+	url := f.baseUrl + "/orders/" + id + "/submit-cancel"
+	content, err := f.retry("cancel_order", func() ([]byte, error) {
+		return f.postResponse("cancel_order", url, "", nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := &client.CancelResult{}
+	err = json.Unmarshal(content, result)
+	return result, err
+}
+
+func (f *RestClient) GetLatestTickerBySymbol(symbol string) (*client.TickerInfo, error) {
+	content, err := f.getResponse("ticker", f.baseUrl+"/market/ticker/"+symbol, false)
+	if err != nil {
+		return nil, err
+	}
+	ticker := &client.TickerInfo{}
+	err = json.Unmarshal(content, ticker)
+	return ticker, err
+}
+
+//GetCandle 返回的k线从新到旧排列，limit为空时取21根
+func (f *RestClient) GetCandle(symbol, resolution, limit string) (*client.Candle, error) {
+	if limit == "" {
+		limit = "21"
+	}
+	content, err := f.getResponse("candles", f.baseUrl+"/market/candles/"+resolution+"/"+symbol+"?limit="+limit, false)
+	if err != nil {
+		return nil, err
+	}
+	c := &client.Candle{}
+	err = json.Unmarshal(content, c)
+	return c, err
+}
+
+func (f *RestClient) GetDepth(symbol, level string) (*client.Depth, error) {
+	content, err := f.getResponse("depth", f.baseUrl+"/market/depth/"+level+"/"+symbol, false)
+	if err != nil {
+		return nil, err
+	}
+	d := &client.Depth{}
+	err = json.Unmarshal(content, d)
+	return d, err
+}
+
+//priorityOf 撤单优先，其次下单，查询最后
+func priorityOf(endpoint string) int {
+	switch endpoint {
+	case "cancel_order":
+		return PRIORITY_HIGH
+	case "create_order":
+		return PRIORITY_NORMAL
+	}
+	return PRIORITY_LOW
+}
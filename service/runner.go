@@ -0,0 +1,373 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/MrChang666/qt/config"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+//Runner 驱动一个交易对上的Strategy：每隔period秒拉取深度并调用策略的钩子。
+//策略的下单、撤单都通过Runner，Runner跟踪还挂着的订单、写订单日志，发现成交时回调OnFill
+type Runner struct {
+	mu        sync.Mutex
+	symbol    string
+	ex        Exchange
+	strategy  Strategy
+	period    int
+	journal   *Journal
+	orders    map[string]string //还挂着的订单id->方向
+	now       func() time.Time
+	pending   *config.SymbolConfig //下一个周期开始时生效的配置
+	paused    bool
+	lastDepth time.Time     //最近一次取到深度的时间
+	control   chan func()   //在Run的goroutine中执行的操作，见Do
+	stopped   chan struct{} //Run返回后关闭
+	feed      *MarketFeed
+	feedGap   time.Duration //深度推送触发的两个周期之间的最小间隔
+}
+
+//Do等待Run接收操作的最长时间
+const CONTROL_TIMEOUT = 30 * time.Second
+
+func NewRunner(symbol string, ex Exchange, strategy Strategy, period int) *Runner {
+	r := &Runner{
+		symbol:   symbol,
+		ex:       ex,
+		strategy: strategy,
+		period:   period,
+		orders:   make(map[string]string),
+		now:      time.Now,
+		control:  make(chan func()),
+		stopped:  make(chan struct{}),
+	}
+	strategy.Init(r)
+	return r
+}
+
+//SetFeed 深度有推送时提前开始下一个周期，两个周期之间至少间隔minInterval，没有推送时仍然每隔period秒执行
+func (r *Runner) SetFeed(f *MarketFeed, minInterval time.Duration) {
+	r.feed = f
+	r.feedGap = minInterval
+}
+
+//SetJournal 开启订单日志，下单、撤单和成交都会写入j
+func (r *Runner) SetJournal(j *Journal) {
+	r.journal = j
+}
+
+func (r *Runner) Symbol() string {
+	return r.symbol
+}
+
+func (r *Runner) Exchange() Exchange {
+	return r.ex
+}
+
+func (r *Runner) Strategy() Strategy {
+	return r.strategy
+}
+
+//Start 运行前调用策略的OnStart，找回上次运行留下的订单
+func (r *Runner) Start() error {
+	return r.strategy.OnStart()
+}
+
+//Run 每隔period秒执行一个周期，设置了SetFeed时深度有更新就提前执行，周期之间执行Do提交的操作，
+//ctx结束或者策略要求停止时调用OnShutdown后返回
+func (r *Runner) Run(ctx context.Context) {
+	defer close(r.stopped)
+	defer r.strategy.OnShutdown()
+	var updates <-chan struct{}
+	if r.feed != nil {
+		ch, stop := r.feed.Watch(r.symbol)
+		defer stop()
+		updates = ch
+	}
+	for {
+		start := time.Now()
+		if !r.runOnce() {
+			return
+		}
+		next := time.After(time.Second * time.Duration(r.period))
+		var early <-chan time.Time
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case f := <-r.control:
+				f()
+			case <-updates:
+				if early == nil {
+					early = time.After(r.feedGap - time.Since(start))
+				}
+			case <-early:
+				break wait
+			case <-next:
+				break wait
+			}
+		}
+	}
+}
+
+//Do 在两个周期之间执行f，执行时策略不在运行，f返回后Do才返回。Run已经返回时立即返回错误，
+//还没有开始运行时超时返回错误
+func (r *Runner) Do(f func()) error {
+	done := make(chan struct{})
+	select {
+	case r.control <- func() {
+		defer close(done)
+		defer handlePanic()
+		f()
+	}:
+	case <-r.stopped:
+		return fmt.Errorf("%s is not running", r.symbol)
+	case <-time.After(CONTROL_TIMEOUT):
+		return fmt.Errorf("%s is not running", r.symbol)
+	}
+	<-done
+	return nil
+}
+
+//Pause 暂停运行并撤掉所有订单，返回没有撤掉的数量。暂停时不再调用策略的钩子
+func (r *Runner) Pause() (int, error) {
+	left := 0
+	err := r.Do(func() {
+		r.mu.Lock()
+		r.paused = true
+		r.mu.Unlock()
+		left = r.CancelAll()
+		log.Infof("%s,paused,%d orders left", r.symbol, left)
+	})
+	return left, err
+}
+
+//Resume 恢复运行，下一个周期按最新深度重新挂单
+func (r *Runner) Resume() error {
+	return r.Do(func() {
+		r.mu.Lock()
+		r.paused = false
+		r.mu.Unlock()
+		log.Infof("%s,resumed", r.symbol)
+	})
+}
+
+//ForceCancel 撤掉所有订单，返回没有撤掉的数量，没有暂停的策略下一个周期会重新挂单
+func (r *Runner) ForceCancel() (int, error) {
+	left := 0
+	err := r.Do(func() {
+		left = r.CancelAll()
+		log.Infof("%s,force cancelled,%d orders left", r.symbol, left)
+	})
+	return left, err
+}
+
+func (r *Runner) Paused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.paused
+}
+
+//LastDepth 最近一次取到深度的时间，还没有取到时为零值
+func (r *Runner) LastDepth() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastDepth
+}
+
+//Orders 还挂着的订单id->方向
+func (r *Runner) Orders() map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	orders := make(map[string]string, len(r.orders))
+	for id, side := range r.orders {
+		orders[id] = side
+	}
+	return orders
+}
+
+//Reconfigure 修改运行参数，在下一个周期开始时生效。策略实现了Reconfigurable时一起修改
+func (r *Runner) Reconfigure(conf *config.SymbolConfig) {
+	r.mu.Lock()
+	r.pending = conf
+	r.mu.Unlock()
+}
+
+//applyPending 应用Reconfigure传入的配置，只在Run的goroutine中调用
+func (r *Runner) applyPending() {
+	r.mu.Lock()
+	conf := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+	if conf == nil {
+		return
+	}
+	r.period = conf.Period
+	if rc, ok := r.strategy.(Reconfigurable); ok {
+		rc.Reconfigure(conf)
+	}
+	log.Infof("%s,config reloaded", r.symbol)
+}
+
+//runOnce 先应用待生效的配置，暂停时直接返回，否则调用OnTimer，再把最新深度交给OnDepth，取不到深度时传nil
+func (r *Runner) runOnce() bool {
+	r.applyPending()
+	if r.Paused() {
+		return true
+	}
+	r.strategy.OnTimer(r.now())
+	depth, err := r.ex.GetDepth(r.symbol)
+	if err != nil {
+		log.Error(err)
+		depth = nil
+	} else {
+		r.mu.Lock()
+		r.lastDepth = r.now()
+		r.mu.Unlock()
+	}
+	return r.strategy.OnDepth(depth)
+}
+
+//OpenOrders Runner跟踪的还挂着的订单id
+func (r *Runner) OpenOrders() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]string, 0, len(r.orders))
+	for id := range r.orders {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+//Tracked 订单是否还挂着
+func (r *Runner) Tracked(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.orders[id]
+	return ok
+}
+
+//Track 跟踪一个不是通过Place挂出的订单，比如重启后接管的订单
+func (r *Runner) Track(id, side string) {
+	r.mu.Lock()
+	r.orders[id] = side
+	r.mu.Unlock()
+}
+
+func (r *Runner) untrack(id string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	side := r.orders[id]
+	delete(r.orders, id)
+	return side
+}
+
+func (r *Runner) side(id string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.orders[id]
+}
+
+//Place 下一个限价单，交易所拒绝时返回的错误包含*APIError，可以用errors.Is判断原因
+func (r *Runner) Place(side string, price, amount decimal.Decimal) (*OrderResult, error) {
+	res, err := r.ex.CreateOrder(&OrderRequest{Symbol: r.symbol, Side: side, Price: price, Amount: amount})
+	if err != nil {
+		return nil, err
+	}
+	if res.Status != client.ORDER_STATES_SUCCESS {
+		return res, fmt.Errorf("%s,%s order failed,%w", r.symbol, side, &APIError{Endpoint: "create_order", Status: res.Status, Msg: res.Msg})
+	}
+	r.Track(res.ID, side)
+	ordersCreated.With(r.symbol, side).Inc()
+	r.Record(&JournalEntry{Event: JOURNAL_PLACED, OrderID: res.ID, Symbol: r.symbol, Side: side, Price: price, Amount: amount})
+	return res, nil
+}
+
+//Cancel 撤单，已经撤掉或者撤单前已经成交时返回true，撤单前成交的部分会回调OnFill。
+//撤单是异步的，订单还没有结束或者查不到最终状态时返回false并继续跟踪，下一次撤单时再确认
+func (r *Runner) Cancel(id string) bool {
+	defer handlePanic()
+	side := r.side(id)
+	log.Debugf("begin to cancel %s order %s", side, id)
+	res, err := r.ex.CancelOrder(id)
+	if err != nil {
+		log.Errorf("cancel %s order failed,%v", side, err)
+		return false
+	}
+	if res.Status != client.ORDER_STATES_SUCCESS && res.Status != client.CANCEL_SUCCESS_ORDER {
+		//都是非正常情况
+		log.Errorf("cancel %s order error,%v", side, res)
+		return false
+	}
+	//3008时订单可能已经成交也可能已经撤销，都按查询到的状态记录
+	orderInfo, err := r.Refresh(id)
+	if err != nil {
+		log.Errorf("get %s order info failed,%v", side, err)
+		return false
+	}
+	if r.Tracked(id) {
+		log.Infof("%s,cancel %s order %s submitted,state %s", r.symbol, side, id, orderInfo.State)
+		return false
+	}
+	return true
+}
+
+//CancelAll 撤掉所有跟踪的订单，返回没有撤掉的数量
+func (r *Runner) CancelAll() int {
+	left := 0
+	for _, id := range r.OpenOrders() {
+		if !r.Cancel(id) {
+			left++
+		}
+	}
+	return left
+}
+
+//Refresh 查询订单，已经成交的回调OnFill，已经撤销的记入日志(有部分成交的也回调OnFill)，
+//之后都不再跟踪。已经不再跟踪的订单只返回查询结果，不会重复记录
+func (r *Runner) Refresh(id string) (*Order, error) {
+	o, err := r.ex.GetOrder(id)
+	if err != nil {
+		return nil, err
+	}
+	if !r.Tracked(id) {
+		return o, nil
+	}
+	switch o.State {
+	case client.FILLED:
+		r.Fill(o)
+	case client.ORDER_STATE_CANCEL, ORDER_STATE_PARTIAL_CANCELED:
+		r.untrack(id)
+		ordersCancelled.With(r.symbol, o.Side).Inc()
+		r.Record(orderEntry(JOURNAL_CANCELLED, o))
+		if o.FilledAmount.IsPositive() {
+			r.strategy.OnFill(o)
+		}
+	}
+	return o, nil
+}
+
+//Fill 记录一个已经成交的订单并回调OnFill
+func (r *Runner) Fill(o *Order) {
+	r.untrack(o.ID)
+	ordersFilled.With(r.symbol, o.Side).Inc()
+	r.Record(orderEntry(JOURNAL_FILLED, o))
+	r.strategy.OnFill(o)
+}
+
+//Record 写一条订单日志，没有开启日志时忽略
+func (r *Runner) Record(e *JournalEntry) {
+	if r.journal == nil {
+		return
+	}
+	if err := r.journal.Append(e); err != nil {
+		log.Errorf("%s,write journal failed,%v", r.symbol, err)
+	}
+}
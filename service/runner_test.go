@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/MrChang666/qt/config"
+	"testing"
+	"time"
+)
+
+//recordingStrategy 记录钩子调用的策略
+type recordingStrategy struct {
+	r        *Runner
+	depths   int
+	timers   int
+	fills    []*Order
+	shutdown bool
+}
+
+func (rs *recordingStrategy) Init(r *Runner)        { rs.r = r }
+func (rs *recordingStrategy) OnStart() error        { return nil }
+func (rs *recordingStrategy) OnTimer(now time.Time) { rs.timers++ }
+func (rs *recordingStrategy) OnFill(o *Order)       { rs.fills = append(rs.fills, o) }
+func (rs *recordingStrategy) OnShutdown()           { rs.shutdown = true }
+func (rs *recordingStrategy) OnDepth(depth *Depth) bool {
+	rs.depths++
+	return depth != nil
+}
+
+func TestRunner_Orders(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	rs := &recordingStrategy{}
+	r := NewRunner("eosusdt", se, rs, 1)
+	if rs.r != r {
+		t.Fatal("Init should bind the runner")
+	}
+
+	filled, err := r.Place(client.BUY, dec("5.01"), dec("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resting, _ := r.Place(client.BUY, dec("4.9"), dec("1"))
+	if _, err = r.Place(client.BUY, dec("4.9"), dec("100")); err == nil {
+		t.Fatal("insufficient balance should be an error")
+	}
+	if ids := r.OpenOrders(); len(ids) != 2 {
+		t.Fatalf("open orders %v", ids)
+	}
+
+	if o, _ := r.Refresh(filled.ID); o.State != client.FILLED || len(rs.fills) != 1 || r.Tracked(filled.ID) {
+		t.Fatalf("fill should be reported once,%v", rs.fills)
+	}
+	if o, _ := r.Refresh(resting.ID); o.State != ORDER_STATE_SUBMITTED || !r.Tracked(resting.ID) {
+		t.Fatal("resting order should stay tracked")
+	}
+	if left := r.CancelAll(); left != 0 || len(r.OpenOrders()) != 0 {
+		t.Fatalf("%d orders left", left)
+	}
+}
+
+func TestRunner_Run(t *testing.T) {
+	rs := &recordingStrategy{}
+	r := NewRunner("eosusdt", newSimBook(), rs, 60)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.Run(ctx)
+	if rs.timers != 1 || rs.depths != 1 || !rs.shutdown {
+		t.Fatalf("timers %d,depths %d,shutdown %v", rs.timers, rs.depths, rs.shutdown)
+	}
+
+	//取不到深度时depth为nil，策略要求停止
+	empty := &recordingStrategy{}
+	NewRunner("btcusdt", newSimBook(), empty, 60).Run(context.Background())
+	if empty.depths != 1 || !empty.shutdown {
+		t.Fatal("runner should stop when the strategy returns false")
+	}
+}
+
+func TestNewStrategy(t *testing.T) {
+	if st, err := NewStrategy(&config.SymbolConfig{Strategy: "recording"}, nil); err != nil || st == nil {
+		t.Fatalf("registered strategy,%v", err)
+	}
+	if _, err := NewStrategy(&config.SymbolConfig{Strategy: "nope"}, nil); err == nil {
+		t.Fatal("unknown strategy should be an error")
+	}
+}
+
+func TestRunner_CancelClosed(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	rs := &recordingStrategy{}
+	r := NewRunner("eosusdt", se, rs, 1)
+	res, _ := r.Place(client.BUY, dec("4.9"), dec("1"))
+
+	//在qt之外撤掉的订单再撤返回3008，不能当成成交
+	se.CancelOrder(res.ID)
+	if !r.Cancel(res.ID) || r.Tracked(res.ID) || len(rs.fills) != 0 {
+		t.Fatalf("cancelled order should not be reported as filled,%v", rs.fills)
+	}
+}
+
+func TestRunner_DoStopped(t *testing.T) {
+	r := NewRunner("btcusdt", newSimBook(), &recordingStrategy{}, 60)
+	r.Run(context.Background())
+	start := time.Now()
+	if err := r.Do(func() {}); err == nil || time.Since(start) > time.Second {
+		t.Fatalf("stopped runner should return at once,%v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package service
+
+import (
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+	"sort"
+	"sync"
+	"time"
+)
+
+const SIM_DEPTH_LEVELS = 20
+
+type simMarket struct {
+	base  string
+	quote string
+	bids  []*simOrder //价格从高到低，同价按时间先后
+	asks  []*simOrder //价格从低到高，同价按时间先后
+}
+
+type simOrder struct {
+	Order
+	seq      int64
+	external bool //外部挂单，不占用我们的余额
+}
+
+func (o *simOrder) remaining() decimal.Decimal {
+	return o.Amount.Sub(o.FilledAmount)
+}
+
+func (o *simOrder) isOpen() bool {
+	return o.State == ORDER_STATE_SUBMITTED || o.State == client.PARTIAL_FILLED
+}
+
+//SimExchange 内存撮合的模拟交易所，按价格优先、时间优先撮合限价单，
+//维护每个币种的可用和冻结余额，返回的状态码和fcoin保持一致
+type SimExchange struct {
+	mu       sync.Mutex
+	markets  map[string]*simMarket
+	ledger   *ledger
+	orders   map[string]*simOrder
+	seq      int64
+	depthSeq int64
+	now      func() time.Time
+}
+
+func NewSimExchange(feeRate decimal.Decimal) *SimExchange {
+	return &SimExchange{
+		markets: make(map[string]*simMarket),
+		ledger:  newLedger(feeRate),
+		orders:  make(map[string]*simOrder),
+		now:     time.Now,
+	}
+}
+
+//AddMarket 注册交易对，如btcusdt,btc,usdt
+func (se *SimExchange) AddMarket(symbol, base, quote string) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	if _, ok := se.markets[symbol]; !ok {
+		se.markets[symbol] = &simMarket{base: base, quote: quote}
+	}
+}
+
+//SetBalance 设置某个币种的可用余额
+func (se *SimExchange) SetBalance(currency string, available decimal.Decimal) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	se.ledger.balance(currency).Available = available
+}
+
+//SubmitExternal 以外部参与者身份下限价单，先与盘口撮合，剩余部分挂在盘口上，
+//用于构造深度或者模拟别人吃掉我们的挂单
+func (se *SimExchange) SubmitExternal(symbol, side string, price, amount decimal.Decimal) error {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	m, ok := se.markets[symbol]
+	if !ok {
+		return fmt.Errorf("unknown symbol %s", symbol)
+	}
+	o := se.newOrder(symbol, side, price, amount)
+	o.external = true
+	se.match(m, o)
+	return nil
+}
+
+func (se *SimExchange) GetDepth(symbol string) (*Depth, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	m, ok := se.markets[symbol]
+	if !ok {
+		return nil, fmt.Errorf("unknown symbol %s", symbol)
+	}
+	return &Depth{
+		Symbol: symbol,
+		Bids:   aggregate(m.bids),
+		Asks:   aggregate(m.asks),
+		Ts:     se.now().UnixNano() / 1000000,
+		Seq:    se.depthSeq,
+	}, nil
+}
+
+func aggregate(orders []*simOrder) []Level {
+	levels := make([]Level, 0, SIM_DEPTH_LEVELS)
+	for _, o := range orders {
+		n := len(levels)
+		if n > 0 && levels[n-1].Price.Equal(o.Price) {
+			levels[n-1].Amount = levels[n-1].Amount.Add(o.remaining())
+			continue
+		}
+		if n == SIM_DEPTH_LEVELS {
+			break
+		}
+		levels = append(levels, Level{Price: o.Price, Amount: o.remaining()})
+	}
+	return levels
+}
+
+func (se *SimExchange) GetBalances() ([]*Balance, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.ledger.snapshot(), nil
+}
+
+func (se *SimExchange) CreateOrder(req *OrderRequest) (*OrderResult, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	m, ok := se.markets[req.Symbol]
+	if !ok {
+		return nil, fmt.Errorf("unknown symbol %s", req.Symbol)
+	}
+	if !req.Price.IsPositive() || !req.Amount.IsPositive() {
+		return nil, fmt.Errorf("invalid order,price:%s,amount:%s", req.Price, req.Amount)
+	}
+
+	if req.Side != client.BUY && req.Side != client.SELL {
+		return nil, fmt.Errorf("invalid side %s", req.Side)
+	}
+	if !se.ledger.freeze(m.base, m.quote, req.Side, req.Price, req.Amount) {
+		return &OrderResult{Status: client.ORDER_INSUFFICIENT, Msg: "insufficient balance"}, nil
+	}
+
+	o := se.newOrder(req.Symbol, req.Side, req.Price, req.Amount)
+	se.match(m, o)
+	return &OrderResult{ID: o.ID, Status: client.ORDER_STATES_SUCCESS}, nil
+}
+
+func (se *SimExchange) CancelOrder(id string) (*CancelResult, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	o, ok := se.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("order %s not found", id)
+	}
+	if !o.isOpen() {
+		return &CancelResult{Status: client.CANCEL_SUCCESS_ORDER}, nil
+	}
+	m := se.markets[o.Symbol]
+	if o.Side == client.BUY {
+		m.bids = removeOrder(m.bids, o)
+	} else {
+		m.asks = removeOrder(m.asks, o)
+	}
+	se.depthSeq++
+
+	if !o.external {
+		se.ledger.unfreeze(m.base, m.quote, o.Side, o.Price, o.remaining())
+	}
+	if o.FilledAmount.IsPositive() {
+		o.State = ORDER_STATE_PARTIAL_CANCELED
+	} else {
+		o.State = client.ORDER_STATE_CANCEL
+	}
+	return &CancelResult{Status: client.ORDER_STATES_SUCCESS}, nil
+}
+
+func (se *SimExchange) GetOrder(id string) (*Order, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	o, ok := se.orders[id]
+	if !ok || o.external {
+		return nil, fmt.Errorf("order %s not found", id)
+	}
+	c := o.Order
+	return &c, nil
+}
+
+func (se *SimExchange) GetOpenOrders(symbol string) ([]*Order, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return openOrders(se.orders, symbol), nil
+}
+
+func (se *SimExchange) newOrder(symbol, side string, price, amount decimal.Decimal) *simOrder {
+	se.seq++
+	o := &simOrder{
+		Order: Order{
+			ID:        fmt.Sprintf("sim%d", se.seq),
+			Symbol:    symbol,
+			Side:      side,
+			Type:      client.ORDER_TYPE_LIMIT,
+			State:     ORDER_STATE_SUBMITTED,
+			Price:     price,
+			Amount:    amount,
+			CreatedAt: se.now().UnixNano() / 1000000,
+		},
+		seq: se.seq,
+	}
+	se.orders[o.ID] = o
+	return o
+}
+
+//match 用o去吃对手盘，成交价为对手挂单的价格，剩余部分按价格时间优先挂到盘口
+func (se *SimExchange) match(m *simMarket, o *simOrder) {
+	opposite := &m.asks
+	crosses := func(p decimal.Decimal) bool { return p.LessThanOrEqual(o.Price) }
+	if o.Side == client.SELL {
+		opposite = &m.bids
+		crosses = func(p decimal.Decimal) bool { return p.GreaterThanOrEqual(o.Price) }
+	}
+
+	for o.remaining().IsPositive() && len(*opposite) > 0 {
+		maker := (*opposite)[0]
+		if !crosses(maker.Price) {
+			break
+		}
+		qty := decimal.Min(o.remaining(), maker.remaining())
+		se.fill(m, maker, qty, maker.Price)
+		se.fill(m, o, qty, maker.Price)
+		if !maker.remaining().IsPositive() {
+			*opposite = (*opposite)[1:]
+		}
+	}
+
+	if o.remaining().IsPositive() {
+		if o.Side == client.BUY {
+			m.bids = insertOrder(m.bids, o, func(a, b *simOrder) bool { return a.Price.GreaterThan(b.Price) })
+		} else {
+			m.asks = insertOrder(m.asks, o, func(a, b *simOrder) bool { return a.Price.LessThan(b.Price) })
+		}
+	}
+	se.depthSeq++
+}
+
+//fill 记录一笔成交，手续费从收到的币种中扣除
+func (se *SimExchange) fill(m *simMarket, o *simOrder, qty, price decimal.Decimal) {
+	value := qty.Mul(price)
+	o.FilledAmount = o.FilledAmount.Add(qty)
+	o.ExecutedValue = o.ExecutedValue.Add(value)
+	if o.remaining().IsPositive() {
+		o.State = client.PARTIAL_FILLED
+	} else {
+		o.State = client.FILLED
+	}
+	if o.external {
+		return
+	}
+	fee := se.ledger.settle(m.base, m.quote, o.Side, o.Price, qty, price)
+	o.FillFees = o.FillFees.Add(fee)
+}
+
+//openOrders 我们自己还挂着的订单，按下单先后排列
+func openOrders(orders map[string]*simOrder, symbol string) []*Order {
+	open := make([]*simOrder, 0)
+	for _, o := range orders {
+		if o.Symbol == symbol && !o.external && o.isOpen() {
+			open = append(open, o)
+		}
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].seq < open[j].seq })
+	res := make([]*Order, 0, len(open))
+	for _, o := range open {
+		c := o.Order
+		res = append(res, &c)
+	}
+	return res
+}
+
+//insertOrder 插入到同价位已有订单之后，保证时间优先
+func insertOrder(orders []*simOrder, o *simOrder, better func(a, b *simOrder) bool) []*simOrder {
+	i := sort.Search(len(orders), func(i int) bool { return better(o, orders[i]) })
+	orders = append(orders, nil)
+	copy(orders[i+1:], orders[i:])
+	orders[i] = o
+	return orders
+}
+
+func removeOrder(orders []*simOrder, o *simOrder) []*simOrder {
+	for i, v := range orders {
+		if v == o {
+			return append(orders[:i], orders[i+1:]...)
+		}
+	}
+	return orders
+}
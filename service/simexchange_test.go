@@ -0,0 +1,132 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func dec(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+//newSimBook 构造一个eosusdt盘口，买盘5.00往下、卖盘5.01往上各20档，每档10个
+func newSimBook() *SimExchange {
+	se := NewSimExchange(dec("0.001"))
+	se.AddMarket("eosusdt", "eos", "usdt")
+	for i := 0; i < 20; i++ {
+		step := decimal.New(int64(i), -2)
+		se.SubmitExternal("eosusdt", client.BUY, dec("5").Sub(step), dec("10"))
+		se.SubmitExternal("eosusdt", client.SELL, dec("5.01").Add(step), dec("10"))
+	}
+	return se
+}
+
+func balanceOf(t *testing.T, se *SimExchange, currency string) *Balance {
+	bals, _ := se.GetBalances()
+	for _, b := range bals {
+		if b.Currency == currency {
+			return b
+		}
+	}
+	t.Fatalf("no balance for %s", currency)
+	return nil
+}
+
+func TestSimExchange_Depth(t *testing.T) {
+	se := newSimBook()
+	se.SubmitExternal("eosusdt", client.BUY, dec("5"), dec("2"))
+	depth, err := se.GetDepth("eosusdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(depth.Bids) != 20 || len(depth.Asks) != 20 {
+		t.Fatalf("bids:%d,asks:%d", len(depth.Bids), len(depth.Asks))
+	}
+	if !depth.Bids[0].Price.Equal(dec("5")) || !depth.Bids[0].Amount.Equal(dec("12")) {
+		t.Fatalf("best bid %v", depth.Bids[0])
+	}
+	if !depth.Asks[19].Price.Equal(dec("5.2")) {
+		t.Fatalf("worst ask %v", depth.Asks[19])
+	}
+}
+
+func TestSimExchange_PriceTimePriority(t *testing.T) {
+	se := NewSimExchange(decimal.Zero)
+	se.AddMarket("eosusdt", "eos", "usdt")
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("100"))
+
+	first, _ := se.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.SELL, Price: dec("5.1"), Amount: dec("1")})
+	second, _ := se.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.SELL, Price: dec("5.1"), Amount: dec("1")})
+	better, _ := se.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.SELL, Price: dec("5.05"), Amount: dec("1")})
+
+	se.SubmitExternal("eosusdt", client.BUY, dec("5.2"), dec("1.5"))
+
+	for id, want := range map[string]string{better.ID: client.FILLED, first.ID: client.PARTIAL_FILLED, second.ID: ORDER_STATE_SUBMITTED} {
+		o, err := se.GetOrder(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.State != want {
+			t.Errorf("order %s price %s state %s, want %s", id, o.Price, o.State, want)
+		}
+	}
+
+	//卖单按挂单价成交：5.05*1+5.1*0.5
+	if usdt := balanceOf(t, se, "usdt"); !usdt.Available.Equal(dec("107.6")) {
+		t.Fatalf("usdt available %s", usdt.Available)
+	}
+	if eos := balanceOf(t, se, "eos"); !eos.Available.Equal(dec("97")) || !eos.Frozen.Equal(dec("1.5")) {
+		t.Fatalf("eos %v", eos)
+	}
+}
+
+func TestSimExchange_BuyFillRefundsAndFees(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("60"))
+
+	//以5.05买10个，实际吃掉5.01档
+	res, _ := se.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.BUY, Price: dec("5.05"), Amount: dec("10")})
+	if res.Status != client.ORDER_STATES_SUCCESS {
+		t.Fatalf("status %d", res.Status)
+	}
+	o, _ := se.GetOrder(res.ID)
+	if o.State != client.FILLED || !o.ExecutedValue.Equal(dec("50.1")) || !o.FillFees.Equal(dec("0.01")) {
+		t.Fatalf("order %v", o)
+	}
+	if usdt := balanceOf(t, se, "usdt"); !usdt.Available.Equal(dec("9.9")) || !usdt.Frozen.IsZero() {
+		t.Fatalf("usdt %v", usdt)
+	}
+	if eos := balanceOf(t, se, "eos"); !eos.Available.Equal(dec("9.99")) {
+		t.Fatalf("eos %v", eos)
+	}
+}
+
+func TestSimExchange_InsufficientAndCancel(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("10"))
+
+	res, _ := se.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.BUY, Price: dec("4.9"), Amount: dec("3")})
+	if res.Status != client.ORDER_INSUFFICIENT {
+		t.Fatalf("want %d, got %d", client.ORDER_INSUFFICIENT, res.Status)
+	}
+
+	res, _ = se.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.BUY, Price: dec("4.9"), Amount: dec("2")})
+	if usdt := balanceOf(t, se, "usdt"); !usdt.Frozen.Equal(dec("9.8")) {
+		t.Fatalf("usdt %v", usdt)
+	}
+	cr, _ := se.CancelOrder(res.ID)
+	if cr.Status != client.ORDER_STATES_SUCCESS {
+		t.Fatalf("cancel status %d", cr.Status)
+	}
+	if usdt := balanceOf(t, se, "usdt"); !usdt.Available.Equal(dec("10")) || !usdt.Frozen.IsZero() {
+		t.Fatalf("usdt %v", usdt)
+	}
+
+	//已经撤掉或成交的订单再撤返回3008
+	cr, _ = se.CancelOrder(res.ID)
+	if cr.Status != client.CANCEL_SUCCESS_ORDER {
+		t.Fatalf("want %d, got %d", client.CANCEL_SUCCESS_ORDER, cr.Status)
+	}
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+//InventorySkew 按持仓偏离目标的程度调整报价：持仓在Target±Band内不调整，
+//超出后偏重的一侧(多了基础币就是买单)挂得更远、下单量按比例减少，另一侧挂得更近。
+//偏离达到2倍Band时调整到最大，偏重一侧停止挂单
+type InventorySkew struct {
+	Target    decimal.Decimal //目标基础币持仓
+	Band      decimal.Decimal
+	MaxLevels int //最多移动的档位数
+}
+
+//Adjust 根据当前持仓计算买卖两侧移动的档位(正数为远离盘口)和下单量比例
+func (s *InventorySkew) Adjust(inventory decimal.Decimal) (buyShift, sellShift int, buyScale, sellScale decimal.Decimal) {
+	one := decimal.New(1, 0)
+	buyScale, sellScale = one, one
+
+	drift := inventory.Sub(s.Target)
+	excess := drift.Abs().Sub(s.Band)
+	if !excess.IsPositive() || !s.Band.IsPositive() {
+		return
+	}
+	ratio := decimal.Min(excess.Div(s.Band), one)
+	shift := int(ratio.Mul(decimal.New(int64(s.MaxLevels), 0)).Round(0).IntPart())
+
+	if drift.IsPositive() {
+		return shift, -shift, one.Sub(ratio), one
+	}
+	return -shift, shift, one, one.Sub(ratio)
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestInventorySkew_Adjust(t *testing.T) {
+	s := &InventorySkew{Target: dec("10"), Band: dec("2"), MaxLevels: 4}
+
+	buyShift, sellShift, buyScale, _ := s.Adjust(dec("11"))
+	if buyShift != 0 || sellShift != 0 || !buyScale.Equal(dec("1")) {
+		t.Fatalf("within band,shift %d %d scale %s", buyShift, sellShift, buyScale)
+	}
+
+	//多了3个，超出band 1个，调整一半
+	buyShift, sellShift, buyScale, sellScale := s.Adjust(dec("13"))
+	if buyShift != 2 || sellShift != -2 || !buyScale.Equal(dec("0.5")) || !sellScale.Equal(dec("1")) {
+		t.Fatalf("long,shift %d %d scale %s %s", buyShift, sellShift, buyScale, sellScale)
+	}
+
+	//少了很多，卖单停止
+	buyShift, sellShift, _, sellScale = s.Adjust(dec("0"))
+	if sellShift != 4 || buyShift != -4 || !sellScale.IsZero() {
+		t.Fatalf("short,shift %d %d scale %s", buyShift, sellShift, sellScale)
+	}
+}
+
+func TestDigService_InventorySkew(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("20"))
+	ds := newTestDigService(se)
+	ds.SetInventorySkew(&InventorySkew{Target: dec("5"), Band: dec("5"), MaxLevels: 4})
+	ds.runner.runOnce()
+
+	//持仓20远超目标，买单停止，卖单挂到第1档
+	if len(ds.buyOrders) > 0 {
+		t.Fatal("heavy side should stop quoting")
+	}
+	sell, _ := se.GetOrder(ds.sellOrders[0].ID)
+	if !sell.Price.Equal(dec("5.01")) {
+		t.Fatalf("sell price %s", sell.Price)
+	}
+}
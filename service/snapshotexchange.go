@@ -0,0 +1,217 @@
+package service
+
+import (
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+	"sync"
+)
+
+//Fill 一笔成交
+type Fill struct {
+	OrderID string
+	Symbol  string
+	Side    string
+	Price   decimal.Decimal
+	Amount  decimal.Decimal
+	Fee     decimal.Decimal //买单收取基础币，卖单收取计价币
+	Ts      int64
+}
+
+//SnapshotExchange 根据深度快照判断成交的模拟交易所，不维护真实的盘口。
+//挂单之后，如果时间在下单之后的快照中对手价到了挂单价(买单卖一不高于挂单价，卖单买一不低于挂单价)，
+//就认为挂单按挂单价全部成交。只是改善了盘口的挂单没有人和它成交，不算成交
+type SnapshotExchange struct {
+	mu      sync.Mutex
+	markets map[string]*simMarket
+	depths  map[string]*Depth
+	ledger  *ledger
+	orders  map[string]*simOrder
+	fills   []*Fill
+	seq     int64
+}
+
+func NewSnapshotExchange(feeRate decimal.Decimal) *SnapshotExchange {
+	return &SnapshotExchange{
+		markets: make(map[string]*simMarket),
+		depths:  make(map[string]*Depth),
+		ledger:  newLedger(feeRate),
+		orders:  make(map[string]*simOrder),
+	}
+}
+
+//AddMarket 注册交易对，如btcusdt,btc,usdt
+func (se *SnapshotExchange) AddMarket(symbol, base, quote string) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	if _, ok := se.markets[symbol]; !ok {
+		se.markets[symbol] = &simMarket{base: base, quote: quote}
+	}
+}
+
+//SetBalance 设置某个币种的可用余额
+func (se *SnapshotExchange) SetBalance(currency string, available decimal.Decimal) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	se.ledger.balance(currency).Available = available
+}
+
+//Update 推送一个新的深度快照，用它撮合该交易对上的挂单，返回本次产生的成交
+func (se *SnapshotExchange) Update(depth *Depth) []*Fill {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	m, ok := se.markets[depth.Symbol]
+	if !ok {
+		return nil
+	}
+	se.depths[depth.Symbol] = depth
+
+	fills := make([]*Fill, 0)
+	m.bids = se.settle(m, m.bids, depth, &fills)
+	m.asks = se.settle(m, m.asks, depth, &fills)
+	return fills
+}
+
+//Fills 到目前为止所有的成交
+func (se *SnapshotExchange) Fills() []*Fill {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	fills := make([]*Fill, len(se.fills))
+	copy(fills, se.fills)
+	return fills
+}
+
+func (se *SnapshotExchange) settle(m *simMarket, orders []*simOrder, depth *Depth, fills *[]*Fill) []*simOrder {
+	rest := orders[:0]
+	for _, o := range orders {
+		//下单时的快照已经判断过，只用之后的快照撮合
+		if depth.Ts <= o.CreatedAt || !crossed(o.Side, o.Price, depth) {
+			rest = append(rest, o)
+			continue
+		}
+		*fills = append(*fills, se.fill(m, o, depth.Ts))
+	}
+	return rest
+}
+
+//crossed 判断快照的对手价是否到了挂单价，只有对手盘能让挂单成交
+func crossed(side string, price decimal.Decimal, depth *Depth) bool {
+	if side == client.BUY {
+		return len(depth.Asks) > 0 && depth.Asks[0].Price.LessThanOrEqual(price)
+	}
+	return len(depth.Bids) > 0 && depth.Bids[0].Price.GreaterThanOrEqual(price)
+}
+
+func (se *SnapshotExchange) fill(m *simMarket, o *simOrder, ts int64) *Fill {
+	qty := o.remaining()
+	fee := se.ledger.settle(m.base, m.quote, o.Side, o.Price, qty, o.Price)
+	o.FilledAmount = o.Amount
+	o.ExecutedValue = o.ExecutedValue.Add(qty.Mul(o.Price))
+	o.FillFees = o.FillFees.Add(fee)
+	o.State = client.FILLED
+	f := &Fill{OrderID: o.ID, Symbol: o.Symbol, Side: o.Side, Price: o.Price, Amount: qty, Fee: fee, Ts: ts}
+	se.fills = append(se.fills, f)
+	return f
+}
+
+func (se *SnapshotExchange) GetDepth(symbol string) (*Depth, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	d, ok := se.depths[symbol]
+	if !ok {
+		return nil, fmt.Errorf("%s,no depth snapshot yet", symbol)
+	}
+	return d, nil
+}
+
+func (se *SnapshotExchange) GetBalances() ([]*Balance, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.ledger.snapshot(), nil
+}
+
+func (se *SnapshotExchange) CreateOrder(req *OrderRequest) (*OrderResult, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	m, ok := se.markets[req.Symbol]
+	if !ok {
+		return nil, fmt.Errorf("unknown symbol %s", req.Symbol)
+	}
+	if !req.Price.IsPositive() || !req.Amount.IsPositive() {
+		return nil, fmt.Errorf("invalid order,price:%s,amount:%s", req.Price, req.Amount)
+	}
+	if req.Side != client.BUY && req.Side != client.SELL {
+		return nil, fmt.Errorf("invalid side %s", req.Side)
+	}
+	if !se.ledger.freeze(m.base, m.quote, req.Side, req.Price, req.Amount) {
+		return &OrderResult{Status: client.ORDER_INSUFFICIENT, Msg: "insufficient balance"}, nil
+	}
+
+	se.seq++
+	o := &simOrder{
+		Order: Order{
+			ID:     fmt.Sprintf("snap%d", se.seq),
+			Symbol: req.Symbol,
+			Side:   req.Side,
+			Type:   client.ORDER_TYPE_LIMIT,
+			State:  ORDER_STATE_SUBMITTED,
+			Price:  req.Price,
+			Amount: req.Amount,
+		},
+		seq: se.seq,
+	}
+	se.orders[o.ID] = o
+
+	//下单时已经能和当前快照的对手盘成交的，直接按挂单价成交
+	if d, ok := se.depths[req.Symbol]; ok {
+		o.CreatedAt = d.Ts
+		if crossed(o.Side, o.Price, d) {
+			se.fill(m, o, d.Ts)
+			return &OrderResult{ID: o.ID, Status: client.ORDER_STATES_SUCCESS}, nil
+		}
+	}
+	if o.Side == client.BUY {
+		m.bids = append(m.bids, o)
+	} else {
+		m.asks = append(m.asks, o)
+	}
+	return &OrderResult{ID: o.ID, Status: client.ORDER_STATES_SUCCESS}, nil
+}
+
+func (se *SnapshotExchange) CancelOrder(id string) (*CancelResult, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	o, ok := se.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("order %s not found", id)
+	}
+	if !o.isOpen() {
+		return &CancelResult{Status: client.CANCEL_SUCCESS_ORDER}, nil
+	}
+	m := se.markets[o.Symbol]
+	if o.Side == client.BUY {
+		m.bids = removeOrder(m.bids, o)
+	} else {
+		m.asks = removeOrder(m.asks, o)
+	}
+	se.ledger.unfreeze(m.base, m.quote, o.Side, o.Price, o.remaining())
+	o.State = client.ORDER_STATE_CANCEL
+	return &CancelResult{Status: client.ORDER_STATES_SUCCESS}, nil
+}
+
+func (se *SnapshotExchange) GetOrder(id string) (*Order, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	o, ok := se.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("order %s not found", id)
+	}
+	c := o.Order
+	return &c, nil
+}
+
+func (se *SnapshotExchange) GetOpenOrders(symbol string) ([]*Order, error) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return openOrders(se.orders, symbol), nil
+}
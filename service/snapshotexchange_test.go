@@ -0,0 +1,58 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"testing"
+)
+
+func snapshot(ts int64, bid, ask string) *Depth {
+	return &Depth{
+		Symbol: "eosusdt",
+		Bids:   []Level{{Price: dec(bid), Amount: dec("10")}},
+		Asks:   []Level{{Price: dec(ask), Amount: dec("10")}},
+		Ts:     ts,
+	}
+}
+
+func TestSnapshotExchange_Fills(t *testing.T) {
+	se := NewSnapshotExchange(dec("0.001"))
+	se.AddMarket("eosusdt", "eos", "usdt")
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("10"))
+	se.Update(snapshot(1000, "5", "5.01"))
+
+	//挂在买一和卖一之间只是改善了盘口，下单时和之后的快照都不成交
+	buy, _ := se.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.BUY, Price: dec("5.005"), Amount: dec("1")})
+	sell, _ := se.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.SELL, Price: dec("5.008"), Amount: dec("1")})
+	if fills := se.Update(snapshot(2000, "5.002", "5.01")); len(fills) != 0 {
+		t.Fatalf("orders improving the book should not fill,%v", fills)
+	}
+	for _, id := range []string{buy.ID, sell.ID} {
+		if o, _ := se.GetOrder(id); o.State != ORDER_STATE_SUBMITTED {
+			t.Fatalf("order %s state %s", id, o.State)
+		}
+	}
+
+	//对手价到了挂单价才成交
+	if fills := se.Update(snapshot(3000, "5.008", "5.01")); len(fills) != 1 || fills[0].OrderID != sell.ID {
+		t.Fatalf("sell should fill when the bid reaches it,%v", fills)
+	}
+	if fills := se.Update(snapshot(4000, "5", "5.005")); len(fills) != 1 || fills[0].OrderID != buy.ID {
+		t.Fatalf("buy should fill when the ask reaches it,%v", fills)
+	}
+
+	//能和当前卖一成交的买单下单时直接成交
+	res, _ := se.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.BUY, Price: dec("5.005"), Amount: dec("1")})
+	if o, _ := se.GetOrder(res.ID); o.State != client.FILLED {
+		t.Fatalf("marketable order state %s", o.State)
+	}
+
+	//时间不晚于下单时的快照不用来撮合
+	res, _ = se.CreateOrder(&OrderRequest{Symbol: "eosusdt", Side: client.SELL, Price: dec("5.01"), Amount: dec("1")})
+	if fills := se.Update(snapshot(4000, "5.01", "5.02")); len(fills) != 0 {
+		t.Fatalf("snapshot taken before the order should not fill it,%v", fills)
+	}
+	if fills := se.Update(snapshot(5000, "5.01", "5.02")); len(fills) != 1 || fills[0].OrderID != res.ID {
+		t.Fatalf("later snapshot should fill the sell,%v", fills)
+	}
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"time"
+)
+
+const VOL_REFRESH = time.Minute
+
+//Candle 一根k线
+type Candle struct {
+	Ts    int64
+	Open  float64
+	Close float64
+	High  float64
+	Low   float64
+}
+
+//CandleSource 能提供k线的交易所，按中间价报价时用来计算波动率
+type CandleSource interface {
+	//GetCandles 获取最近limit根k线，resolution如M1、M5
+	GetCandles(symbol, resolution string, limit int) ([]*Candle, error)
+}
+
+//SpreadQuoting 按中间价报价：买卖价分别为mid*(1∓spread)，spread = Bps + VolMultiplier*波动率，
+//波动率是最近VolWindow根k线收盘价对数收益率的标准差(以bps计)，VolResolution为空时不按波动率调整
+type SpreadQuoting struct {
+	Bps           decimal.Decimal //每侧离中间价的基点
+	VolResolution string
+	VolWindow     int
+	VolMultiplier decimal.Decimal
+	MaxBps        decimal.Decimal //价差上限，0为不限制
+}
+
+//SetSpreadQuoting 改为按中间价报价，nil为按档位报价
+func (ds *DigService) SetSpreadQuoting(sq *SpreadQuoting) {
+	ds.spread = sq
+}
+
+//spreadBps 当前每侧的价差，波动率每VOL_REFRESH更新一次
+func (ds *DigService) spreadBps() decimal.Decimal {
+	sq := ds.spread
+	bps := sq.Bps
+	if sq.VolResolution != "" {
+		if time.Since(ds.volUpdated) >= VOL_REFRESH {
+			vol, err := ds.volatility()
+			if err != nil {
+				log.Errorf("%s,update volatility failed,%v", ds.symbol, err)
+			} else {
+				ds.volBps = vol
+				ds.volUpdated = time.Now()
+				log.Debugf("%s,volatility %s bps", ds.symbol, vol.StringFixed(2))
+			}
+		}
+		bps = bps.Add(sq.VolMultiplier.Mul(ds.volBps))
+	}
+	if sq.MaxBps.IsPositive() && bps.GreaterThan(sq.MaxBps) {
+		bps = sq.MaxBps
+	}
+	return bps
+}
+
+func (ds *DigService) volatility() (decimal.Decimal, error) {
+	cs, ok := ds.ex.(CandleSource)
+	if !ok {
+		return decimal.Zero, fmt.Errorf("exchange does not provide candles")
+	}
+	candles, err := cs.GetCandles(ds.symbol, ds.spread.VolResolution, ds.spread.VolWindow+1)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return decimal.NewFromFloat(Volatility(candles) * 10000), nil
+}
+
+//Volatility 收盘价对数收益率的标准差
+func Volatility(candles []*Candle) float64 {
+	returns := make([]float64, 0, len(candles))
+	for i := 1; i < len(candles); i++ {
+		if candles[i-1].Close <= 0 || candles[i].Close <= 0 {
+			continue
+		}
+		returns = append(returns, math.Log(candles[i].Close/candles[i-1].Close))
+	}
+	if len(returns) < 2 {
+		return 0
+	}
+	var sum float64
+	for _, r := range returns {
+		sum += r
+	}
+	mean := sum / float64(len(returns))
+	var variance float64
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	return math.Sqrt(variance / float64(len(returns)-1))
+}
+
+//spreadPrices 按中间价计算买卖价，按pricePrecison取整并保证不会和对手盘成交。
+//持仓调整移动的档位在这里换算成价差的比例：每移动一档价差变化1/(2*MaxLevels)
+func (ds *DigService) spreadPrices(depth *Depth, buyShift, sellShift int) (decimal.Decimal, decimal.Decimal) {
+	mid := midPrice(depth)
+	bps := ds.spreadBps()
+	bidBps := bps.Mul(ds.skewFactor(buyShift))
+	askBps := bps.Mul(ds.skewFactor(sellShift))
+
+	tenThousand := decimal.New(10000, 0)
+	one := decimal.New(1, 0)
+	tick := decimal.New(1, -ds.pricepPrecision)
+
+	bid := ds.roundPrice(client.BUY, mid.Mul(one.Sub(bidBps.Div(tenThousand))))
+	ask := ds.roundPrice(client.SELL, mid.Mul(one.Add(askBps.Div(tenThousand))))
+	if limit := depth.Asks[0].Price.Sub(tick); bid.GreaterThan(limit) {
+		bid = limit
+	}
+	if limit := depth.Bids[0].Price.Add(tick); ask.LessThan(limit) {
+		ask = limit
+	}
+	return bid, ask
+}
+
+func (ds *DigService) skewFactor(shift int) decimal.Decimal {
+	if shift == 0 || ds.skew == nil || ds.skew.MaxLevels <= 0 {
+		return decimal.New(1, 0)
+	}
+	return decimal.New(1, 0).Add(decimal.New(int64(shift), 0).Div(decimal.New(int64(2*ds.skew.MaxLevels), 0)))
+}
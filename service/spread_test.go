@@ -0,0 +1,103 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+	"math"
+	"testing"
+	"time"
+)
+
+type candleSim struct {
+	*SimExchange
+	closes []float64
+}
+
+func (cs *candleSim) GetCandles(symbol, resolution string, limit int) ([]*Candle, error) {
+	candles := make([]*Candle, 0, len(cs.closes))
+	for _, c := range cs.closes {
+		candles = append(candles, &Candle{Close: c})
+	}
+	return candles, nil
+}
+
+func TestVolatility(t *testing.T) {
+	candles := []*Candle{{Close: 100}, {Close: 101}, {Close: 100}, {Close: 101}}
+	r := math.Log(1.01)
+	//收益率r,-r,r，均值r/3
+	mean := r / 3
+	want := math.Sqrt((2*(r-mean)*(r-mean) + (-r-mean)*(-r-mean)) / 2)
+	if v := Volatility(candles); math.Abs(v-want) > 1e-12 {
+		t.Fatalf("volatility %v,want %v", v, want)
+	}
+	if Volatility(candles[:2]) != 0 {
+		t.Fatal("need at least two returns")
+	}
+}
+
+func TestDigService_SpreadQuoting(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("4"))
+	ds := newTestDigService(se)
+	//中间价5.005，每侧20bps
+	ds.SetSpreadQuoting(&SpreadQuoting{Bps: dec("20")})
+	ds.runner.runOnce()
+
+	buy, _ := se.GetOrder(ds.buyOrders[0].ID)
+	sell, _ := se.GetOrder(ds.sellOrders[0].ID)
+	//5.005*0.998=4.99499向下取3位，5.005*1.002=5.01501向上取3位
+	if !buy.Price.Equal(dec("4.994")) || !sell.Price.Equal(dec("5.016")) {
+		t.Fatalf("buy %s sell %s", buy.Price, sell.Price)
+	}
+
+	//价差很小时不能吃掉对手盘
+	ds.SetSpreadQuoting(&SpreadQuoting{Bps: dec("1")})
+	ds.runner.runOnce()
+	buy, _ = se.GetOrder(ds.buyOrders[0].ID)
+	sell, _ = se.GetOrder(ds.sellOrders[0].ID)
+	if !buy.Price.Equal(dec("5.004")) || !sell.Price.Equal(dec("5.006")) {
+		t.Fatalf("buy %s sell %s", buy.Price, sell.Price)
+	}
+}
+
+func TestDigService_LevelQuotingRounds(t *testing.T) {
+	se := NewSimExchange(decimal.Zero)
+	se.AddMarket("eosusdt", "eos", "usdt")
+	for i := 0; i < 20; i++ {
+		offset := decimal.New(int64(i), -2)
+		se.SubmitExternal("eosusdt", client.BUY, dec("4.9876").Sub(offset), dec("10"))
+		se.SubmitExternal("eosusdt", client.SELL, dec("5.0123").Add(offset), dec("10"))
+	}
+	se.SetBalance("usdt", dec("100"))
+	se.SetBalance("eos", dec("4"))
+	ds := newTestDigService(se)
+	ds.runner.runOnce()
+
+	//第5档4.9476和5.0523比pricePrecison多一位，和价差模式一样买价向下、卖价向上取3位
+	buy, _ := se.GetOrder(ds.buyOrders[0].ID)
+	sell, _ := se.GetOrder(ds.sellOrders[0].ID)
+	if !buy.Price.Equal(dec("4.947")) || !sell.Price.Equal(dec("5.053")) {
+		t.Fatalf("buy %s sell %s", buy.Price, sell.Price)
+	}
+}
+
+func TestDigService_SpreadWidensWithVolatility(t *testing.T) {
+	se := newSimBook()
+	ex := &candleSim{SimExchange: se, closes: []float64{100, 101, 100, 101, 100}}
+	ds := newTestDigService(ex)
+	ds.SetSpreadQuoting(&SpreadQuoting{Bps: dec("10"), VolResolution: "M1", VolWindow: 4, VolMultiplier: dec("1"), MaxBps: dec("50")})
+
+	bps := ds.spreadBps()
+	if !bps.Equal(dec("50")) {
+		t.Fatalf("spread should be capped,%s", bps)
+	}
+	if ds.volUpdated.IsZero() || time.Since(ds.volUpdated) > time.Second {
+		t.Fatal("volatility should be cached")
+	}
+
+	ds.spread.MaxBps = dec("0")
+	if bps = ds.spreadBps(); bps.LessThan(dec("100")) {
+		t.Fatalf("1%% moves should widen spread well past 100bps,%s", bps)
+	}
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"fmt"
+	"github.com/MrChang666/qt/config"
+	"time"
+)
+
+const (
+	STRATEGY_DIG  = "dig"
+	STRATEGY_GRID = "grid"
+	STRATEGY_TWAP = "twap"
+)
+
+//Strategy 交易策略，由Runner按周期驱动，下单撤单都通过Init时拿到的Runner
+type Strategy interface {
+	//Init 绑定到Runner上，NewRunner时调用
+	Init(r *Runner)
+	//OnStart 开始运行前调用一次，用来找回上次运行留下的订单
+	OnStart() error
+	//OnTimer 每个周期最先调用
+	OnTimer(now time.Time)
+	//OnDepth 每个周期拿到最新深度后调用，取不到深度时depth为nil，返回false时停止运行
+	OnDepth(depth *Depth) bool
+	//OnFill Runner跟踪的订单成交时调用，部分成交后撤销的也会调用
+	OnFill(o *Order)
+	//OnShutdown 停止运行前调用，需要撤单的策略在这里撤单
+	OnShutdown()
+}
+
+//Reconfigurable 可以在运行中修改参数的策略，Runner在下一个周期开始时调用Reconfigure。
+//没有实现的策略修改配置后由Manager重启
+type Reconfigurable interface {
+	Reconfigure(conf *config.SymbolConfig)
+}
+
+//StrategyFactory 根据qt.yml中一个交易对的配置创建策略
+type StrategyFactory func(conf *config.SymbolConfig, ex Exchange) (Strategy, error)
+
+var strategies = make(map[string]StrategyFactory)
+
+//RegisterStrategy 按名字注册策略，qt.yml中用strategy选择，重复注册时后注册的生效
+func RegisterStrategy(name string, f StrategyFactory) {
+	strategies[name] = f
+}
+
+//NewStrategy 按conf.Strategy创建策略
+func NewStrategy(conf *config.SymbolConfig, ex Exchange) (Strategy, error) {
+	f, ok := strategies[conf.Strategy]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %s", conf.Strategy)
+	}
+	return f(conf, ex)
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	WS_URL          = "wss://api.fcoin.com/v2/ws"
+	WS_DIAL_TIMEOUT = 10 * time.Second
+	WS_READ_TIMEOUT = 60 * time.Second //超过这个时间没有收到任何消息时认为连接已断开
+)
+
+//推送消息的type
+const (
+	STREAM_HELLO  = "hello"
+	STREAM_TOPICS = "topics"
+	STREAM_PING   = "ping"
+	STREAM_DEPTH  = "depth"
+	STREAM_TRADE  = "trade"
+)
+
+//Stream fcoin的websocket行情推送，一个连接可以订阅多个交易对。
+//Read只能在一个goroutine中调用，Subscribe、Ping和Close可以在其它goroutine中调用
+type Stream struct {
+	ws  *wsConn
+	ids int64
+}
+
+//StreamTrade 推送的一笔公开成交
+type StreamTrade struct {
+	Id     int64   `json:"id"`
+	Ts     int64   `json:"ts"`
+	Side   string  `json:"side"`
+	Price  float64 `json:"price"`
+	Amount float64 `json:"amount"`
+}
+
+//StreamMessage 一条推送，Kind为type的第一段，如depth.L20.btcusdt的Kind为depth、Symbol为btcusdt
+type StreamMessage struct {
+	Type   string
+	Kind   string
+	Symbol string
+	Depth  *client.Depth //Kind为depth时不为nil，内容和REST接口的data相同
+	Trade  *StreamTrade  //Kind为trade时不为nil
+	Topics []string      //Kind为topics时为订阅成功的主题
+	Raw    []byte
+}
+
+//StreamMessageError 推送的内容无法解析，连接仍然可用，跳过这一条继续读取
+type StreamMessageError struct {
+	Raw []byte
+	Err error
+}
+
+func (e *StreamMessageError) Error() string {
+	return fmt.Sprintf("invalid stream message %s,%v", e.Raw, e.Err)
+}
+
+func (e *StreamMessageError) Unwrap() error {
+	return e.Err
+}
+
+type streamCommand struct {
+	Cmd  string        `json:"cmd"`
+	Args []interface{} `json:"args"`
+	Id   string        `json:"id"`
+}
+
+//DepthTopic 深度的主题，level如L20
+func DepthTopic(symbol, level string) string {
+	return "depth." + level + "." + symbol
+}
+
+//TradeTopic 成交的主题
+func TradeTopic(symbol string) string {
+	return "trade." + symbol
+}
+
+//DialStream 连接行情推送，url为空时使用WS_URL
+func DialStream(url string) (*Stream, error) {
+	if url == "" {
+		url = WS_URL
+	}
+	ws, err := dialWs(url, WS_DIAL_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{ws: ws}, nil
+}
+
+func (s *Stream) send(cmd string, args ...interface{}) error {
+	id := strconv.FormatInt(atomic.AddInt64(&s.ids, 1), 10)
+	content, err := json.Marshal(&streamCommand{Cmd: cmd, Args: args, Id: id})
+	if err != nil {
+		return err
+	}
+	return s.ws.writeFrame(WS_OP_TEXT, content)
+}
+
+//Subscribe 订阅主题，结果在Read中以topics消息返回
+func (s *Stream) Subscribe(topics ...string) error {
+	args := make([]interface{}, len(topics))
+	for i, t := range topics {
+		args[i] = t
+	}
+	return s.send("sub", args...)
+}
+
+//Ping 应用层的心跳，服务端回复ping消息
+func (s *Stream) Ping() error {
+	return s.send("ping", time.Now().UnixNano()/int64(time.Millisecond))
+}
+
+//Read 读取下一条推送，WS_READ_TIMEOUT内没有收到消息时返回错误，内容无法解析时返回*StreamMessageError
+func (s *Stream) Read() (*StreamMessage, error) {
+	s.ws.SetReadDeadline(time.Now().Add(WS_READ_TIMEOUT))
+	content, err := s.ws.readMessage()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := ParseStreamMessage(content)
+	if err != nil {
+		return nil, &StreamMessageError{Raw: content, Err: err}
+	}
+	return msg, nil
+}
+
+//ParseStreamMessage 解析一条推送，不认识的type只填Type和Raw
+func ParseStreamMessage(content []byte) (*StreamMessage, error) {
+	head := struct {
+		Type   string   `json:"type"`
+		Topics []string `json:"topics"`
+	}{}
+	if err := json.Unmarshal(content, &head); err != nil {
+		return nil, err
+	}
+	msg := &StreamMessage{Type: head.Type, Raw: content}
+	parts := strings.Split(head.Type, ".")
+	msg.Kind, msg.Symbol = parts[0], parts[len(parts)-1]
+	if len(parts) == 1 {
+		msg.Symbol = ""
+	}
+	switch msg.Kind {
+	case STREAM_DEPTH:
+		msg.Depth = &client.Depth{}
+		if err := json.Unmarshal(content, &msg.Depth.Data); err != nil {
+			return nil, err
+		}
+	case STREAM_TRADE:
+		msg.Trade = &StreamTrade{}
+		if err := json.Unmarshal(content, msg.Trade); err != nil {
+			return nil, err
+		}
+	case STREAM_TOPICS:
+		msg.Topics = head.Topics
+	}
+	return msg, nil
+}
+
+//Close 关闭连接，阻塞中的Read会返回错误
+func (s *Stream) Close() error {
+	return s.ws.close()
+}
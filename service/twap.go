@@ -0,0 +1,370 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/MrChang666/fcoin-api-go/client"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+//子单的价格
+const (
+	TWAP_PASSIVE = "passive" //挂在己方最优价
+	TWAP_CROSS   = "cross"   //按对手方最优价吃单
+)
+
+//TwapService 把一个大单(父单)拆成子单执行。按时间把duration均分为slices段，
+//每段开始时把累计目标补足；设置了visible时为冰山单，盘口上始终只挂visible的数量，成交后再挂下一笔。
+//子单价格偏离最优价或进入下一段时撤单重挂，duration结束后剩余部分按对手价吃单。
+//父单全部成交后停止运行。设置了statePath时父单进度保存在文件中，重启后接着执行，已经完成的父单不再执行
+type TwapService struct {
+	symbol         string
+	side           string
+	amount         decimal.Decimal
+	duration       time.Duration
+	slices         int
+	visible        decimal.Decimal
+	priceStyle     string
+	assetPrecision int32
+	runner         *Runner
+	now            time.Time
+	start          time.Time
+	arrivalMid     decimal.Decimal
+	child          *twapChild
+	settled        map[string]bool
+	filled         decimal.Decimal
+	value          decimal.Decimal
+	fees           decimal.Decimal
+	children       int
+	statePath      string
+	done           bool
+}
+
+type twapChild struct {
+	id    string
+	price decimal.Decimal
+	slice int
+}
+
+//twapState 父单的持久化进度，父单参数变化后旧进度作废
+type twapState struct {
+	Symbol     string          `json:"symbol"`
+	Side       string          `json:"side"`
+	Amount     decimal.Decimal `json:"amount"`
+	Start      time.Time       `json:"start"`
+	ArrivalMid decimal.Decimal `json:"arrivalMid"`
+	Filled     decimal.Decimal `json:"filled"`
+	Value      decimal.Decimal `json:"value"`
+	Fees       decimal.Decimal `json:"fees"`
+	Children   int             `json:"children"`
+	Settled    []string        `json:"settled"`
+	Child      *twapChildState `json:"child,omitempty"`
+	Done       bool            `json:"done"`
+}
+
+type twapChildState struct {
+	ID    string          `json:"id"`
+	Price decimal.Decimal `json:"price"`
+	Slice int             `json:"slice"`
+}
+
+//ExecutionReport 父单的执行情况，滑点以开始执行时的中间价为基准，正数表示比中间价差
+type ExecutionReport struct {
+	Symbol      string
+	Side        string
+	Target      decimal.Decimal
+	Filled      decimal.Decimal
+	AvgPrice    decimal.Decimal
+	ArrivalMid  decimal.Decimal
+	SlippageBps decimal.Decimal
+	Fees        decimal.Decimal
+	Children    int
+}
+
+func (er ExecutionReport) String() string {
+	return fmt.Sprintf("symbol:%s,side:%s,filled:%s/%s,avg price:%s,arrival mid:%s,slippage:%sbps,fees:%s,children:%d",
+		er.Symbol, er.Side, er.Filled, er.Target, er.AvgPrice.StringFixed(8), er.ArrivalMid,
+		er.SlippageBps.StringFixed(2), er.Fees, er.Children)
+}
+
+func NewTwapService(symbol, side string, amount decimal.Decimal, duration time.Duration, slices int, assetPrecision int32) *TwapService {
+	if slices < 1 {
+		slices = 1
+	}
+	return &TwapService{
+		symbol:         symbol,
+		side:           side,
+		amount:         amount,
+		duration:       duration,
+		slices:         slices,
+		priceStyle:     TWAP_PASSIVE,
+		assetPrecision: assetPrecision,
+		settled:        make(map[string]bool),
+	}
+}
+
+//SetIceberg 盘口上最多只挂visible的数量，0为关闭
+func (ts *TwapService) SetIceberg(visible decimal.Decimal) {
+	ts.visible = visible
+}
+
+//SetPriceStyle 子单价格，TWAP_PASSIVE或TWAP_CROSS
+func (ts *TwapService) SetPriceStyle(style string) {
+	ts.priceStyle = style
+}
+
+//SetStatePath 保存父单进度的文件，为空时不保存
+func (ts *TwapService) SetStatePath(path string) {
+	ts.statePath = path
+}
+
+//Init 绑定Runner，实现Strategy
+func (ts *TwapService) Init(r *Runner) {
+	ts.runner = r
+}
+
+//OnStart 读取上次运行保存的进度，接着跟踪没有结束的子单；父单参数和配置不一致时撤掉旧的子单，
+//按新的父单开始
+func (ts *TwapService) OnStart() error {
+	if ts.statePath == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(ts.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	st := &twapState{}
+	if err = json.Unmarshal(b, st); err != nil {
+		return fmt.Errorf("parse twap state %s failed,%v", ts.statePath, err)
+	}
+	if st.Symbol != ts.symbol || st.Side != ts.side || !st.Amount.Equal(ts.amount) {
+		if c := st.Child; c != nil {
+			log.Infof("%s,parent order changed,cancel child order %s of the old one", ts.symbol, c.ID)
+			ts.runner.Track(c.ID, st.Side)
+			ts.runner.Cancel(c.ID)
+		}
+		ts.save()
+		return nil
+	}
+	ts.start, ts.arrivalMid = st.Start, st.ArrivalMid
+	ts.filled, ts.value, ts.fees = st.Filled, st.Value, st.Fees
+	ts.children, ts.done = st.Children, st.Done
+	for _, id := range st.Settled {
+		ts.settled[id] = true
+	}
+	if c := st.Child; c != nil {
+		ts.child = &twapChild{id: c.ID, price: c.Price, slice: c.Slice}
+		ts.runner.Track(c.ID, ts.side)
+	}
+	log.Infof("%s,resume %s %s,filled %s,done:%v", ts.symbol, ts.side, ts.amount, ts.filled, ts.done)
+	return nil
+}
+
+func (ts *TwapService) save() {
+	if ts.statePath == "" {
+		return
+	}
+	st := &twapState{
+		Symbol: ts.symbol, Side: ts.side, Amount: ts.amount, Start: ts.start, ArrivalMid: ts.arrivalMid,
+		Filled: ts.filled, Value: ts.value, Fees: ts.fees, Children: ts.children, Done: ts.done,
+		Settled: make([]string, 0, len(ts.settled)),
+	}
+	for id := range ts.settled {
+		st.Settled = append(st.Settled, id)
+	}
+	sort.Strings(st.Settled)
+	if ts.child != nil {
+		st.Child = &twapChildState{ID: ts.child.id, Price: ts.child.price, Slice: ts.child.slice}
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(ts.statePath), 0755)
+	}
+	//先写临时文件再改名，避免写到一半退出
+	tmp := ts.statePath + ".tmp"
+	if err == nil {
+		err = ioutil.WriteFile(tmp, b, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, ts.statePath)
+	}
+	if err != nil {
+		log.Errorf("%s,save twap state failed,%v", ts.symbol, err)
+	}
+}
+
+func (ts *TwapService) OnTimer(now time.Time) {
+	ts.now = now
+}
+
+//OnDepth 检查当前子单，需要时撤单重挂；没有子单时按进度挂下一笔，父单完成后返回false
+func (ts *TwapService) OnDepth(depth *Depth) bool {
+	defer handlePanic()
+	if ts.done {
+		log.Infof("%s,parent order was already executed,%v", ts.symbol, ts.Report())
+		return false
+	}
+	if depth == nil || len(depth.Bids) == 0 || len(depth.Asks) == 0 {
+		return true
+	}
+	if ts.start.IsZero() {
+		ts.start = ts.now
+		ts.arrivalMid = midPrice(depth)
+		log.Infof("%s,start %s %s,arrival mid:%s", ts.symbol, ts.side, ts.amount, ts.arrivalMid)
+		ts.save()
+	}
+
+	if ts.child != nil {
+		o, err := ts.runner.Refresh(ts.child.id)
+		if err != nil {
+			log.Errorf("%s,get child order %s failed,%v", ts.symbol, ts.child.id, err)
+			return true
+		}
+		if o.State == ORDER_STATE_SUBMITTED || o.State == client.PARTIAL_FILLED {
+			if ts.child.price.Equal(ts.price(depth)) && (ts.visible.IsPositive() || ts.child.slice == ts.slice()) {
+				return true
+			}
+			if !ts.cancelChild() {
+				return true
+			}
+		} else {
+			ts.settle(o)
+			ts.child = nil
+			ts.save()
+		}
+	}
+
+	remaining := ts.floor(ts.amount.Sub(ts.filled))
+	if !remaining.IsPositive() {
+		ts.done = true
+		ts.save()
+		log.Infof("%s,execution done,%v", ts.symbol, ts.Report())
+		return false
+	}
+	qty := ts.nextQty(remaining)
+	if !qty.IsPositive() {
+		return true
+	}
+	price := ts.price(depth)
+	res, err := ts.runner.Place(ts.side, price, qty)
+	if err != nil {
+		log.Errorf("%s,create child order failed,%v", ts.symbol, err)
+		return true
+	}
+	ts.child = &twapChild{id: res.ID, price: price, slice: ts.slice()}
+	ts.children++
+	ts.save()
+	log.Debugf("%s,child %s order %s,price:%s,amount:%s", ts.symbol, ts.side, res.ID, price, qty)
+	return true
+}
+
+//OnFill 子单成交
+func (ts *TwapService) OnFill(o *Order) {
+	ts.settle(o)
+	if ts.child != nil && ts.child.id == o.ID {
+		ts.child = nil
+	}
+	ts.save()
+}
+
+//OnShutdown 撤掉还挂着的子单，保存进度
+func (ts *TwapService) OnShutdown() {
+	if ts.child != nil {
+		ts.cancelChild()
+	}
+	ts.save()
+	log.Infof("%s,execution stopped,%v", ts.symbol, ts.Report())
+}
+
+//Report 当前的执行情况
+func (ts *TwapService) Report() ExecutionReport {
+	er := ExecutionReport{
+		Symbol:      ts.symbol,
+		Side:        ts.side,
+		Target:      ts.amount,
+		Filled:      ts.filled,
+		AvgPrice:    decimal.Zero,
+		ArrivalMid:  ts.arrivalMid,
+		SlippageBps: decimal.Zero,
+		Fees:        ts.fees,
+		Children:    ts.children,
+	}
+	if ts.filled.IsPositive() {
+		er.AvgPrice = ts.value.Div(ts.filled)
+	}
+	if er.AvgPrice.IsPositive() && ts.arrivalMid.IsPositive() {
+		diff := er.AvgPrice.Sub(ts.arrivalMid)
+		if ts.side == client.SELL {
+			diff = diff.Neg()
+		}
+		er.SlippageBps = diff.Div(ts.arrivalMid).Mul(decimal.New(10000, 0))
+	}
+	return er
+}
+
+//cancelChild 撤掉子单，撤单前成交的部分由OnFill累计。撤单还没有完成时保留子单，下一个周期再查询
+func (ts *TwapService) cancelChild() bool {
+	if !ts.runner.Cancel(ts.child.id) {
+		return false
+	}
+	ts.child = nil
+	return true
+}
+
+//settle 累计一个已经结束的子单的成交，同一个子单只计一次
+func (ts *TwapService) settle(o *Order) {
+	if ts.settled[o.ID] {
+		return
+	}
+	ts.settled[o.ID] = true
+	if !o.FilledAmount.IsPositive() {
+		return
+	}
+	ts.filled = ts.filled.Add(o.FilledAmount)
+	ts.value = ts.value.Add(o.ExecutedValue)
+	ts.fees = ts.fees.Add(o.FillFees)
+	log.Infof("%s,child %s order %s filled %s at %s,progress %s/%s", ts.symbol, o.Side, o.ID, o.FilledAmount, o.Price, ts.filled, ts.amount)
+}
+
+//slice 当前处于第几段，从1开始，duration结束后为slices
+func (ts *TwapService) slice() int {
+	if ts.duration <= 0 {
+		return ts.slices
+	}
+	i := int(int64(ts.now.Sub(ts.start))*int64(ts.slices)/int64(ts.duration)) + 1
+	if i > ts.slices {
+		return ts.slices
+	}
+	return i
+}
+
+//nextQty 下一笔子单的数量：冰山单为visible，否则补足到当前段的累计目标
+func (ts *TwapService) nextQty(remaining decimal.Decimal) decimal.Decimal {
+	if ts.visible.IsPositive() {
+		return ts.floor(decimal.Min(ts.visible, remaining))
+	}
+	target := ts.amount.Mul(decimal.New(int64(ts.slice()), 0)).Div(decimal.New(int64(ts.slices), 0))
+	return ts.floor(decimal.Min(target.Sub(ts.filled), remaining))
+}
+
+func (ts *TwapService) price(depth *Depth) decimal.Decimal {
+	cross := ts.priceStyle == TWAP_CROSS || (ts.duration > 0 && ts.now.Sub(ts.start) >= ts.duration)
+	if (ts.side == client.BUY) == cross {
+		return depth.Asks[0].Price
+	}
+	return depth.Bids[0].Price
+}
+
+func (ts *TwapService) floor(d decimal.Decimal) decimal.Decimal {
+	p := decimal.New(1, ts.assetPrecision)
+	return d.Mul(p).Floor().Div(p)
+}
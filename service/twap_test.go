@@ -0,0 +1,188 @@
+package service
+
+import (
+	"github.com/MrChang666/fcoin-api-go/client"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestTwap(se *SimExchange, side string, amount string, duration time.Duration, slices int) (*TwapService, *Runner, *time.Time) {
+	ts := NewTwapService("eosusdt", side, dec(amount), duration, slices, 4)
+	r := NewRunner("eosusdt", se, ts, 1)
+	clock := time.Unix(1561000000, 0)
+	r.now = func() time.Time { return clock }
+	return ts, r, &clock
+}
+
+func TestTwapService_Slices(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	ts, r, clock := newTestTwap(se, client.BUY, "6", 3*time.Second, 3)
+	ts.SetPriceStyle(TWAP_CROSS)
+
+	for i := 0; i < 3; i++ {
+		if !r.runOnce() {
+			t.Fatalf("slice %d,execution should not be done", i+1)
+		}
+		//同一段内不会多下单
+		r.runOnce()
+		if ts.children != i+1 {
+			t.Fatalf("slice %d,%d children", i+1, ts.children)
+		}
+		*clock = clock.Add(time.Second)
+	}
+	if r.runOnce() {
+		t.Fatal("execution should be done")
+	}
+	er := ts.Report()
+	if !er.Filled.Equal(dec("6")) || !er.AvgPrice.Equal(dec("5.01")) || !er.ArrivalMid.Equal(dec("5.005")) {
+		t.Fatalf("report %v", er)
+	}
+	//5.01比5.005高约10bps
+	if er.SlippageBps.StringFixed(2) != "9.99" {
+		t.Fatalf("slippage %s", er.SlippageBps)
+	}
+}
+
+func TestTwapService_Iceberg(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("eos", dec("7"))
+	ts, r, _ := newTestTwap(se, client.SELL, "7", 0, 1)
+	ts.SetIceberg(dec("3"))
+
+	for _, p := range []string{"5.01", "5.02", "5.03"} {
+		r.runOnce()
+		open, _ := se.GetOpenOrders("eosusdt")
+		if len(open) != 1 || open[0].Amount.GreaterThan(dec("3")) || !open[0].Price.Equal(dec(p)) {
+			t.Fatalf("only the visible size should be shown at %s,%v", p, open)
+		}
+		//外部买单吃掉这一档
+		se.SubmitExternal("eosusdt", client.BUY, dec(p), dec("13"))
+	}
+	if r.runOnce() {
+		t.Fatal("execution should be done")
+	}
+	er := ts.Report()
+	if !er.Filled.Equal(dec("7")) || er.Children != 3 || er.AvgPrice.StringFixed(4) != "5.0171" || !er.SlippageBps.IsNegative() {
+		t.Fatalf("report %v", er)
+	}
+}
+
+func TestTwapService_RepriceAndShutdown(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	ts, r, _ := newTestTwap(se, client.BUY, "4", time.Hour, 4)
+
+	r.runOnce()
+	first := ts.child.id
+	//有人抬高了买一价，子单撤掉跟上
+	se.SubmitExternal("eosusdt", client.BUY, dec("5.005"), dec("1"))
+	r.runOnce()
+	if o, _ := se.GetOrder(first); o.State != client.ORDER_STATE_CANCEL {
+		t.Fatalf("stale child state %s", o.State)
+	}
+	if o, _ := se.GetOrder(ts.child.id); !o.Price.Equal(dec("5.005")) || !o.Amount.Equal(dec("1")) {
+		t.Fatalf("new child %v", o)
+	}
+
+	ts.OnShutdown()
+	if open, _ := se.GetOpenOrders("eosusdt"); len(open) != 0 || ts.child != nil {
+		t.Fatal("child should be cancelled on shutdown")
+	}
+}
+
+func TestTwapService_Resume(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "qt")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "twap-eosusdt.json")
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	start := func() (*TwapService, *Runner, *time.Time) {
+		ts, r, clock := newTestTwap(se, client.BUY, "6", 3*time.Second, 3)
+		ts.SetPriceStyle(TWAP_CROSS)
+		ts.SetStatePath(path)
+		if err := r.Start(); err != nil {
+			t.Fatal(err)
+		}
+		return ts, r, clock
+	}
+
+	//第二段的子单已经成交但还没有查询到时退出，没有调用OnShutdown
+	ts, r, clock := start()
+	r.runOnce()
+	r.runOnce()
+	*clock = clock.Add(time.Second)
+	r.runOnce()
+	child := ts.child.id
+
+	ts, r, clock = start()
+	if !ts.filled.Equal(dec("2")) || ts.child == nil || ts.child.id != child || !r.Tracked(child) {
+		t.Fatalf("progress should be restored,filled %s,child %v", ts.filled, ts.child)
+	}
+	*clock = clock.Add(time.Second)
+	r.runOnce()
+	if !ts.filled.Equal(dec("4")) || ts.children != 2 {
+		t.Fatalf("restored child should be settled without a new order,filled %s,children %d", ts.filled, ts.children)
+	}
+	*clock = clock.Add(time.Second)
+	r.runOnce()
+	if r.runOnce() {
+		t.Fatal("execution should be done")
+	}
+	if b := balanceOf(t, se, "eos"); !b.Available.Equal(dec("5.994")) {
+		t.Fatalf("bought %s", b.Available)
+	}
+
+	//已经完成的父单重启后不再执行
+	ts, r, _ = start()
+	if r.runOnce() || ts.children != 3 {
+		t.Fatal("finished parent order should not be executed again")
+	}
+
+	//父单参数变化后重新开始
+	ts = NewTwapService("eosusdt", client.BUY, dec("1"), 0, 1, 4)
+	ts.SetStatePath(path)
+	NewRunner("eosusdt", se, ts, 1).Start()
+	if ts.done || ts.filled.IsPositive() {
+		t.Fatal("changed parent order should start from zero")
+	}
+}
+
+//asyncCancelExchange 第一次撤单只返回成功，订单要到下一次撤单时才撤掉，像fcoin的异步撤单
+type asyncCancelExchange struct {
+	*SimExchange
+	submitted map[string]bool
+}
+
+func (ae *asyncCancelExchange) CancelOrder(id string) (*CancelResult, error) {
+	if !ae.submitted[id] {
+		ae.submitted[id] = true
+		return &CancelResult{Status: client.ORDER_STATES_SUCCESS}, nil
+	}
+	return ae.SimExchange.CancelOrder(id)
+}
+
+func TestTwapService_AsyncCancel(t *testing.T) {
+	se := newSimBook()
+	se.SetBalance("usdt", dec("100"))
+	ts := NewTwapService("eosusdt", client.BUY, dec("4"), 0, 1, 4)
+	r := NewRunner("eosusdt", &asyncCancelExchange{SimExchange: se, submitted: make(map[string]bool)}, ts, 1)
+	r.runOnce()
+	id := ts.child.id
+	//子单排在5.00原有的10个后面，成交1个
+	se.SubmitExternal("eosusdt", client.SELL, dec("5"), dec("11"))
+
+	//撤单还没有完成，子单还在
+	if ts.cancelChild() || ts.child == nil || !r.Tracked(id) {
+		t.Fatal("child should be kept until the cancel is done")
+	}
+	if !ts.cancelChild() || ts.child != nil || r.Tracked(id) {
+		t.Fatal("cancelled child should be settled")
+	}
+	if !ts.filled.Equal(dec("1")) {
+		t.Fatalf("filled %s", ts.filled)
+	}
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+//websocket的帧类型，见RFC 6455
+const (
+	WS_OP_CONTINUATION = 0x0
+	WS_OP_TEXT         = 0x1
+	WS_OP_BINARY       = 0x2
+	WS_OP_CLOSE        = 0x8
+	WS_OP_PING         = 0x9
+	WS_OP_PONG         = 0xa
+)
+
+const (
+	WS_MAX_MESSAGE    = 16 << 20 //单条消息的最大长度
+	WS_WRITE_TIMEOUT  = 10 * time.Second
+	wsAcceptMagicGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+//ErrWsClosed 对方发送了close帧
+var ErrWsClosed = errors.New("websocket closed")
+
+//wsConn 只实现了行情推送需要的websocket客户端：文本和二进制消息、分片、ping/pong和close，不支持压缩扩展
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	wmu  sync.Mutex
+}
+
+//dialWs 连接ws://或wss://地址并完成握手
+func dialWs(rawurl string, timeout time.Duration) (*wsConn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "ws":
+			host = net.JoinHostPort(u.Hostname(), "80")
+		case "wss":
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("unsupported websocket scheme %q", u.Scheme)
+	}
+
+	conn, err := net.DialTimeout("tcp", host, timeout)
+	if err != nil {
+		return nil, &NetworkError{Endpoint: "ws", Err: err}
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if u.Scheme == "wss" {
+		tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err = tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, &NetworkError{Endpoint: "ws", Err: err}
+		}
+		conn = tc
+	}
+
+	ws := &wsConn{conn: conn, br: bufio.NewReader(conn)}
+	if err = ws.handshake(u); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ws, nil
+}
+
+func (ws *wsConn) handshake(u *url.URL) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+		Host: u.Host,
+	}
+	if err := req.Write(ws.conn); err != nil {
+		return &NetworkError{Endpoint: "ws", Err: err}
+	}
+	resp, err := http.ReadResponse(ws.br, req)
+	if err != nil {
+		return &NetworkError{Endpoint: "ws", Err: err}
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return &HTTPError{Endpoint: "ws", StatusCode: resp.StatusCode}
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		return fmt.Errorf("ws,invalid Sec-WebSocket-Accept")
+	}
+	return nil
+}
+
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsAcceptMagicGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+//SetReadDeadline 之后的readMessage超过t还没有读到时返回错误
+func (ws *wsConn) SetReadDeadline(t time.Time) error {
+	return ws.conn.SetReadDeadline(t)
+}
+
+//readMessage 读取一条完整的消息，期间收到的ping自动回复pong，收到close时回复后返回ErrWsClosed
+func (ws *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case WS_OP_PING:
+			if err = ws.writeFrame(WS_OP_PONG, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case WS_OP_PONG:
+			continue
+		case WS_OP_CLOSE:
+			ws.writeFrame(WS_OP_CLOSE, payload)
+			if len(payload) >= 2 {
+				return nil, fmt.Errorf("%w,code %d", ErrWsClosed, binary.BigEndian.Uint16(payload))
+			}
+			return nil, ErrWsClosed
+		case WS_OP_TEXT, WS_OP_BINARY:
+			if started {
+				return nil, fmt.Errorf("ws,new message before the previous one finished")
+			}
+			started = true
+			msg = payload
+		case WS_OP_CONTINUATION:
+			if !started {
+				return nil, fmt.Errorf("ws,continuation without a message")
+			}
+			msg = append(msg, payload...)
+		default:
+			return nil, fmt.Errorf("ws,unknown opcode %d", op)
+		}
+		if len(msg) > WS_MAX_MESSAGE {
+			return nil, fmt.Errorf("ws,message larger than %d", WS_MAX_MESSAGE)
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (ws *wsConn) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(ws.br, head[:]); err != nil {
+		return false, 0, nil, &NetworkError{Endpoint: "ws", Err: err}
+	}
+	fin, op := head[0]&0x80 != 0, head[0]&0x0f
+	masked, n := head[1]&0x80 != 0, uint64(head[1]&0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return false, 0, nil, &NetworkError{Endpoint: "ws", Err: err}
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return false, 0, nil, &NetworkError{Endpoint: "ws", Err: err}
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > WS_MAX_MESSAGE {
+		return false, 0, nil, fmt.Errorf("ws,frame larger than %d", WS_MAX_MESSAGE)
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(ws.br, mask[:]); err != nil {
+			return false, 0, nil, &NetworkError{Endpoint: "ws", Err: err}
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return false, 0, nil, &NetworkError{Endpoint: "ws", Err: err}
+	}
+	if masked {
+		maskBytes(mask, payload)
+	}
+	return fin, op, payload, nil
+}
+
+//writeFrame 客户端发送的帧都需要掩码
+func (ws *wsConn) writeFrame(op byte, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|op)
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		frame = append(frame, 0x80|127)
+		frame = append(frame, ext[:]...)
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	start := len(frame)
+	frame = append(frame, payload...)
+	maskBytes(mask, frame[start:])
+
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	ws.conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+	if _, err := ws.conn.Write(frame); err != nil {
+		return &NetworkError{Endpoint: "ws", Err: err}
+	}
+	return nil
+}
+
+func maskBytes(mask [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= mask[i%4]
+	}
+}
+
+//close 发送close帧后关闭连接，可以在readMessage阻塞时调用
+func (ws *wsConn) close() error {
+	ws.writeFrame(WS_OP_CLOSE, []byte{0x03, 0xe8})
+	return ws.conn.Close()
+}